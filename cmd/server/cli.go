@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+	"github.com/mguptahub/nanodns/pkg/config"
+)
+
+// legacyCommands maps the flags older versions used as commands to the
+// commands replacing them.
+var legacyCommands = map[string]string{
+	"start":   "start",
+	"stop":    "stop",
+	"status":  "status",
+	"logs":    "logs",
+	"version": "version",
+	"v":       "version",
+	"help":    "help",
+	"h":       "help",
+}
+
+// parseCommand splits the command line into a command and its arguments.
+// Without a command the server runs in the foreground, so options such as
+// --port can be given on their own.
+func parseCommand(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "serve", nil
+	}
+	if !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
+	}
+	if command, ok := legacyCommands[strings.TrimLeft(args[0], "-")]; ok {
+		return command, args[1:]
+	}
+	return "serve", args
+}
+
+// envFile is the env file given with --env-file
+var envFile string
+
+// newFlagSet creates the flag set of a command with the options every
+// command has.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&envFile, "env-file", "", "Read the configuration from this env file instead of .env")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of nanodns %s:\n", name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// setup loads the configuration once the flags are parsed, and starts
+// logging.
+func setup() {
+	if envFile != "" {
+		os.Setenv("NANODNS_ENV_FILE", envFile)
+	}
+	config.Initialize()
+
+	if err := logging.Init(); err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
+	}
+}
+
+// parseFlags parses the options of a command, loads the configuration and
+// returns the remaining arguments.
+func parseFlags(fs *flag.FlagSet, args []string) []string {
+	_ = fs.Parse(args)
+	setup()
+	return fs.Args()
+}
+
+// parseServerFlags is parseFlags for the commands that run a server.
+func parseServerFlags(fs *flag.FlagSet, args []string) *serverFlags {
+	flags := &serverFlags{}
+	flags.register(fs)
+	_ = fs.Parse(args)
+	flags.apply()
+	setup()
+	return flags
+}
+
+// serverFlags are the options of the commands that run a server. They take
+// precedence over the env file and the environment.
+type serverFlags struct {
+	port    string
+	bind    string
+	records string
+}
+
+func (f *serverFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.port, "port", "", "Listen on this port (overrides DNS_PORT)")
+	fs.StringVar(&f.bind, "bind", "", "Listen on these comma-separated addresses only (overrides DNS_BIND)")
+	fs.StringVar(&f.records, "records", "", "Read additional records from this env file, overriding the env file")
+}
+
+// apply sets the environment variables the flags override. It must run
+// before setup.
+func (f *serverFlags) apply() {
+	if f.port != "" {
+		os.Setenv("DNS_PORT", f.port)
+	}
+	if f.bind != "" {
+		os.Setenv("DNS_BIND", f.bind)
+	}
+	if f.records != "" {
+		os.Setenv("NANODNS_RECORDS_FILE", f.records)
+	}
+}
+
+// args returns the flags to start a daemon with the same options. Paths are
+// made absolute, as the daemon may not share the working directory.
+func (f *serverFlags) args() []string {
+	var args []string
+	if envFile != "" {
+		args = append(args, "--env-file", absPath(envFile))
+	}
+	if f.port != "" {
+		args = append(args, "--port", f.port)
+	}
+	if f.bind != "" {
+		args = append(args, "--bind", f.bind)
+	}
+	if f.records != "" {
+		args = append(args, "--records", absPath(f.records))
+	}
+	return args
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+func printUsage() {
+	fmt.Println("")
+	fmt.Println("Usage: nanodns [command] [options]")
+	fmt.Println("")
+	fmt.Println("commands:")
+	fmt.Println("  serve                              Run the server in the foreground (default)")
+	fmt.Println("  start                              Run the server as a daemon")
+	fmt.Println("  stop                               Stop the running daemon service")
+	fmt.Println("  stop --timeout 30s                 Wait up to 30s for the server to exit, then kill it")
+	fmt.Println("  restart                            Stop the daemon if it is running and start it again")
+	fmt.Println("  status                             Show the PID, uptime, listeners and version of the server")
+	fmt.Println("  logs                               Show service logs")
+	fmt.Println("  logs -a                            Show action logs")
+	fmt.Println("  logs -q                            Show the query log")
+	fmt.Println("  logs --since 1h                    Show lines written in the last hour (0 for all)")
+	fmt.Println("  logs -f | --follow                 Keep showing new lines")
+	fmt.Println("  logs --grep PATTERN                Show lines matching a regular expression")
+	fmt.Println("  logs --domain NAME                 Show lines mentioning a domain")
+	fmt.Println("  logs -n | --lines N                Show the last N lines")
+	fmt.Println("  healthcheck                        Exit non-zero unless the local DNS server answers")
+	fmt.Println("  reload                             Reload the records, views and policies")
+	fmt.Println("  records list                       List the loaded records")
+	fmt.Println("  stats                              Show query statistics")
+	fmt.Println("  cache flush [name]                 Flush the relay cache, or only one name")
+	fmt.Println("  upstreams                          Check the upstream nameservers")
+	fmt.Println("  blocklist                          Show the blocklists and when they were loaded")
+	fmt.Println("  blocklist refresh                  Load the blocklists again")
+	fmt.Println("  loglevel [level]                   Show or set the log level (debug, info, warn, error)")
+	fmt.Println("  install-service                    Write systemd units running the server with socket activation")
+	fmt.Println("  install-service --user NAME        Run the service as NAME instead of a user allocated by systemd")
+	fmt.Println("  install-service --dir DIR          Write the units to DIR instead of /etc/systemd/system")
+	fmt.Println("  version                            Show the binary version")
+	fmt.Println("  help                               Show the help information")
+	fmt.Println("")
+	fmt.Println("options of serve, start, restart, healthcheck and install-service:")
+	fmt.Println("  --port PORT                        Listen on this port (overrides DNS_PORT)")
+	fmt.Println("  --bind ADDRESSES                   Listen on these addresses only (overrides DNS_BIND)")
+	fmt.Println("  --records FILE                     Read additional records from this env file")
+	fmt.Println("")
+	fmt.Println("options of every command:")
+	fmt.Println("  --env-file FILE                    Read the configuration from this env file instead of .env")
+}
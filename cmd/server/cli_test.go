@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		wantCommand string
+		wantArgs    []string
+	}{
+		{"no arguments serves", nil, "serve", nil},
+		{"options without a command serve", []string{"--port", "5353"}, "serve", []string{"--port", "5353"}},
+		{"command", []string{"start", "--port", "5353"}, "start", []string{"--port", "5353"}},
+		{"admin command", []string{"cache", "flush", "example.com"}, "cache", []string{"flush", "example.com"}},
+		{"legacy flag", []string{"-stop"}, "stop", []string{}},
+		{"legacy logs flag", []string{"--logs", "-duration", "1h"}, "logs", []string{"-duration", "1h"}},
+		{"legacy version flag", []string{"-v"}, "version", []string{}},
+		{"legacy help flag", []string{"--help"}, "help", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, args := parseCommand(tt.args)
+			if command != tt.wantCommand {
+				t.Errorf("parseCommand() command = %q, want %q", command, tt.wantCommand)
+			}
+			if len(args) != 0 || len(tt.wantArgs) != 0 {
+				if !reflect.DeepEqual(args, tt.wantArgs) {
+					t.Errorf("parseCommand() args = %q, want %q", args, tt.wantArgs)
+				}
+			}
+		})
+	}
+}
+
+func TestServerFlagsArgs(t *testing.T) {
+	fs := newFlagSet("start")
+	var flags serverFlags
+	flags.register(fs)
+	defer func() { envFile = "" }()
+
+	if err := fs.Parse([]string{"--port", "5353", "--env-file", "dev.env", "--records", "/etc/nanodns/records.env", "--bind", "127.0.0.53"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	abs, _ := filepath.Abs("dev.env")
+	want := []string{"--env-file", abs, "--port", "5353", "--bind", "127.0.0.53", "--records", "/etc/nanodns/records.env"}
+	args := flags.args()
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args() = %q, want %q", args, want)
+	}
+
+	// The daemon parses the options it is started with back
+	child := newFlagSet("serve")
+	var childFlags serverFlags
+	childFlags.register(child)
+	if err := child.Parse(args); err != nil {
+		t.Fatalf("Parse() of args() error = %v", err)
+	}
+	if childFlags != flags || envFile != abs {
+		t.Errorf("Daemon options = %+v (env file %s), want %+v", childFlags, envFile, flags)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mguptahub/nanodns/internal/control"
+	"github.com/mguptahub/nanodns/internal/dns"
+	"github.com/mguptahub/nanodns/internal/logging"
+	"github.com/mguptahub/nanodns/internal/metrics"
+	"github.com/mguptahub/nanodns/pkg/config"
+)
+
+// admin runs the commands received on the control socket.
+type admin struct {
+	handler   *dns.Handler
+	listeners []string
+	started   time.Time
+	mu        sync.Mutex // serializes reloads
+}
+
+// startControlServer serves the admin commands for handler, which answers on
+// listeners, on the configured control socket.
+func startControlServer(handler *dns.Handler, listeners []string) (*control.Server, error) {
+	a := &admin{handler: handler, listeners: listeners, started: time.Now()}
+
+	server := control.NewServer()
+	server.Register("status", a.status)
+	server.Register("reload", a.reload)
+	server.Register("records", a.records)
+	server.Register("stats", a.stats)
+	server.Register("cache", a.cache)
+	server.Register("upstreams", a.upstreams)
+	server.Register("blocklist", a.blocklist)
+	server.Register("loglevel", a.loglevel)
+
+	path := config.GetControlSocket()
+	if err := server.Listen(path); err != nil {
+		return nil, err
+	}
+	logging.LogService(fmt.Sprintf("Listening for admin commands on %s", path))
+	return server, nil
+}
+
+// status reports what the running server is and where it listens.
+func (a *admin) status(args []string) (string, error) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "PID:        %d\n", os.Getpid())
+	fmt.Fprintf(&sb, "Version:    %s\n", version)
+	fmt.Fprintf(&sb, "Uptime:     %s\n", time.Since(a.started).Round(time.Second))
+	fmt.Fprintf(&sb, "Listeners:  %s\n", strings.Join(a.listeners, ", "))
+	fmt.Fprintf(&sb, "Records:    %d\n", len(a.handler.Records()))
+	return sb.String(), nil
+}
+
+// reload reads the env file again and replaces the records, views and
+// answer policies. The server keeps its current records if that fails.
+func (a *admin) reload(args []string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	err := config.ReloadEnvFile()
+	var records map[string][]dns.DNSRecord
+	if err == nil {
+		records = dns.LoadRecords()
+		err = a.handler.Reload(records, config.GetViews(), dns.LoadPolicies())
+	}
+	if err != nil {
+		metrics.SetReloadStatus(false)
+		logging.LogAction("RELOAD_FAILED", err.Error())
+		return "", fmt.Errorf("reload failed, keeping the current records: %w", err)
+	}
+
+	counts := dns.CountByType(records)
+	metrics.SetRecordsLoaded(counts)
+	metrics.SetReloadStatus(true)
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	logging.LogAction("RELOAD_SUCCESS", fmt.Sprintf("Loaded %d records", total))
+	return fmt.Sprintf("Reloaded %d records", total), nil
+}
+
+// records lists the loaded records.
+func (a *admin) records(args []string) (string, error) {
+	if len(args) > 0 && args[0] != "list" {
+		return "", fmt.Errorf("unknown records command %q, expected list", args[0])
+	}
+
+	var sb strings.Builder
+	tw := tabwriter.NewWriter(&sb, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOMAIN\tTYPE\tVALUE\tTTL\tVIEW")
+	for _, rec := range a.handler.Records() {
+		value := rec.Value
+		switch {
+		case rec.RecordType == dns.MXRecord:
+			value = fmt.Sprintf("%d %s", rec.Priority, value)
+		case rec.IsService:
+			value = config.ServicePrefix + value
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", rec.Domain, rec.RecordType, value, rec.TTL, rec.View)
+	}
+	tw.Flush()
+	return sb.String(), nil
+}
+
+// stats summarizes the queries answered since the server started.
+func (a *admin) stats(args []string) (string, error) {
+	bySource := metrics.QueriesBy("source")
+	var total float64
+	for _, n := range bySource {
+		total += n
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Uptime:          %s\n", time.Since(a.started).Round(time.Second))
+	fmt.Fprintf(&sb, "Records:         %d\n", len(a.handler.Records()))
+	fmt.Fprintf(&sb, "Queries:         %.0f\n", total)
+	fmt.Fprintf(&sb, "  by source:     %s\n", formatCounts(bySource))
+	fmt.Fprintf(&sb, "  by type:       %s\n", formatCounts(metrics.QueriesBy("qtype")))
+	fmt.Fprintf(&sb, "  by rcode:      %s\n", formatCounts(metrics.QueriesBy("rcode")))
+	fmt.Fprintf(&sb, "Relay errors:    %s\n", formatCounts(metrics.RelayErrors()))
+	fmt.Fprintf(&sb, "Cached answers:  %d\n", a.handler.CachedAnswers())
+	return sb.String(), nil
+}
+
+// cache flushes the relay cache, or only the answers for one name.
+func (a *admin) cache(args []string) (string, error) {
+	if len(args) == 0 || args[0] != "flush" || len(args) > 2 {
+		return "", fmt.Errorf("usage: cache flush [name]")
+	}
+
+	var name string
+	if len(args) == 2 {
+		name = args[1]
+	}
+	n, err := a.handler.FlushCache(name)
+	if err != nil {
+		return "", err
+	}
+	logging.LogAction("CACHE_FLUSH", fmt.Sprintf("Removed %d cached answers for %q", n, name))
+	return fmt.Sprintf("Removed %d cached answers", n), nil
+}
+
+// upstreams probes the upstream nameservers.
+func (a *admin) upstreams(args []string) (string, error) {
+	statuses := a.handler.Upstreams()
+	if statuses == nil {
+		return "DNS relay is disabled\n", nil
+	}
+
+	var sb strings.Builder
+	tw := tabwriter.NewWriter(&sb, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tSTATUS\tRTT\tERROR")
+	for _, s := range statuses {
+		if s.Err != nil {
+			fmt.Fprintf(tw, "%s\tdown\t-\t%v\n", s.Server, s.Err)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\tup\t%s\t\n", s.Server, s.RTT.Round(time.Microsecond))
+	}
+	tw.Flush()
+	return sb.String(), nil
+}
+
+// blocklist describes the blocklists, or loads them again in the background,
+// as downloads may take longer than an admin command may.
+func (a *admin) blocklist(args []string) (string, error) {
+	if len(args) > 1 || len(args) == 1 && args[0] != "refresh" {
+		return "", fmt.Errorf("usage: blocklist [refresh]")
+	}
+	statuses := a.handler.Blocklists()
+	if statuses == nil {
+		return "Blocklists are disabled\n", nil
+	}
+	if len(args) == 1 {
+		logging.LogAction("BLOCKLIST_REFRESH", fmt.Sprintf("Loading %d blocklists", len(statuses)))
+		go a.handler.RefreshBlocklists()
+		return fmt.Sprintf("Loading %d blocklists, see nanodns blocklist for the result\n", len(statuses)), nil
+	}
+
+	var sb strings.Builder
+	tw := tabwriter.NewWriter(&sb, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tDOMAINS\tUPDATED\tERROR")
+	for _, s := range statuses {
+		updated, failure := "-", ""
+		if !s.Updated.IsZero() {
+			updated = s.Updated.Format(time.DateTime)
+		}
+		if s.Err != nil {
+			failure = s.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", s.Source, s.Domains, updated, failure)
+	}
+	tw.Flush()
+	return sb.String(), nil
+}
+
+// loglevel shows the log level, or changes it.
+func (a *admin) loglevel(args []string) (string, error) {
+	if len(args) == 0 {
+		return fmt.Sprintf("Log level is %s\n", logging.GetLevel()), nil
+	}
+
+	level, err := logging.ParseLevel(args[0])
+	if err != nil {
+		return "", err
+	}
+	logging.SetLevel(level)
+	logging.LogAction("LOG_LEVEL", fmt.Sprintf("Log level set to %s", level))
+	return fmt.Sprintf("Log level set to %s\n", level), nil
+}
+
+// formatCounts formats counts as "key=count" pairs sorted by key.
+func formatCounts(counts map[string]float64) string {
+	if len(counts) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=%.0f", key, counts[key])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// runAdminCommand sends a command to the running server and prints its
+// output.
+func runAdminCommand(command string, args []string) {
+	output, err := control.Call(config.GetControlSocket(), command, args...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+		os.Exit(1)
+	}
+	fmt.Print(output)
+	if output != "" && !strings.HasSuffix(output, "\n") {
+		fmt.Println()
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mguptahub/nanodns/internal/dns"
+	"github.com/mguptahub/nanodns/internal/logging"
+	"github.com/mguptahub/nanodns/pkg/config"
+)
+
+func TestFormatCounts(t *testing.T) {
+	tests := []struct {
+		counts map[string]float64
+		want   string
+	}{
+		{nil, "-"},
+		{map[string]float64{"relay": 2, "local": 10}, "local=10 relay=2"},
+	}
+	for _, tt := range tests {
+		if got := formatCounts(tt.counts); got != tt.want {
+			t.Errorf("formatCounts(%v) = %q, want %q", tt.counts, got, tt.want)
+		}
+	}
+}
+
+func TestAdminCommands(t *testing.T) {
+	records := map[string][]dns.DNSRecord{
+		"mail.example.com.": {
+			{Domain: "mail.example.com.", Value: "mx.example.com.", TTL: 300, RecordType: dns.MXRecord, Priority: 10},
+		},
+	}
+	handler, err := dns.NewHandler(records, config.RelayConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer handler.Close()
+	a := &admin{handler: handler, listeners: []string{":10053/udp"}}
+
+	output, err := a.status(nil)
+	if err != nil || !strings.Contains(output, "Listeners:  :10053/udp") || !strings.Contains(output, "Version:    "+version) {
+		t.Errorf("status = %q, %v, want the listeners and version", output, err)
+	}
+
+	output, err = a.records([]string{"list"})
+	if err != nil || !strings.Contains(output, "10 mx.example.com.") {
+		t.Errorf("records list = %q, %v, want the MX record with its priority", output, err)
+	}
+	if _, err := a.records([]string{"delete"}); err == nil {
+		t.Error("records delete succeeded")
+	}
+	if _, err := a.cache([]string{"clear"}); err == nil {
+		t.Error("cache clear succeeded")
+	}
+	if _, err := a.cache([]string{"flush"}); err == nil {
+		t.Error("cache flush without a relay cache succeeded")
+	}
+	if output, _ := a.upstreams(nil); !strings.Contains(output, "disabled") {
+		t.Errorf("upstreams = %q, want relay disabled", output)
+	}
+	if output, _ := a.blocklist(nil); !strings.Contains(output, "disabled") {
+		t.Errorf("blocklist = %q, want blocklists disabled", output)
+	}
+	if _, err := a.blocklist([]string{"clear"}); err == nil {
+		t.Error("blocklist clear succeeded")
+	}
+
+	defer logging.SetLevel(logging.GetLevel())
+	if _, err := a.loglevel([]string{"debug"}); err != nil {
+		t.Fatalf("loglevel debug error = %v", err)
+	}
+	if logging.GetLevel() != logging.LevelDebug {
+		t.Errorf("Log level = %s, want debug", logging.GetLevel())
+	}
+	if _, err := a.loglevel([]string{"loud"}); err == nil {
+		t.Error("loglevel loud succeeded")
+	}
+}
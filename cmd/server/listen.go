@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	externaldns "github.com/miekg/dns"
+)
+
+// listen binds the sockets of listeners and returns a server answering on
+// each with the handler handlers returns for its listener. UDP listeners
+// open udpSockets sockets sharing their address with SO_REUSEPORT, each read
+// by its own server. Binding up front reports an address in use before
+// anything is served; if one listener fails, the sockets already bound are
+// closed.
+func listen(listeners []config.Listener, udpSockets int, handlers func(config.Listener) externaldns.Handler) ([]*externaldns.Server, error) {
+	servers := make([]*externaldns.Server, 0, len(listeners))
+	for _, l := range listeners {
+		handler := handlers(l)
+		var bound []*externaldns.Server
+		var err error
+		switch l.Net {
+		case config.ProtocolUDP:
+			bound, err = listenUDP(l.Addr, udpSockets, handler)
+		case config.ProtocolTCP:
+			var listener net.Listener
+			if listener, err = net.Listen("tcp", l.Addr); err == nil {
+				bound = []*externaldns.Server{{Addr: l.Addr, Net: l.Net, Listener: listener, Handler: handler}}
+			}
+		default:
+			err = fmt.Errorf("unknown protocol %q", l.Net)
+		}
+		if err != nil {
+			closeSockets(servers)
+			return nil, fmt.Errorf("failed to listen on %s: %w", l, err)
+		}
+		servers = append(servers, bound...)
+	}
+	return servers, nil
+}
+
+// listenUDP opens n sockets on addr, sharing it with SO_REUSEPORT if n is
+// more than one.
+func listenUDP(addr string, n int, handler externaldns.Handler) ([]*externaldns.Server, error) {
+	var lc net.ListenConfig
+	if n > 1 {
+		lc.Control = reusePort
+	}
+
+	servers := make([]*externaldns.Server, 0, n)
+	for range n {
+		pc, err := lc.ListenPacket(context.Background(), "udp", addr)
+		if err != nil {
+			closeSockets(servers)
+			return nil, err
+		}
+		// The other sockets join the port the first one got, which matters
+		// for port 0
+		addr = pc.LocalAddr().String()
+		servers = append(servers, &externaldns.Server{Addr: addr, Net: "udp", PacketConn: pc, Handler: handler})
+	}
+	return servers, nil
+}
+
+// activated returns a server on each socket passed by systemd socket
+// activation, answering with the handler handlers returns for its listener,
+// and the listeners they are. The listeners are named as in listenerName.
+func activated(files []*os.File, configured []config.Listener, handlers func(config.Listener) externaldns.Handler) ([]*externaldns.Server, []config.Listener, error) {
+	var servers []*externaldns.Server
+	var listeners []config.Listener
+	for _, f := range files {
+		server, err := activatedServer(f)
+		f.Close() // the server has its own copy of the descriptor
+		if err != nil {
+			closeSockets(servers)
+			return nil, nil, err
+		}
+		l := config.Listener{Addr: server.Addr, Net: server.Net}
+		l.Name = listenerName(f.Name(), l, configured)
+		server.Handler = handlers(l)
+		servers = append(servers, server)
+		listeners = append(listeners, l)
+	}
+	return servers, listeners, nil
+}
+
+// listenerName returns the name of the listener l on a socket passed by
+// systemd: the socket's FileDescriptorName if it is a valid listener name, or
+// else the name of the configured listener with the same address and
+// protocol, which the sockets install-service writes have.
+func listenerName(fdName string, l config.Listener, configured []config.Listener) string {
+	// LISTEN_FD_<n> stands in for the names of systemd versions without them
+	if config.ValidListenerName(fdName) && !strings.HasPrefix(fdName, "LISTEN_FD_") {
+		return fdName
+	}
+	for _, c := range configured {
+		if c.Net == l.Net && sameAddr(l.Addr, c.Addr) {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+// sameAddr reports whether the bound address matches the configured one,
+// whose empty host stands for all addresses.
+func sameAddr(bound, configured string) bool {
+	addr, err := netip.ParseAddrPort(bound)
+	if err != nil {
+		return false
+	}
+	host, port, err := net.SplitHostPort(configured)
+	if err != nil || port != strconv.Itoa(int(addr.Port())) {
+		return false
+	}
+	if host == "" {
+		return addr.Addr().IsUnspecified()
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.Unmap() == addr.Addr().Unmap()
+}
+
+func activatedServer(f *os.File) (*externaldns.Server, error) {
+	if pc, err := net.FilePacketConn(f); err == nil {
+		if _, ok := pc.LocalAddr().(*net.UDPAddr); ok {
+			return &externaldns.Server{Addr: pc.LocalAddr().String(), Net: config.ProtocolUDP, PacketConn: pc}, nil
+		}
+		pc.Close()
+	} else if listener, err := net.FileListener(f); err == nil {
+		if _, ok := listener.Addr().(*net.TCPAddr); ok {
+			return &externaldns.Server{Addr: listener.Addr().String(), Net: config.ProtocolTCP, Listener: listener}, nil
+		}
+		listener.Close()
+	}
+	return nil, fmt.Errorf("socket %s passed by systemd is neither UDP nor TCP", f.Name())
+}
+
+// closeSockets closes the sockets of servers, which stops them without
+// waiting for queries in flight.
+func closeSockets(servers []*externaldns.Server) {
+	for _, server := range servers {
+		if server.PacketConn != nil {
+			server.PacketConn.Close()
+		}
+		if server.Listener != nil {
+			server.Listener.Close()
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mguptahub/nanodns/internal/dns"
+	"github.com/mguptahub/nanodns/pkg/config"
+	externaldns "github.com/miekg/dns"
+)
+
+// serveWith answers on every listener with handler.
+func serveWith(handler externaldns.Handler) func(config.Listener) externaldns.Handler {
+	return func(config.Listener) externaldns.Handler { return handler }
+}
+
+func TestListen(t *testing.T) {
+	echo := externaldns.HandlerFunc(func(w externaldns.ResponseWriter, r *externaldns.Msg) {
+		m := new(externaldns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	})
+
+	servers, err := listen([]config.Listener{
+		{Addr: "127.0.0.1:0", Net: config.ProtocolUDP},
+		{Addr: "127.0.0.1:0", Net: config.ProtocolTCP},
+	}, 1, serveWith(echo))
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+	udpAddr := servers[0].PacketConn.LocalAddr().String()
+	tcpAddr := servers[1].Listener.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, servers, time.Second)
+	}()
+	defer func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("serve() error = %v", err)
+		}
+	}()
+
+	for network, addr := range map[string]string{"udp": udpAddr, "tcp": tcpAddr} {
+		m := new(externaldns.Msg)
+		m.SetQuestion("example.com.", externaldns.TypeA)
+		client := &externaldns.Client{Net: network, Timeout: 2 * time.Second}
+		if _, _, err := client.Exchange(m, addr); err != nil {
+			t.Errorf("Query over %s failed: %v", network, err)
+		}
+	}
+}
+
+func TestListenClosesSocketsOnError(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+
+	// The second listener fails on the port the first one holds
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	_, err = listen([]config.Listener{
+		{Addr: addr, Net: config.ProtocolUDP},
+		{Addr: addr, Net: config.ProtocolUDP},
+	}, 1, serveWith(nil))
+	if err == nil {
+		t.Fatal("listen() on an address in use succeeded")
+	}
+
+	// The first socket was closed, so the port is free again
+	pc, err = net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatalf("Socket bound before the error is still open: %v", err)
+	}
+	pc.Close()
+}
+
+func TestListenReusePort(t *testing.T) {
+	if !reusePortSupported {
+		t.Skip("SO_REUSEPORT load balancing not supported")
+	}
+
+	servers, err := listen([]config.Listener{{Addr: "127.0.0.1:0", Net: config.ProtocolUDP}}, 4, serveWith(nil))
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+	defer closeSockets(servers)
+
+	if len(servers) != 4 {
+		t.Fatalf("listen() opened %d sockets, want 4", len(servers))
+	}
+	addr := servers[0].PacketConn.LocalAddr().String()
+	for _, server := range servers[1:] {
+		if got := server.PacketConn.LocalAddr().String(); got != addr {
+			t.Errorf("Socket bound to %s, want %s", got, addr)
+		}
+	}
+}
+
+// BenchmarkServeUDP measures the queries per second answered over UDP with
+// one socket and with a socket per CPU, queried by many clients at once.
+func BenchmarkServeUDP(b *testing.B) {
+	records := map[string][]dns.DNSRecord{
+		"app.example.com.": {{Domain: "app.example.com.", RecordType: dns.ARecord, Value: "10.0.0.1", TTL: 60}},
+	}
+	handler, err := dns.NewHandler(records, config.RelayConfig{})
+	if err != nil {
+		b.Fatalf("NewHandler() error = %v", err)
+	}
+	defer handler.Close()
+
+	counts := []int{1}
+	if reusePortSupported && runtime.GOMAXPROCS(0) > 1 {
+		counts = append(counts, runtime.GOMAXPROCS(0))
+	}
+	for _, sockets := range counts {
+		b.Run(fmt.Sprintf("sockets=%d", sockets), func(b *testing.B) {
+			benchmarkServeUDP(b, sockets, externaldns.HandlerFunc(handler.ServeDNS))
+		})
+	}
+}
+
+func benchmarkServeUDP(b *testing.B, sockets int, handler externaldns.Handler) {
+	servers, err := listen([]config.Listener{{Addr: "127.0.0.1:0", Net: config.ProtocolUDP}}, sockets, serveWith(handler))
+	if err != nil {
+		b.Fatalf("listen() error = %v", err)
+	}
+	addr := servers[0].PacketConn.LocalAddr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, servers, time.Second)
+	}()
+	defer func() {
+		cancel()
+		<-served
+	}()
+
+	query := new(externaldns.Msg)
+	query.SetQuestion("app.example.com.", externaldns.TypeA)
+
+	b.SetParallelism(4)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// Every client has its own source port, which the kernel hashes
+		// to pick a socket
+		conn, err := externaldns.Dial("udp", addr)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer conn.Close()
+		for pb.Next() {
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			if err := conn.WriteMsg(query); err != nil {
+				b.Error(err)
+				return
+			}
+			if _, err := conn.ReadMsg(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "queries/s")
+}
+
+func TestActivated(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	defer pc.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+	unix, err := net.ListenPacket("unixgram", filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	defer unix.Close()
+
+	udpFile, _ := pc.(*net.UDPConn).File()
+	tcpFile, _ := ln.(*net.TCPListener).File()
+	servers, listeners, err := activated([]*os.File{udpFile, tcpFile}, nil, serveWith(nil))
+	if err != nil {
+		t.Fatalf("activated() error = %v", err)
+	}
+	defer closeSockets(servers)
+
+	want := []config.Listener{
+		{Addr: pc.LocalAddr().String(), Net: config.ProtocolUDP},
+		{Addr: ln.Addr().String(), Net: config.ProtocolTCP},
+	}
+	if !reflect.DeepEqual(listeners, want) {
+		t.Errorf("activated() listeners = %v, want %v", listeners, want)
+	}
+	if servers[0].PacketConn == nil || servers[1].Listener == nil {
+		t.Errorf("activated() servers don't hold the sockets")
+	}
+
+	unixFile, _ := unix.(*net.UnixConn).File()
+	if _, _, err := activated([]*os.File{unixFile}, nil, serveWith(nil)); err == nil {
+		t.Error("activated() with a Unix socket succeeded")
+	}
+}
+
+func TestListenerName(t *testing.T) {
+	configured := []config.Listener{
+		{Name: "lan", Addr: "10.0.0.5:53", Net: config.ProtocolUDP},
+		{Name: "lan", Addr: "10.0.0.5:53", Net: config.ProtocolTCP},
+		{Name: "all", Addr: ":5353", Net: config.ProtocolUDP},
+		{Addr: "127.0.0.1:53", Net: config.ProtocolUDP},
+	}
+
+	tests := []struct {
+		name   string
+		fdName string
+		l      config.Listener
+		want   string
+	}{
+		{"FileDescriptorName", "public", config.Listener{Addr: "10.0.0.5:53", Net: config.ProtocolUDP}, "public"},
+		{"unit name", "nanodns.socket", config.Listener{Addr: "10.0.0.5:53", Net: config.ProtocolTCP}, "lan"},
+		{"unnamed fd", "LISTEN_FD_3", config.Listener{Addr: "10.0.0.5:53", Net: config.ProtocolUDP}, "lan"},
+		{"all addresses", "nanodns.socket", config.Listener{Addr: "[::]:5353", Net: config.ProtocolUDP}, "all"},
+		{"other protocol", "nanodns.socket", config.Listener{Addr: "[::]:5353", Net: config.ProtocolTCP}, ""},
+		{"unnamed listener", "nanodns.socket", config.Listener{Addr: "127.0.0.1:53", Net: config.ProtocolUDP}, ""},
+		{"unknown address", "nanodns.socket", config.Listener{Addr: "192.0.2.1:53", Net: config.ProtocolUDP}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := listenerName(tt.fdName, tt.l, configured); got != tt.want {
+				t.Errorf("listenerName(%q, %v) = %q, want %q", tt.fdName, tt.l, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,208 +1,410 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
-	"strconv"
+	"os/signal"
+	"regexp"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/mguptahub/nanodns/internal/control"
+	"github.com/mguptahub/nanodns/internal/daemon"
 	"github.com/mguptahub/nanodns/internal/dns"
+	"github.com/mguptahub/nanodns/internal/dnstap"
 	"github.com/mguptahub/nanodns/internal/logging"
+	"github.com/mguptahub/nanodns/internal/metrics"
 	"github.com/mguptahub/nanodns/pkg/config"
 	externaldns "github.com/miekg/dns"
 )
 
-const pidFilePath = "/tmp/nanodns.pid"
+// defaultStopTimeout is how long stop waits before killing the server. It
+// leaves the server time to drain queries for the default SHUTDOWN_TIMEOUT.
+const defaultStopTimeout = 15 * time.Second
 
-var logDuration time.Duration
+// startTimeout is how long start waits for the daemon to take its PID file
+const startTimeout = 10 * time.Second
 
-var (
-	version      = "dev" // Default version; can be overridden at build time
-	startService bool
-	stopService  bool
-	showVersion  bool
-	showHelp     bool
-	showStatus   bool
-	showLogs     bool
-)
+// defaultLogDuration is how far back logs shows lines by default
+const defaultLogDuration = 24 * time.Hour
 
-func init() {
-	flag.DurationVar(&logDuration, "duration", 24*time.Hour, "Duration of logs to show (e.g., 72h, 7d)")
-
-	flag.BoolVar(&startService, "start", false, "Run the binary as a daemon")
-	flag.BoolVar(&stopService, "stop", false, "Stop the running daemon service")
-	flag.BoolVar(&showStatus, "status", false, "Show service status")
-	flag.BoolVar(&showVersion, "version", false, "Show the binary version")
-	flag.BoolVar(&showVersion, "v", false, "Show the binary version (short)")
-	flag.BoolVar(&showHelp, "help", false, "Shows help information")
-	flag.BoolVar(&showHelp, "h", false, "Shows help information (short)")
-
-	flag.BoolVar(&showLogs, "logs", false, "Show the logs")
-
-	// Custom usage function
-	flag.Usage = func() {
-		fmt.Println("")
-		fmt.Println("Usage: nanodns [command | options]")
-		fmt.Println("")
-		fmt.Println("commands:")
-		fmt.Println("  start                              Run the binary as a daemon")
-		fmt.Println("  stop                               Stop the running daemon service")
-		fmt.Println("  status                             Show service status")
-		fmt.Println("  logs                               Show service logs")
-		fmt.Println("  logs -a                            Show action logs")
-		fmt.Println("")
-		fmt.Println("options:")
-		fmt.Println("  -v | --version                     Show the binary version")
-		fmt.Println("  -h | --help                        Show the help information")
-	}
-
-	config.Initialize()
-
-	// Initialize logging system
-	if err := logging.Init(); err != nil {
-		log.Fatalf("Failed to initialize logging: %v", err)
-	}
-}
+var version = "dev" // Default version; can be overridden at build time
+
+// daemonEnv is the environment nanodns was started with. The daemon gets it
+// instead of the variables loaded from the env file, so it can reload them.
+var daemonEnv []string
 
 func main() {
-	flag.Parse()
-	// fmt.Println(flag.Args())
-	if len(flag.Args()) > 0 {
-		switch flag.Arg(0) {
-		case "start":
-			startDaemon()
-			return
-		case "stop":
-			stopDaemon()
-			return
-		case "status":
-			checkServiceStatus()
-			return
-		case "logs":
-			showSelectiveLogs()
-			return
-		case "help":
-			flag.Usage()
-			return
-		case "version", "v":
-			printVersion()
-			return
-		default:
-			flag.Usage()
-			return
+	daemonEnv = os.Environ()
+
+	command, args := parseCommand(os.Args[1:])
+	switch command {
+	case "serve":
+		parseServerFlags(newFlagSet(command), args)
+		if err := startDNSServer(); err != nil {
+			logging.Errorf("DNS server failed: %v", err)
+			os.Exit(1)
 		}
+	case "start":
+		startDaemon(parseServerFlags(newFlagSet(command), args))
+	case "restart":
+		fs := newFlagSet(command)
+		timeout := fs.Duration("timeout", defaultStopTimeout, "How long to wait for the server to exit before killing it")
+		flags := parseServerFlags(fs, args)
+		restartDaemon(flags, *timeout)
+	case "stop":
+		fs := newFlagSet(command)
+		timeout := fs.Duration("timeout", defaultStopTimeout, "How long to wait for the server to exit before killing it")
+		parseFlags(fs, args)
+		stopDaemon(*timeout)
+	case "status":
+		parseFlags(newFlagSet(command), args)
+		checkServiceStatus()
+	case "logs":
+		showSelectiveLogs(args)
+	case "healthcheck":
+		parseServerFlags(newFlagSet(command), args)
+		runHealthCheck()
+	case "install-service":
+		fs := newFlagSet(command)
+		user := fs.String("user", "", "Run the service as this user instead of one allocated by systemd")
+		group := fs.String("group", "", "Run the service with this group")
+		dir := fs.String("dir", defaultUnitDir, "Write the units to this directory")
+		if os.Getenv("NANODNS_ENV_FILE") == "" {
+			os.Setenv("NANODNS_ENV_FILE", defaultServiceEnvFile)
+		}
+		flags := parseServerFlags(fs, args)
+		installService(flags, *user, *group, *dir)
+	case "reload", "records", "stats", "cache", "upstreams", "blocklist", "loglevel":
+		runAdminCommand(command, parseFlags(newFlagSet(command), args))
+	case "version":
+		printVersion()
+	case "help":
+		printUsage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", command)
+		printUsage()
+		os.Exit(2)
 	}
+}
 
-	if showHelp {
-		flag.Usage()
-		return
+// startDNSServer serves DNS until SIGTERM or SIGINT, then shuts down
+// gracefully: queries in flight are answered, the listeners, control socket
+// and outputs are closed, and the logs are flushed last.
+func startDNSServer() error {
+	defer logging.Cleanup()
+
+	// Lock the PID file first, so a second server fails before binding
+	pidFile, err := daemon.AcquirePIDFile(config.GetPIDFile())
+	if err != nil {
+		return err
+	}
+	defer pidFile.Release()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	logging.LogService("Initializing DNS server")
+
+	// Rotate the logs in the background, and reopen them on SIGUSR1 for
+	// external tools such as logrotate
+	stopRotation := logging.StartRotation()
+	defer stopRotation()
+	reopenLogsOnSignal()
+
+	// Load records from environment variables
+	records := dns.LoadRecords()
+	logging.LogService(fmt.Sprintf("Loaded %d DNS records", len(records)))
+
+	// Get relay configuration
+	relayConfig := config.GetRelayConfig()
+	if relayConfig.Enabled {
+		logging.LogService(fmt.Sprintf("DNS relay enabled, using nameservers: %v", relayConfig.Nameservers))
+	}
+	cacheSize := config.GetRelayCacheSize()
+	if relayConfig.Enabled && cacheSize > 0 {
+		logging.LogService(fmt.Sprintf("Caching up to %d relayed answers", cacheSize))
+	}
+
+	// Get split-horizon views
+	views := config.GetViews()
+	for _, view := range views {
+		logging.LogService(fmt.Sprintf("View %s serves clients in %v", view.Name, view.Networks))
+	}
+
+	opts := []dns.Option{
+		dns.WithWildcardCapture(config.GetWildcardCapture()),
+		dns.WithViews(views),
+		dns.WithECS(config.GetECSConfig()),
+		dns.WithPolicies(dns.LoadPolicies()),
+		dns.WithRelayCache(cacheSize),
+		dns.WithACL(config.GetACLConfig()),
+	}
+
+	// Open the optional dnstap output
+	if tap := openDnstap(config.GetDnstapConfig()); tap != nil {
+		defer tap.Close()
+		opts = append(opts, dns.WithDnstap(tap))
+	}
+
+	// Load the optional blocklists, the downloaded ones in the background
+	if blocklists := config.GetBlocklistConfig(); blocklists.Enabled() {
+		blocker := dns.NewBlocker(blocklists)
+		defer blocker.Close()
+		opts = append(opts, dns.WithBlocklist(blocker))
 	}
 
-	if startService {
-		if checkIfRunning() {
-			fmt.Println("")
-			fmt.Println("NanoDNS is already running.")
-			fmt.Println("")
-			return
+	// Create DNS handler
+	handler, err := dns.NewHandler(records, relayConfig, opts...)
+	if err != nil {
+		logging.Fatalf("Failed to create DNS handler: %v", err)
+	}
+	defer handler.Close()
+	metrics.SetRecordsLoaded(dns.CountByType(records))
+	metrics.SetReloadStatus(true)
+
+	// Bind every listener before serving on any, or take the sockets bound
+	// by systemd
+	servers, listeners, err := bindListeners(rateLimited(externaldns.HandlerFunc(handler.ServeDNS)))
+	if err != nil {
+		return err
+	}
+	state := newServerState(probeTarget(listeners))
+	state.handler.Store(handler)
+
+	// Start the optional HTTP server for metrics and health probes
+	if addr := config.GetHTTPAddr(); addr != "" {
+		httpServer := startHTTPServer(addr, state)
+		defer httpServer.Close()
+	}
+
+	var starting atomic.Int32
+	starting.Store(int32(len(servers)))
+	for _, server := range servers {
+		server.NotifyStartedFunc = func() {
+			if starting.Add(-1) == 0 {
+				state.listening.Store(true)
+			}
 		}
-		fmt.Println("")
-		fmt.Println("Running as daemon...")
-		fmt.Println("")
-		startDaemon()
-		return
 	}
 
-	if stopService {
-		stopDaemon()
-		return
+	// Serve admin commands such as reload on the control socket
+	names := make([]string, len(listeners))
+	for i, l := range listeners {
+		names[i] = l.String()
+	}
+	if ctl, err := startControlServer(handler, names); err != nil {
+		logging.Errorf("Failed to start control socket: %v", err)
+	} else {
+		defer ctl.Close()
 	}
 
-	if showStatus {
-		checkServiceStatus()
-		return
+	// Everything that needs root is done
+	if err := dropPrivileges(config.GetPIDFile(), config.GetControlSocket()); err != nil {
+		return err
 	}
 
-	if showLogs {
-		showServiceLogs()
-		return
+	logging.LogService(fmt.Sprintf("Starting DNS server on %s", strings.Join(names, ", ")))
+	err = serve(ctx, servers, config.GetShutdownTimeout())
+	state.listening.Store(false)
+	if ctx.Err() == nil {
+		return err
 	}
+	// Restore the default behavior so a second signal stops the process
+	// right away
+	stop()
+	if err != nil {
+		logging.Warnf("%v", err)
+	}
+	logging.LogService("DNS server stopped")
+	return nil
+}
 
-	if showVersion {
-		printVersion()
-		return
+// bindListeners returns the servers on the sockets passed by systemd socket
+// activation, or else on the listeners configured with DNS_BIND, answering
+// with the handler handlers returns for their listener, and the listeners
+// they are.
+func bindListeners(handlers func(config.Listener) externaldns.Handler) ([]*externaldns.Server, []config.Listener, error) {
+	if files := daemon.ListenFiles(); len(files) > 0 {
+		logging.LogService(fmt.Sprintf("Using %d sockets passed by systemd instead of binding DNS_BIND", len(files)))
+		// DNS_BIND still names the sockets bound at its addresses
+		configured, err := config.GetListeners()
+		if err != nil {
+			logging.Warnf("Not naming the sockets passed by systemd after DNS_BIND: %v", err)
+		}
+		servers, listeners, err := activated(files, configured, handlers)
+		if err == nil {
+			warnUnnamed(listeners)
+		}
+		return servers, listeners, err
 	}
 
-	if len(os.Args) == 1 {
-		startDNSServer()
+	listeners, err := config.GetListeners()
+	if err != nil {
+		return nil, nil, err
+	}
+	udpSockets := config.GetUDPSockets()
+	if udpSockets > 1 && !reusePortSupported {
+		logging.Warnf("DNS_REUSEPORT needs SO_REUSEPORT load balancing, which this system lacks; using one UDP socket")
+		udpSockets = 1
 	}
+	if udpSockets > 1 {
+		logging.LogService(fmt.Sprintf("Reading each UDP address with %d sockets", udpSockets))
+	}
+	servers, err := listen(listeners, udpSockets, handlers)
+	return servers, listeners, err
 }
 
-func startDNSServer() {
-	logging.LogService("Initializing DNS server")
+// warnUnnamed warns about the sockets passed by systemd that got no listener
+// name when per-listener settings are set, since those can't apply to them.
+func warnUnnamed(listeners []config.Listener) {
+	var settings []string
+	for _, env := range os.Environ() {
+		key, _, _ := strings.Cut(env, "=")
+		if strings.HasPrefix(key, "RRL_") ||
+			strings.HasPrefix(key, "RATE_LIMIT_") && !strings.HasSuffix(key, "_PREFIX") {
+			settings = append(settings, key)
+		}
+	}
+	if len(settings) == 0 {
+		return
+	}
+	for _, l := range listeners {
+		if l.Name == "" {
+			logging.Warnf("Socket %s passed by systemd has no listener name, so the per-listener settings %s don't apply to it; name it with FileDescriptorName or a DNS_BIND entry at its address", l, strings.Join(settings, ", "))
+		}
+	}
+}
 
-	// Load records from environment variables
-	records := dns.LoadRecords()
-	logging.LogService(fmt.Sprintf("Loaded %d DNS records", len(records)))
+// rateLimited returns the handler of each listener: handler behind the rate
+// limits set for the listener's name. Listeners with the same name, such as
+// the UDP and TCP ones of an address, share their limits.
+func rateLimited(handler externaldns.Handler) func(config.Listener) externaldns.Handler {
+	limited := make(map[string]externaldns.Handler)
+	return func(l config.Listener) externaldns.Handler {
+		if h, ok := limited[l.Name]; ok {
+			return h
+		}
+		cfg := config.GetRateLimitConfig(l.Name)
+		if cfg.Enabled() {
+			logging.LogService(fmt.Sprintf("Rate limiting %s: %s", l, describeRateLimit(cfg)))
+		}
+		h := dns.NewRateLimiter(handler, l.Name, cfg)
+		limited[l.Name] = h
+		return h
+	}
+}
 
-	// Get relay configuration
-	relayConfig := config.GetRelayConfig()
-	if relayConfig.Enabled {
-		logging.LogService(fmt.Sprintf("DNS relay enabled, using nameservers: %v", relayConfig.Nameservers))
+// describeRateLimit summarizes the limits of cfg for the log.
+func describeRateLimit(cfg config.RateLimitConfig) string {
+	var limits []string
+	if cfg.QueriesPerSecond > 0 {
+		limits = append(limits, fmt.Sprintf("%g queries/s (burst %d)", cfg.QueriesPerSecond, cfg.QueryBurst))
+	}
+	if cfg.ResponsesPerSecond > 0 {
+		limits = append(limits, fmt.Sprintf("%g identical responses/s (slip %d)", cfg.ResponsesPerSecond, cfg.Slip))
 	}
+	return fmt.Sprintf("%s per /%d or /%d client prefix", strings.Join(limits, ", "), cfg.IPv4Prefix, cfg.IPv6Prefix)
+}
 
-	// Create DNS handler
-	handler, err := dns.NewHandler(records, relayConfig)
-	if err != nil {
-		logging.LogService(fmt.Sprintf("Failed to create DNS handler: %v", err))
-		log.Fatalf("Failed to create DNS handler: %v", err)
+// openDnstap opens the configured dnstap output, or returns nil if dnstap
+// is disabled.
+func openDnstap(cfg config.DnstapConfig) *dnstap.Writer {
+	if !cfg.Enabled() {
+		return nil
 	}
-	externaldns.HandleFunc(".", handler.ServeDNS)
 
-	// Configure server
-	port := config.GetDNSPort()
-	server := &externaldns.Server{
-		Addr: ":" + port,
-		Net:  "udp",
+	tapVersion := "nanodns " + version
+	if cfg.Socket != "" {
+		logging.LogService(fmt.Sprintf("Writing dnstap events to socket %s", cfg.Socket))
+		return dnstap.NewSocketWriter(cfg.Socket, cfg.Identity, tapVersion)
 	}
 
-	logging.LogService(fmt.Sprintf("Starting DNS server on port %s", port))
-	if err := server.ListenAndServe(); err != nil {
-		logging.LogService(fmt.Sprintf("Failed to start server: %v", err))
-		log.Fatalf("Failed to start server: %v", err)
+	tap, err := dnstap.NewFileWriter(cfg.File, cfg.Identity, tapVersion)
+	if err != nil {
+		logging.Fatalf("Failed to open dnstap output: %v", err)
 	}
+	logging.LogService(fmt.Sprintf("Writing dnstap events to file %s", cfg.File))
+	return tap
+}
+
+func reopenLogsOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			if err := logging.Reopen(); err != nil {
+				logging.Errorf("Failed to reopen log files: %v", err)
+				continue
+			}
+			logging.Infof("Reopened log files")
+		}
+	}()
+}
+
+func startHTTPServer(addr string, state *serverState) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", state.healthz)
+	mux.HandleFunc("/readyz", state.readyz)
 
-	defer func() {
-		if err := server.Shutdown(); err != nil {
-			logging.LogService(fmt.Sprintf("Error during server shutdown: %v", err))
+	logging.LogService(fmt.Sprintf("Starting HTTP server on %s", addr))
+	server := &http.Server{Addr: addr, Handler: mux}
+	// Bind right away, the server may be about to drop its privileges
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logging.Errorf("HTTP server failed: %v", err)
+		return server
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logging.Errorf("HTTP server failed: %v", err)
 		}
 	}()
+	return server
 }
 
-func startDaemon() {
-	if checkIfRunning() {
-		logging.LogAction("START_ATTEMPT", "Server already running")
-		fmt.Println("NanoDNS is already running.")
+// startDaemon runs the server in the background with the given options.
+func startDaemon(flags *serverFlags) {
+	pidFile := config.GetPIDFile()
+	if pid, err := daemon.FindRunning(pidFile); err != nil {
+		logging.LogAction("START_FAILED", err.Error())
+		log.Fatalf("Failed to check for a running server: %v", err)
+	} else if pid != 0 {
+		logging.LogAction("START_ATTEMPT", fmt.Sprintf("Server already running (PID: %d)", pid))
+		fmt.Printf("NanoDNS is already running (PID: %d).\n", pid)
 		return
 	}
 
-	// Get service log file for output redirection
-	logFile, err := logging.GetServiceLogFile()
+	// Get service log file for output redirection, or discard the output
+	// when the logs go to syslog or journald only
+	var logFile *os.File
+	var err error
+	if logging.FileOutput() {
+		logFile, err = logging.GetServiceLogFile()
+	} else {
+		logFile, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err == nil {
+			defer logFile.Close()
+		}
+	}
 	if err != nil {
-		logging.LogAction("START_FAILED", fmt.Sprintf("Failed to open service log: %v", err))
+		logging.LogAction("START_FAILED", fmt.Sprintf("Failed to open the server output: %v", err))
 		return // Just exit the function
 	}
 
-	// cmd := exec.Command(os.Args[0])
-	cmd := exec.Command(os.Args[0])
+	cmd := exec.Command(os.Args[0], append([]string{"serve"}, flags.args()...)...)
+	cmd.Env = daemonEnv
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setsid: true,
 	}
-	// cmd.Dir = config.WorkingDir
 
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
@@ -212,68 +414,73 @@ func startDaemon() {
 		return // Just exit the function
 	}
 
-	if err := writePID(cmd.Process.Pid); err != nil {
-		if err := cmd.Process.Kill(); err != nil {
-			logging.LogAction("PROCESS_KILL_FAILED", fmt.Sprintf("Failed to kill process: %v", err))
+	// The server writes and locks the PID file itself; wait until it has,
+	// or has given up
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	deadline := time.After(startTimeout)
+	for {
+		select {
+		case err := <-exited:
+			logging.LogAction("START_FAILED", fmt.Sprintf("Server exited during startup: %v", err))
+			fmt.Println("NanoDNS failed to start, see nanodns logs for details.")
+			os.Exit(1)
+		case <-deadline:
+			logging.LogAction("START_FAILED", fmt.Sprintf("Server didn't write %s within %s", pidFile, startTimeout))
+			fmt.Printf("NanoDNS (PID %d) didn't start within %s, see nanodns logs for details.\n", cmd.Process.Pid, startTimeout)
+			os.Exit(1)
+		case <-time.After(stopPollInterval):
+		}
+		if pid, _ := daemon.FindRunning(pidFile); pid == cmd.Process.Pid {
+			break
 		}
-		log.Fatalf("Failed to write PID: %v", err)
 	}
 
 	logging.LogAction("START_SUCCESS", fmt.Sprintf("Server started with PID %d", cmd.Process.Pid))
 	fmt.Printf("Server running in background with PID %d\n", cmd.Process.Pid)
 }
 
-func writePID(pid int) error {
-	return os.WriteFile(pidFilePath, []byte(strconv.Itoa(pid)), 0644)
-}
-
-func checkIfRunning() bool {
-	if _, err := os.Stat(pidFilePath); os.IsNotExist(err) {
-		return false // PID file does not exist
-	}
-
-	data, err := os.ReadFile(pidFilePath)
-	if err != nil {
-		log.Fatalf("Failed to read PID file: %v", err)
-	}
-	pidStr := strings.TrimSpace(string(data))
-	pid, err := strconv.Atoi(pidStr)
+// findRunning returns the PID of the running server, or 0 if there is none.
+func findRunning() int {
+	pid, err := daemon.FindRunning(config.GetPIDFile())
 	if err != nil {
-		log.Fatalf("Invalid PID in file: %v", err)
+		log.Fatalf("Failed to check for a running server: %v", err)
 	}
-
-	// Check if the process is still running
-	process, err := os.FindProcess(pid)
-	if err != nil {
-		return false
-	}
-
-	// Try to signal the process with zero (doesn't send a signal, just checks if it's valid)
-	err = process.Signal(syscall.Signal(0)) // Just checking if the process exists
-	return err == nil
+	return pid
 }
 
+// checkServiceStatus asks the running server for its status.
 func checkServiceStatus() {
 	fmt.Println("")
-	if checkIfRunning() {
-		fmt.Println("The DNS server is currently running.")
-	} else {
+	defer fmt.Println("")
+
+	pid := findRunning()
+	if pid == 0 {
 		fmt.Println("The DNS server is not running.")
+		return
 	}
-	fmt.Println("")
-}
-
-func stopDaemon() {
-	pidData, err := os.ReadFile(pidFilePath)
+	output, err := control.Call(config.GetControlSocket(), "status")
 	if err != nil {
-		logging.LogAction("STOP_ATTEMPT", "No PID file found")
-		fmt.Println("No PID file found. NanoDNS is not running.")
+		fmt.Printf("The DNS server is running (PID: %d), but doesn't answer on its control socket: %v\n", pid, err)
 		return
 	}
+	fmt.Println("The DNS server is running.")
+	fmt.Print(output)
+}
 
-	pid, err := strconv.Atoi(strings.TrimSpace(string(pidData)))
-	if err != nil {
-		logging.LogAction("STOP_FAILED", fmt.Sprintf("Invalid PID in file: %v", err))
+// stopDaemon asks the daemon to shut down and waits for it to exit, killing
+// it if it is still running after timeout.
+func stopDaemon(timeout time.Duration) {
+	pid := findRunning()
+	if pid == 0 {
+		logging.LogAction("STOP_ATTEMPT", "Server not running")
+		fmt.Println("NanoDNS is not running.")
+		// Clean up after a server that crashed
+		if err := os.Remove(config.GetPIDFile()); err != nil && !os.IsNotExist(err) {
+			logging.LogAction("PID_REMOVE_FAILED", fmt.Sprintf("Failed to remove stale PID file: %v", err))
+		}
 		return
 	}
 
@@ -289,53 +496,114 @@ func stopDaemon() {
 		log.Fatalf("Failed to stop NanoDNS: %v", err)
 	}
 
+	if !waitForExit(process, timeout) {
+		logging.LogAction("STOP_TIMEOUT", fmt.Sprintf("Server still running after %s, killing it (PID: %d)", timeout, pid))
+		fmt.Printf("NanoDNS did not exit within %s, killing it (PID: %d)\n", timeout, pid)
+		if err := process.Kill(); err != nil {
+			logging.LogAction("STOP_FAILED", fmt.Sprintf("Failed to kill process: %v", err))
+			log.Fatalf("Failed to kill NanoDNS: %v", err)
+		}
+		waitForExit(process, timeout)
+	}
+
 	logging.LogAction("STOP_SUCCESS", fmt.Sprintf("Server stopped (PID: %d)", pid))
 	fmt.Printf("NanoDNS stopped successfully (PID: %d)\n", pid)
 
-	if err := os.Remove(pidFilePath); err != nil {
+	// The server removes its PID file on exit, unless it had to be killed
+	if err := os.Remove(config.GetPIDFile()); err != nil && !os.IsNotExist(err) {
 		logging.LogAction("PID_REMOVE_FAILED", fmt.Sprintf("Failed to remove PID file: %v", err))
 	}
 }
 
-func showSelectiveLogs() {
-	if len(os.Args) > 2 {
-		switch os.Args[2] {
-		case "-a", "--action-logs":
-			showActionLogs()
-			return
-		default:
-			showServiceLogs()
-			return
+// restartDaemon stops the daemon if it is running and starts it again with
+// the given options.
+func restartDaemon(flags *serverFlags, timeout time.Duration) {
+	if findRunning() != 0 {
+		stopDaemon(timeout)
+	}
+	startDaemon(flags)
+}
+
+// showSelectiveLogs shows the logs selected by the options following the
+// logs command.
+func showSelectiveLogs(args []string) {
+	fs := newFlagSet("logs")
+	var actionLogs, queryLogs, follow bool
+	var since time.Duration
+	var grep, domain string
+	var lines int
+	fs.BoolVar(&actionLogs, "a", false, "Show action logs")
+	fs.BoolVar(&actionLogs, "action-logs", false, "Show action logs")
+	fs.BoolVar(&queryLogs, "q", false, "Show the query log")
+	fs.BoolVar(&queryLogs, "queries", false, "Show the query log")
+	fs.DurationVar(&since, "since", defaultLogDuration, "Only show lines newer than this, 0 for all of them")
+	fs.DurationVar(&since, "duration", defaultLogDuration, "Only show lines newer than this (alias of --since)")
+	fs.BoolVar(&follow, "f", false, "Keep showing new lines as they are written")
+	fs.BoolVar(&follow, "follow", false, "Keep showing new lines as they are written")
+	fs.StringVar(&grep, "grep", "", "Only show lines matching the regular expression")
+	fs.StringVar(&domain, "domain", "", "Only show lines mentioning the domain or its subdomains")
+	fs.IntVar(&lines, "n", 0, "Only show the last N lines")
+	fs.IntVar(&lines, "lines", 0, "Only show the last N lines")
+	parseFlags(fs, args)
+
+	logFile := logging.ServiceLog
+	switch {
+	case actionLogs:
+		logFile = logging.ActionLog
+	case queryLogs:
+		logFile = logging.QueryLog
+	}
+
+	filter := logging.LogFilter{Since: since, Domain: domain, Lines: lines}
+	if grep != "" {
+		pattern, err := regexp.Compile(grep)
+		if err != nil {
+			fmt.Printf("Invalid --grep pattern: %v\n", err)
+			os.Exit(1)
 		}
-	} else {
-		showServiceLogs()
-		return
+		filter.Pattern = pattern
 	}
+
+	printLogs(logFile, filter, follow)
 }
 
-func showServiceLogs() {
-	// Use the new configuration-aware logging package
-	logs, err := logging.GetServiceLogs(logDuration)
+func printLogs(logFile logging.LogFile, filter logging.LogFilter, follow bool) {
+	entries, err := logging.ReadLogs(logFile, filter)
 	if err != nil {
-		fmt.Printf("Failed to read service logs: %v\n", err)
+		fmt.Printf("Failed to read logs: %v\n", err)
 		return
 	}
-
-	for _, entry := range logs {
+	for _, entry := range entries {
 		fmt.Println(entry)
 	}
+
+	if follow {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err := logging.FollowLogs(ctx, logFile, filter, func(line string) {
+			fmt.Println(line)
+		})
+		if err != nil {
+			fmt.Printf("Failed to follow logs: %v\n", err)
+		}
+	}
 }
 
-func showActionLogs() {
-	logs, err := logging.GetActionLogs(logDuration)
+// runHealthCheck queries the local DNS server, exiting with status 1 if it
+// doesn't answer. It needs no shell or DNS tools, so images can use it as
+// their HEALTHCHECK.
+func runHealthCheck() {
+	listeners, err := config.GetListeners()
 	if err != nil {
-		fmt.Printf("Failed to read action logs: %v\n", err)
-		return
+		fmt.Fprintf(os.Stderr, "unhealthy: %v\n", err)
+		os.Exit(1)
 	}
-
-	for _, entry := range logs {
-		fmt.Println(entry)
+	target := probeTarget(listeners)
+	if err := dns.Probe(target.Net, target.Addr, probeTimeout); err != nil {
+		fmt.Fprintf(os.Stderr, "unhealthy: %v\n", err)
+		os.Exit(1)
 	}
+	fmt.Println("healthy")
 }
 
 func printVersion() {
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mguptahub/nanodns/internal/daemon"
+	"github.com/mguptahub/nanodns/internal/logging"
+	"github.com/mguptahub/nanodns/pkg/config"
+)
+
+// dropPrivileges switches a server started as root to the configured user
+// and group, and confines it to the configured chroot. The log files and the
+// owned paths, such as the PID file, are handed to the user first so it can
+// still rotate and remove them.
+func dropPrivileges(owned ...string) error {
+	cfg := config.GetPrivilegeConfig()
+	if cfg == (config.PrivilegeConfig{}) {
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		logging.Warnf("Not started as root, ignoring RUN_USER, RUN_GROUP and RUN_CHROOT")
+		return nil
+	}
+
+	uid, gid, err := daemon.LookupAccount(cfg.User, cfg.Group)
+	if err != nil {
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		if err := logging.Chown(uid, gid); err != nil {
+			return fmt.Errorf("failed to hand over the log files: %w", err)
+		}
+		for _, path := range owned {
+			if err := os.Lchown(path, uid, gid); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to hand over %s: %w", path, err)
+			}
+		}
+	}
+
+	if err := daemon.DropPrivileges(uid, gid, cfg.Chroot); err != nil {
+		return err
+	}
+	logging.LogService(fmt.Sprintf("Running as user %d, group %d", os.Getuid(), os.Getgid()))
+	if cfg.Chroot != "" {
+		logging.LogService(fmt.Sprintf("Confined to %s", cfg.Chroot))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/mguptahub/nanodns/internal/dns"
+	"github.com/mguptahub/nanodns/pkg/config"
+)
+
+// probeTimeout bounds the self-query made by readiness checks
+const probeTimeout = 2 * time.Second
+
+// serverState is what the health endpoints report on.
+type serverState struct {
+	listening atomic.Bool                 // DNS listener bound
+	handler   atomic.Pointer[dns.Handler] // set once the records are loaded
+	target    config.Listener             // listener the self-query is sent to
+	probe     func(network, addr string, timeout time.Duration) error
+}
+
+// probeTarget returns the listener self-queries are sent to, preferring UDP.
+// Listeners on all addresses are queried on the loopback address.
+func probeTarget(listeners []config.Listener) config.Listener {
+	target := listeners[0]
+	for _, l := range listeners {
+		if l.Net == config.ProtocolUDP {
+			target = l
+			break
+		}
+	}
+
+	host, port, _ := net.SplitHostPort(target.Addr)
+	if ip := net.ParseIP(host); ip == nil {
+		host = "127.0.0.1"
+	} else if ip.IsUnspecified() {
+		host = "::1"
+		if ip.To4() != nil {
+			host = "127.0.0.1"
+		}
+	}
+	target.Addr = net.JoinHostPort(host, port)
+	return target
+}
+
+func newServerState(target config.Listener) *serverState {
+	return &serverState{target: target, probe: dns.Probe}
+}
+
+// healthz reports whether the process is alive and its listener is bound.
+func (s *serverState) healthz(w http.ResponseWriter, r *http.Request) {
+	if !s.listening.Load() {
+		http.Error(w, "DNS listener not bound", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz reports whether queries are answered: the records are loaded, an
+// upstream is reachable if relaying is enabled and a self-query succeeds.
+func (s *serverState) readyz(w http.ResponseWriter, r *http.Request) {
+	if err := s.ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *serverState) ready() error {
+	handler := s.handler.Load()
+	if handler == nil {
+		return fmt.Errorf("records not loaded")
+	}
+	if !s.listening.Load() {
+		return fmt.Errorf("DNS listener not bound")
+	}
+	if err := handler.Ready(); err != nil {
+		return err
+	}
+	if err := s.probe(s.target.Net, s.target.Addr, probeTimeout); err != nil {
+		return fmt.Errorf("self-query failed: %v", err)
+	}
+	return nil
+}
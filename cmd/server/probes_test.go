@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mguptahub/nanodns/internal/dns"
+	"github.com/mguptahub/nanodns/pkg/config"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	handler, err := dns.NewHandler(nil, config.RelayConfig{})
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer handler.Close()
+
+	probeErr := errors.New("timeout")
+
+	tests := []struct {
+		name       string
+		listening  bool
+		loaded     bool
+		probeErr   error
+		wantHealth int
+		wantReady  int
+	}{
+		{"starting", false, false, nil, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		{"records not loaded", true, false, nil, http.StatusOK, http.StatusServiceUnavailable},
+		{"self-query failing", true, true, probeErr, http.StatusOK, http.StatusServiceUnavailable},
+		{"ready", true, true, nil, http.StatusOK, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServerState(config.Listener{Addr: "127.0.0.1:0", Net: config.ProtocolUDP})
+			s.probe = func(string, string, time.Duration) error { return tt.probeErr }
+			s.listening.Store(tt.listening)
+			if tt.loaded {
+				s.handler.Store(handler)
+			}
+
+			rec := httptest.NewRecorder()
+			s.healthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if rec.Code != tt.wantHealth {
+				t.Errorf("healthz status = %d, want %d", rec.Code, tt.wantHealth)
+			}
+
+			rec = httptest.NewRecorder()
+			s.readyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.wantReady {
+				t.Errorf("readyz status = %d, want %d", rec.Code, tt.wantReady)
+			}
+		})
+	}
+}
+
+func TestProbeTarget(t *testing.T) {
+	udp := func(addr string) config.Listener { return config.Listener{Addr: addr, Net: config.ProtocolUDP} }
+	tcp := func(addr string) config.Listener { return config.Listener{Addr: addr, Net: config.ProtocolTCP} }
+
+	tests := []struct {
+		name      string
+		listeners []config.Listener
+		want      config.Listener
+	}{
+		{"all addresses", []config.Listener{udp(":53")}, udp("127.0.0.1:53")},
+		{"all IPv4 addresses", []config.Listener{udp("0.0.0.0:53")}, udp("127.0.0.1:53")},
+		{"all IPv6 addresses", []config.Listener{udp("[::]:53")}, udp("[::1]:53")},
+		{"address", []config.Listener{udp("127.0.0.53:53")}, udp("127.0.0.53:53")},
+		{"UDP preferred", []config.Listener{tcp("10.0.0.5:53"), udp("[::1]:5353")}, udp("[::1]:5353")},
+		{"TCP only", []config.Listener{tcp("10.0.0.5:53")}, tcp("10.0.0.5:53")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := probeTarget(tt.listeners); got != tt.want {
+				t.Errorf("probeTarget() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
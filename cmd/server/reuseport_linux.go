@@ -0,0 +1,23 @@
+package main
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePortSupported reports whether the kernel spreads the datagrams of an
+// address over the sockets sharing it with SO_REUSEPORT.
+const reusePortSupported = true
+
+// reusePort is a net.ListenConfig Control function setting SO_REUSEPORT.
+func reusePort(network, address string, c syscall.RawConn) error {
+	var opErr error
+	err := c.Control(func(fd uintptr) {
+		opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePortSupported reports whether the kernel spreads the datagrams of an
+// address over the sockets sharing it with SO_REUSEPORT. Other systems
+// deliver them all to one socket, so extra sockets wouldn't help.
+const reusePortSupported = false
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT load balancing requires Linux")
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+)
+
+// defaultUnitDir is where install-service writes the systemd units
+const defaultUnitDir = "/etc/systemd/system"
+
+// defaultServiceEnvFile is the env file installed by install.sh
+const defaultServiceEnvFile = "/usr/local/share/nanodns.env"
+
+// serviceRuntimeDir and serviceLogDir are the directories systemd creates for
+// the service
+const (
+	serviceRuntimeDir = "/run/nanodns"
+	serviceLogDir     = "/var/log/nanodns"
+)
+
+// serviceOptions are the settings of the generated systemd units.
+type serviceOptions struct {
+	binary    string   // absolute path of nanodns
+	envFile   string   // absolute path of the env file
+	args      []string // options of serve besides the env file
+	user      string   // empty for a user allocated by systemd
+	group     string
+	listeners []config.Listener
+}
+
+// serviceUnit returns the service unit running the server. systemd binds the
+// sockets, so the server itself never needs root.
+func serviceUnit(opts serviceOptions) string {
+	command := commandLine(opts.binary, "serve", append([]string{"--env-file", opts.envFile}, opts.args...))
+	reload := commandLine(opts.binary, "reload", []string{"--env-file", opts.envFile})
+
+	var sb strings.Builder
+	sb.WriteString("[Unit]\n")
+	sb.WriteString("Description=NanoDNS DNS server\n")
+	sb.WriteString("Documentation=https://github.com/mguptahub/nanodns\n")
+	sb.WriteString("Requires=nanodns.socket\n")
+	sb.WriteString("After=network-online.target nanodns.socket\n")
+	sb.WriteString("Wants=network-online.target\n")
+	sb.WriteString("\n[Service]\n")
+	sb.WriteString("Type=simple\n")
+	fmt.Fprintf(&sb, "ExecStart=%s\n", command)
+	fmt.Fprintf(&sb, "ExecReload=%s\n", reload)
+	fmt.Fprintf(&sb, "Environment=RUNTIME_DIR=%s LOG_DIR=%s\n", serviceRuntimeDir, serviceLogDir)
+	sb.WriteString("RuntimeDirectory=nanodns\n")
+	sb.WriteString("LogsDirectory=nanodns\n")
+	if opts.user == "" {
+		sb.WriteString("DynamicUser=yes\n")
+	} else {
+		fmt.Fprintf(&sb, "User=%s\n", opts.user)
+	}
+	if opts.group != "" {
+		fmt.Fprintf(&sb, "Group=%s\n", opts.group)
+	}
+	sb.WriteString("NoNewPrivileges=yes\n")
+	sb.WriteString("ProtectSystem=strict\n")
+	sb.WriteString("ProtectHome=read-only\n")
+	sb.WriteString("Restart=on-failure\n")
+	fmt.Fprintf(&sb, "TimeoutStopSec=%d\n", int(defaultStopTimeout.Seconds()))
+	sb.WriteString("\n[Install]\n")
+	sb.WriteString("WantedBy=multi-user.target\n")
+	return sb.String()
+}
+
+// commandLine quotes the words of a command for a unit file.
+func commandLine(binary, command string, args []string) string {
+	words := append([]string{binary, command}, args...)
+	for i, word := range words {
+		word = strings.NewReplacer("%", "%%", "$", "$$").Replace(word)
+		if word == "" || strings.ContainsAny(word, " \t\"'\\;") {
+			word = strconv.Quote(word)
+		}
+		words[i] = word
+	}
+	return strings.Join(words, " ")
+}
+
+// socketUnit returns the socket unit binding the listeners for the service.
+func socketUnit(opts serviceOptions) string {
+	var sb strings.Builder
+	sb.WriteString("[Unit]\n")
+	sb.WriteString("Description=NanoDNS DNS server sockets\n")
+	sb.WriteString("\n[Socket]\n")
+	for _, l := range opts.listeners {
+		addr := l.Addr
+		// systemd listens on all addresses when given only a port
+		if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+			addr = port
+		}
+		if l.Net == config.ProtocolTCP {
+			fmt.Fprintf(&sb, "ListenStream=%s\n", addr)
+		} else {
+			fmt.Fprintf(&sb, "ListenDatagram=%s\n", addr)
+		}
+	}
+	sb.WriteString("\n[Install]\n")
+	sb.WriteString("WantedBy=sockets.target\n")
+	return sb.String()
+}
+
+// installService writes the systemd units running the server with flags to
+// dir.
+func installService(flags *serverFlags, user, group, dir string) {
+	listeners, err := config.GetListeners()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "install-service: %v\n", err)
+		os.Exit(1)
+	}
+	binary, err := os.Executable()
+	if err == nil {
+		binary, err = filepath.EvalSymlinks(binary)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "install-service: failed to find the nanodns binary: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The service reads the env file install-service used, given as an
+	// option or in NANODNS_ENV_FILE
+	envFile = ""
+	opts := serviceOptions{
+		binary:    binary,
+		envFile:   absPath(os.Getenv("NANODNS_ENV_FILE")),
+		args:      flags.args(),
+		user:      user,
+		group:     group,
+		listeners: listeners,
+	}
+
+	units := map[string]string{
+		"nanodns.service": serviceUnit(opts),
+		"nanodns.socket":  socketUnit(opts),
+	}
+	for _, name := range []string{"nanodns.service", "nanodns.socket"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(units[name]), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "install-service: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+
+	fmt.Println("")
+	fmt.Println("Start the service with:")
+	fmt.Println("  systemctl daemon-reload")
+	fmt.Println("  systemctl enable --now nanodns.socket nanodns.service")
+	fmt.Println("")
+	fmt.Println("Admin commands reach the service with:")
+	fmt.Printf("  RUNTIME_DIR=%s nanodns status\n", serviceRuntimeDir)
+}
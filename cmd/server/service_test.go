@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+)
+
+func TestServiceUnit(t *testing.T) {
+	tests := []struct {
+		name string
+		opts serviceOptions
+		want []string
+	}{
+		{
+			name: "dynamic user",
+			opts: serviceOptions{
+				binary:  "/usr/local/bin/nanodns",
+				envFile: "/usr/local/share/nanodns.env",
+				args:    []string{"--records", "/etc/nanodns/records.env"},
+			},
+			want: []string{
+				"ExecStart=/usr/local/bin/nanodns serve --env-file /usr/local/share/nanodns.env --records /etc/nanodns/records.env\n",
+				"ExecReload=/usr/local/bin/nanodns reload --env-file /usr/local/share/nanodns.env\n",
+				"Environment=RUNTIME_DIR=/run/nanodns LOG_DIR=/var/log/nanodns\n",
+				"DynamicUser=yes\n",
+				"Requires=nanodns.socket\n",
+			},
+		},
+		{
+			name: "user and quoted paths",
+			opts: serviceOptions{
+				binary:  "/opt/nano dns/nanodns",
+				envFile: "/etc/nanodns/50%.env",
+				user:    "nanodns",
+				group:   "dns",
+			},
+			want: []string{
+				`ExecStart="/opt/nano dns/nanodns" serve --env-file /etc/nanodns/50%%.env` + "\n",
+				"User=nanodns\nGroup=dns\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unit := serviceUnit(tt.opts)
+			for _, want := range tt.want {
+				if !strings.Contains(unit, want) {
+					t.Errorf("serviceUnit() = %s\nwant it to contain %q", unit, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSocketUnit(t *testing.T) {
+	unit := socketUnit(serviceOptions{listeners: []config.Listener{
+		{Addr: ":53", Net: config.ProtocolUDP},
+		{Addr: "127.0.0.53:53", Net: config.ProtocolTCP},
+		{Addr: "[::1]:5353", Net: config.ProtocolUDP},
+	}})
+
+	want := "[Socket]\nListenDatagram=53\nListenStream=127.0.0.53:53\nListenDatagram=[::1]:5353\n"
+	if !strings.Contains(unit, want) {
+		t.Errorf("socketUnit() = %s\nwant it to contain %q", unit, want)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+	externaldns "github.com/miekg/dns"
+)
+
+// stopPollInterval is how often stop checks whether the server has exited
+const stopPollInterval = 100 * time.Millisecond
+
+// serve runs servers, whose sockets are bound, until ctx is done or one of
+// them fails, then stops them all, giving queries in flight up to drain to
+// be answered.
+func serve(ctx context.Context, servers []*externaldns.Server, drain time.Duration) error {
+	errs := make(chan error, len(servers))
+	started := make(chan struct{}, len(servers))
+	for _, server := range servers {
+		notify := server.NotifyStartedFunc
+		server.NotifyStartedFunc = func() {
+			if notify != nil {
+				notify()
+			}
+			started <- struct{}{}
+		}
+		go func() {
+			errs <- server.ActivateAndServe()
+		}()
+	}
+
+	// A server can only be shut down once it has started
+	for range servers {
+		select {
+		case <-started:
+		case err := <-errs:
+			closeSockets(servers)
+			for range len(servers) - 1 {
+				<-errs
+			}
+			return err
+		}
+	}
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+	}
+
+	logging.LogService("Shutting down, draining queries in flight")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	var wg sync.WaitGroup
+	var stuck atomic.Bool
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if server.ShutdownContext(shutdownCtx) != nil {
+				stuck.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+	if stuck.Load() {
+		return fmt.Errorf("queries still in flight after %s", drain)
+	}
+	return nil
+}
+
+// waitForExit waits up to timeout for process to exit and reports whether it
+// did.
+func waitForExit(process *os.Process, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if err := process.Signal(syscall.Signal(0)); err != nil {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(stopPollInterval)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	externaldns "github.com/miekg/dns"
+)
+
+func TestServeDrainsQueries(t *testing.T) {
+	received := make(chan struct{})
+	slow := externaldns.HandlerFunc(func(w externaldns.ResponseWriter, r *externaldns.Msg) {
+		close(received)
+		time.Sleep(200 * time.Millisecond)
+		m := new(externaldns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	})
+
+	servers, err := listen([]config.Listener{{Addr: "127.0.0.1:0", Net: config.ProtocolUDP}}, 1, serveWith(slow))
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+	server := servers[0]
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, servers, 2*time.Second)
+	}()
+	<-started
+
+	answered := make(chan error, 1)
+	go func() {
+		m := new(externaldns.Msg)
+		m.SetQuestion("example.com.", externaldns.TypeA)
+		_, err := externaldns.Exchange(m, server.PacketConn.LocalAddr().String())
+		answered <- err
+	}()
+
+	// Shut down while the query is being answered
+	<-received
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("serve() error = %v", err)
+	}
+	if err := <-answered; err != nil {
+		t.Errorf("Query in flight during shutdown failed: %v", err)
+	}
+}
+
+func TestServeStopsAllServers(t *testing.T) {
+	servers, err := listen([]config.Listener{
+		{Addr: "127.0.0.1:0", Net: config.ProtocolUDP},
+		{Addr: "127.0.0.1:0", Net: config.ProtocolTCP},
+	}, 1, serveWith(externaldns.HandlerFunc(func(w externaldns.ResponseWriter, r *externaldns.Msg) {})))
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+	// A server without sockets fails to start
+	servers = append(servers, &externaldns.Server{Net: "udp"})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(context.Background(), servers, time.Second)
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("serve() with a failing server succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve() didn't return after a server failed")
+	}
+}
+
+func TestWaitForExit(t *testing.T) {
+	start := func(seconds string) *exec.Cmd {
+		cmd := exec.Command("sleep", seconds)
+		if err := cmd.Start(); err != nil {
+			t.Skipf("sleep not available: %v", err)
+		}
+		// Reap the child so it doesn't linger as a zombie
+		go cmd.Wait()
+		return cmd
+	}
+
+	quick := start("0.1")
+	if !waitForExit(quick.Process, 2*time.Second) {
+		t.Error("waitForExit() timed out on an exiting process")
+	}
+
+	slow := start("10")
+	defer slow.Process.Kill()
+	if waitForExit(slow.Process, 200*time.Millisecond) {
+		t.Error("waitForExit() returned true for a running process")
+	}
+}
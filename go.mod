@@ -5,12 +5,12 @@ go 1.22.4
 require (
 	github.com/joho/godotenv v1.5.1
 	github.com/miekg/dns v1.1.62
+	golang.org/x/sys v0.22.0
 )
 
 require (
 	golang.org/x/mod v0.18.0 // indirect
 	golang.org/x/net v0.27.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
-	golang.org/x/sys v0.22.0 // indirect
 	golang.org/x/tools v0.22.0 // indirect
 )
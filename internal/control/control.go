@@ -0,0 +1,156 @@
+// Package control serves admin commands to the running server over a Unix
+// socket, and sends them from the command line.
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// callTimeout bounds a whole command, reloads included
+const callTimeout = 30 * time.Second
+
+// Request is a command sent to the server, one JSON object per connection.
+type Request struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// Response is the server's reply to a Request.
+type Response struct {
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// CommandFunc runs a command and returns the text shown to the operator.
+type CommandFunc func(args []string) (string, error)
+
+// Server answers commands on a Unix socket.
+type Server struct {
+	mu       sync.Mutex
+	commands map[string]CommandFunc
+	listener net.Listener
+	path     string
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a server without any commands.
+func NewServer() *Server {
+	return &Server{commands: make(map[string]CommandFunc)}
+}
+
+// Register adds a command. Commands must be registered before Listen.
+func (s *Server) Register(name string, fn CommandFunc) {
+	s.commands[name] = fn
+}
+
+// Listen creates the socket at path and serves commands in the background.
+// A stale socket left behind by a crashed server is replaced, but a socket
+// another server still answers on is not.
+func (s *Server) Listen(path string) error {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("control socket %s is in use by another server", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale control socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	// Commands can change the server, so only the owner and group may connect
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	s.listener, s.path = listener, path
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.serve(listener)
+	return nil
+}
+
+// Close stops accepting commands, waits for running ones and removes the
+// socket.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	listener, path := s.listener, s.path
+	s.listener = nil
+	s.mu.Unlock()
+	if listener == nil {
+		return nil
+	}
+
+	err := listener.Close()
+	s.wg.Wait()
+	if rmErr := os.Remove(path); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+func (s *Server) serve(listener net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+
+	var req Request
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("invalid request: %v", err)
+	} else if fn, ok := s.commands[req.Command]; !ok {
+		resp.Error = fmt.Sprintf("unknown command %q", req.Command)
+	} else if output, err := fn(req.Args); err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Output = output
+	}
+	_ = json.NewEncoder(conn).Encode(resp)
+}
+
+// Call sends a command to the server listening on path and returns its
+// output.
+func Call(path, command string, args ...string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, callTimeout)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to control socket %s, is NanoDNS running? %w", path, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+
+	if err := json.NewEncoder(conn).Encode(Request{Command: command, Args: args}); err != nil {
+		return "", err
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != "" {
+		return "", errors.New(resp.Error)
+	}
+	return resp.Output, nil
+}
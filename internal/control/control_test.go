@@ -0,0 +1,102 @@
+package control
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func startTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "nanodns.sock")
+	server := NewServer()
+	server.Register("echo", func(args []string) (string, error) {
+		return strings.Join(args, " "), nil
+	})
+	server.Register("fail", func(args []string) (string, error) {
+		return "", errors.New("command failed")
+	})
+	if err := server.Listen(path); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server, path
+}
+
+func TestCall(t *testing.T) {
+	_, path := startTestServer(t)
+
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{"output", "echo", []string{"flush", "example.com"}, "flush example.com", ""},
+		{"no arguments", "echo", nil, "", ""},
+		{"command error", "fail", nil, "", "command failed"},
+		{"unknown command", "missing", nil, "", `unknown command "missing"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Call(path, tt.command, tt.args...)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("Call() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Call() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Call() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0660 {
+		t.Errorf("Socket permissions = %o, want 660", perm)
+	}
+}
+
+func TestServerListen(t *testing.T) {
+	server, path := startTestServer(t)
+
+	if err := NewServer().Listen(path); err == nil {
+		t.Error("Listen() on a socket in use succeeded")
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Close() left the socket behind")
+	}
+	if _, err := Call(path, "echo"); err == nil {
+		t.Error("Call() after Close() succeeded")
+	}
+
+	// A stale socket left by a crashed server is replaced
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewServer()
+	restarted.Register("echo", func(args []string) (string, error) { return "ok", nil })
+	if err := restarted.Listen(path); err != nil {
+		t.Fatalf("Listen() over a stale socket error = %v", err)
+	}
+	defer restarted.Close()
+	if got, err := Call(path, "echo"); err != nil || got != "ok" {
+		t.Errorf("Call() = %q, %v, want ok", got, err)
+	}
+}
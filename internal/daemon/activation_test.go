@@ -0,0 +1,58 @@
+package daemon
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestListenFiles(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	defer conn.Close()
+	f, err := conn.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatalf("File() error = %v", err)
+	}
+	defer f.Close()
+
+	// systemd passes the sockets as consecutive descriptors
+	const start = 100
+	for fd := start; fd < start+2; fd++ {
+		if err := unix.Dup2(int(f.Fd()), fd); err != nil {
+			t.Fatalf("Dup2() error = %v", err)
+		}
+		defer unix.Close(fd)
+	}
+
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "2")
+	if files := listenFiles(start); files != nil {
+		t.Errorf("listenFiles() for another process = %v, want nil", files)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "2")
+	t.Setenv("LISTEN_FDNAMES", "dns")
+	files := listenFiles(start)
+	if len(files) != 2 {
+		t.Fatalf("listenFiles() returned %d files, want 2", len(files))
+	}
+	if files[0].Fd() != start || files[0].Name() != "dns" {
+		t.Errorf("First file = %d %q, want %d %q", files[0].Fd(), files[0].Name(), start, "dns")
+	}
+	if files[1].Fd() != start+1 || files[1].Name() != "LISTEN_FD_101" {
+		t.Errorf("Second file = %d %q, want %d %q", files[1].Fd(), files[1].Name(), start+1, "LISTEN_FD_101")
+	}
+
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if value, ok := os.LookupEnv(key); ok {
+			t.Errorf("%s = %q after listenFiles(), want it unset", key, value)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package daemon manages the process of a running NanoDNS server: its PID
+// file, the sockets systemd passes to it and its privileges.
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// RunningError reports that another server holds the PID file.
+type RunningError struct {
+	PID int
+}
+
+func (e *RunningError) Error() string {
+	return fmt.Sprintf("NanoDNS is already running (PID %d)", e.PID)
+}
+
+// PIDFile is a PID file locked by the running server. The lock is released
+// by the kernel when the process dies, so a PID file that isn't locked was
+// left behind by a server that crashed.
+type PIDFile struct {
+	path string
+	file *os.File
+}
+
+// AcquirePIDFile locks the PID file at path and writes the PID of this
+// process to it, creating the directory if needed. It returns a
+// *RunningError if another server holds the lock.
+//
+// The runtime directory may be shared, like /tmp, so a symlink at path is
+// refused, and a stale file another user owns or that is linked elsewhere
+// too is replaced rather than written through.
+func AcquirePIDFile(path string) (*PIDFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create runtime directory: %w", err)
+	}
+	file, err := lockPIDFile(path, os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+	if ownErr := checkOwned(file); ownErr != nil {
+		file.Close()
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to replace PID file %s (%v): %w", path, ownErr, err)
+		}
+		if file, err = lockPIDFile(path, os.O_CREATE|os.O_EXCL); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write PID file: %w", err)
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write PID file: %w", err)
+	}
+	return &PIDFile{path: path, file: file}, nil
+}
+
+// lockPIDFile opens the PID file at path with flag, without following a
+// symlink, and locks it.
+func lockPIDFile(path string, flag int) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|flag|syscall.O_NOFOLLOW, 0644)
+	// FreeBSD reports a symlink with EMLINK
+	if errors.Is(err, syscall.ELOOP) || errors.Is(err, syscall.EMLINK) {
+		return nil, fmt.Errorf("refusing PID file %s: it is a symbolic link", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PID file: %w", err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		pid, _ := readPID(file)
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, &RunningError{PID: pid}
+		}
+		return nil, fmt.Errorf("failed to lock PID file: %w", err)
+	}
+	return file, nil
+}
+
+// checkOwned returns an error unless file is a regular file with a single
+// link, owned by the effective user.
+func checkOwned(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	switch {
+	case !info.Mode().IsRegular():
+		return fmt.Errorf("not a regular file")
+	case !ok:
+		return nil
+	case int(stat.Uid) != os.Geteuid():
+		return fmt.Errorf("owned by user %d", stat.Uid)
+	case stat.Nlink != 1:
+		return fmt.Errorf("file has %d links", stat.Nlink)
+	}
+	return nil
+}
+
+// Release removes the PID file and releases its lock.
+func (p *PIDFile) Release() error {
+	err := os.Remove(p.path)
+	if closeErr := p.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// FindRunning returns the PID of the server that owns the PID file at path,
+// or 0 if no server is running. The PID file is trusted while it is locked;
+// an unlocked one, as written by older versions, only counts if its PID
+// belongs to a running NanoDNS process.
+func FindRunning(path string) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open PID file: %w", err)
+	}
+	defer file.Close()
+
+	pid, err := readPID(file)
+	if err != nil {
+		return 0, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return pid, nil
+	}
+	if err == nil {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	}
+	if pid > 0 && IsNanoDNS(pid) {
+		return pid, nil
+	}
+	return 0, nil
+}
+
+// IsNanoDNS reports whether pid is a running process of the same executable
+// as this one. Where the executable can't be checked, any running process
+// counts.
+func IsNanoDNS(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil && !errors.Is(err, syscall.EPERM) {
+		return false
+	}
+	name, err := processName(pid)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	self, err := processName(os.Getpid())
+	return err == nil && name == self
+}
+
+func readPID(file *os.File) (int, error) {
+	data := make([]byte, 32)
+	n, err := file.ReadAt(data, 0)
+	if n == 0 && err != nil {
+		return 0, nil
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data[:n])))
+	if err != nil {
+		return 0, fmt.Errorf("invalid PID in file %s: %q", file.Name(), strings.TrimSpace(string(data[:n])))
+	}
+	return pid, nil
+}
@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestAcquirePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "nanodns.pid")
+
+	pidFile, err := AcquirePIDFile(path)
+	if err != nil {
+		t.Fatalf("AcquirePIDFile() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != strconv.Itoa(os.Getpid())+"\n" {
+		t.Errorf("PID file contains %q, want this process", data)
+	}
+
+	var running *RunningError
+	if _, err := AcquirePIDFile(path); !errors.As(err, &running) || running.PID != os.Getpid() {
+		t.Errorf("Second AcquirePIDFile() error = %v, want RunningError", err)
+	}
+	if pid, err := FindRunning(path); err != nil || pid != os.Getpid() {
+		t.Errorf("FindRunning() = %d, %v, want %d", pid, err, os.Getpid())
+	}
+
+	if err := pidFile.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Release() left the PID file behind")
+	}
+	if pid, err := FindRunning(path); err != nil || pid != 0 {
+		t.Errorf("FindRunning() after Release() = %d, %v, want 0", pid, err)
+	}
+}
+
+func TestAcquirePIDFileLinks(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	if err := os.WriteFile(target, []byte("keep\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	symlink := filepath.Join(dir, "symlink.pid")
+	if err := os.Symlink(target, symlink); err != nil {
+		t.Fatal(err)
+	}
+	hardlink := filepath.Join(dir, "hardlink.pid")
+	if err := os.Link(target, hardlink); err != nil {
+		t.Fatal(err)
+	}
+
+	if pidFile, err := AcquirePIDFile(symlink); err == nil {
+		pidFile.Release()
+		t.Error("AcquirePIDFile() of a symlink succeeded")
+	}
+
+	// A hard link is replaced by a file of its own
+	pidFile, err := AcquirePIDFile(hardlink)
+	if err != nil {
+		t.Fatalf("AcquirePIDFile() of a hard link error = %v", err)
+	}
+	defer pidFile.Release()
+	if data, _ := os.ReadFile(hardlink); string(data) != strconv.Itoa(os.Getpid())+"\n" {
+		t.Errorf("PID file contains %q, want this process", data)
+	}
+
+	if data, _ := os.ReadFile(target); string(data) != "keep\n" {
+		t.Errorf("Linked file contains %q, want it untouched", data)
+	}
+}
+
+func TestAcquirePIDFileOtherOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of a file needs root")
+	}
+	path := filepath.Join(t.TempDir(), "nanodns.pid")
+	if err := os.WriteFile(path, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(path, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+
+	pidFile, err := AcquirePIDFile(path)
+	if err != nil {
+		t.Fatalf("AcquirePIDFile() error = %v", err)
+	}
+	defer pidFile.Release()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := info.Sys().(*syscall.Stat_t).Uid; uid != 0 {
+		t.Errorf("PID file is owned by user %d, want it replaced by one of root", uid)
+	}
+}
+
+func TestFindRunningStalePIDFile(t *testing.T) {
+	other := exec.Command("sleep", "10")
+	if err := other.Start(); err != nil {
+		t.Skipf("sleep not available: %v", err)
+	}
+	defer func() {
+		other.Process.Kill()
+		other.Wait()
+	}()
+
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skipf("true not available: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    int
+		wantErr bool
+	}{
+		{"exited process", strconv.Itoa(exited.Process.Pid), 0, false},
+		{"PID reused by another program", strconv.Itoa(other.Process.Pid), 0, false},
+		{"unlocked file of a running server", strconv.Itoa(os.Getpid()), os.Getpid(), false},
+		{"empty file", "", 0, false},
+		{"garbage", "nanodns", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "nanodns.pid")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			pid, err := FindRunning(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FindRunning() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if pid != tt.want {
+				t.Errorf("FindRunning() = %d, want %d", pid, tt.want)
+			}
+
+			// A stale PID file doesn't prevent a start
+			if !tt.wantErr && tt.want == 0 {
+				pidFile, err := AcquirePIDFile(path)
+				if err != nil {
+					t.Fatalf("AcquirePIDFile() over a stale file error = %v", err)
+				}
+				pidFile.Release()
+			}
+		})
+	}
+
+	if pid, err := FindRunning(filepath.Join(t.TempDir(), "missing.pid")); err != nil || pid != 0 {
+		t.Errorf("FindRunning() without a PID file = %d, %v, want 0", pid, err)
+	}
+}
@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// LookupAccount returns the user and group IDs of userName and groupName,
+// which may also be numeric IDs. The group defaults to the user's primary
+// group; an ID is -1 if neither names it.
+func LookupAccount(userName, groupName string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if _, ok := err.(user.UnknownUserError); ok {
+			u, err = user.LookupId(userName)
+		}
+		if err != nil {
+			return -1, -1, fmt.Errorf("unknown user %q", userName)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if _, ok := err.(user.UnknownGroupError); ok {
+			g, err = user.LookupGroupId(groupName)
+		}
+		if err != nil {
+			return -1, -1, fmt.Errorf("unknown group %q", groupName)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
+}
+
+// DropPrivileges confines the process to chroot, if not empty, and switches
+// it to uid and gid, leaving those that are -1 unchanged. The process must
+// run as root, and can't regain its privileges afterwards.
+func DropPrivileges(uid, gid int, chroot string) error {
+	if chroot != "" {
+		if err := syscall.Chroot(chroot); err != nil {
+			return fmt.Errorf("failed to chroot to %s: %w", chroot, err)
+		}
+		if err := os.Chdir("/"); err != nil {
+			return fmt.Errorf("failed to chroot to %s: %w", chroot, err)
+		}
+	}
+	// The group goes first, changing it needs root
+	if gid != -1 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("failed to set supplementary groups: %w", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("failed to switch to group %d: %w", gid, err)
+		}
+	}
+	if uid != -1 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("failed to switch to user %d: %w", uid, err)
+		}
+	}
+	return nil
+}
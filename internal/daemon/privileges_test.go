@@ -0,0 +1,34 @@
+package daemon
+
+import "testing"
+
+func TestLookupAccount(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		group   string
+		wantUID int
+		wantGID int
+		wantErr bool
+	}{
+		{"nothing", "", "", -1, -1, false},
+		{"user with primary group", "root", "", 0, 0, false},
+		{"numeric user", "0", "", 0, 0, false},
+		{"group only", "", "0", -1, 0, false},
+		{"user and group", "root", "0", 0, 0, false},
+		{"unknown user", "nanodns-no-such-user", "", -1, -1, true},
+		{"unknown group", "root", "nanodns-no-such-group", -1, -1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid, gid, err := LookupAccount(tt.user, tt.group)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LookupAccount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if uid != tt.wantUID || gid != tt.wantGID {
+				t.Errorf("LookupAccount() = %d, %d, want %d, %d", uid, gid, tt.wantUID, tt.wantGID)
+			}
+		})
+	}
+}
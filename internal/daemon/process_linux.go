@@ -0,0 +1,18 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// processName returns the executable name of pid as the kernel reports it,
+// truncated to 15 characters. Unlike /proc/<pid>/exe it is readable for
+// processes of other users.
+func processName(pid int) (string, error) {
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(comm)), nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package daemon
+
+import "errors"
+
+// processName can't tell the executable of a process on this system, so a
+// running process with the PID is taken to be the server.
+func processName(pid int) (string, error) {
+	return "", errors.ErrUnsupported
+}
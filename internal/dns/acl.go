@@ -0,0 +1,74 @@
+package dns
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/mguptahub/nanodns/internal/dnstap"
+	"github.com/mguptahub/nanodns/internal/logging"
+	"github.com/mguptahub/nanodns/internal/metrics"
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+// ACL names, as counted in the metrics
+const (
+	aclQuery     = "query"
+	aclRecursion = "recursion"
+	aclTransfer  = "transfer"
+)
+
+// WithACL restricts which clients may query, use the relay, and transfer or
+// update zones.
+func WithACL(acl config.ACLConfig) Option {
+	return func(h *Handler) {
+		h.acl = acl
+	}
+}
+
+// isTransfer reports whether r asks for a zone transfer or a dynamic update.
+func isTransfer(r *dns.Msg) bool {
+	if r.Opcode == dns.OpcodeUpdate {
+		return true
+	}
+	for _, q := range r.Question {
+		if q.Qtype == dns.TypeAXFR || q.Qtype == dns.TypeIXFR {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAccess returns the ACL applying to r from client, and its action.
+func (h *Handler) checkAccess(client netip.Addr, r *dns.Msg) (string, string) {
+	if action := h.acl.Query.Check(client); action != config.ACLAllow {
+		return aclQuery, action
+	}
+	if isTransfer(r) {
+		return aclTransfer, h.acl.Transfer.Check(client)
+	}
+	return aclQuery, config.ACLAllow
+}
+
+// reject answers r with REFUSED, or not at all, as action of the ACL named
+// acl says.
+func (h *Handler) reject(w dns.ResponseWriter, r *dns.Msg, client netip.Addr, acl, action string, start time.Time) {
+	metrics.ObserveACLRejection(acl, action)
+	logging.Debugf("Query from %s rejected by the %s ACL: %s", client, acl, action)
+	if action == config.ACLDeny {
+		return
+	}
+
+	m := new(dns.Msg)
+	m.SetRcode(r, dns.RcodeRefused)
+	if len(r.Question) > 0 {
+		metrics.ObserveQuery(dns.TypeToString[r.Question[0].Qtype], dns.RcodeToString[m.Rcode], metrics.SourceACL)
+	}
+	// Only queries past the query and transfer ACLs were sent to dnstap
+	if h.tap != nil && acl == aclRecursion {
+		tapClient(h.tap, dnstap.ClientResponse, w, m, start)
+	}
+	if err := w.WriteMsg(m); err != nil {
+		logging.Errorf("Error writing DNS response: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+func TestHandlerACL(t *testing.T) {
+	upstream := startTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("198.51.100.1"),
+		})
+		w.WriteMsg(m)
+	}))
+
+	records := map[string][]DNSRecord{
+		"app.example.com.": {{Domain: "app.example.com.", Value: "10.0.0.1", TTL: 60, RecordType: ARecord}},
+	}
+	mustParse := func(value string) config.ACL {
+		acl, err := config.ParseACL(value)
+		if err != nil {
+			t.Fatalf("ParseACL(%q) error = %v", value, err)
+		}
+		return acl
+	}
+	acl := config.ACLConfig{
+		Query:     mustParse("deny 192.0.2.66, refuse 192.0.2.0/24, allow any"),
+		Recursion: mustParse("allow 10.0.0.0/8, deny 172.16.0.0/12"),
+		Transfer:  mustParse("allow 10.0.0.53"),
+	}
+	handler, err := NewHandler(records, config.RelayConfig{
+		Enabled:     true,
+		Nameservers: []string{upstream},
+		Timeout:     time.Second,
+	}, WithACL(acl))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer handler.Close()
+
+	tests := []struct {
+		name      string
+		client    string
+		qname     string
+		qtype     uint16
+		update    bool
+		wantReply bool
+		wantRcode int
+		wantRA    bool
+	}{
+		{"local record", "203.0.113.5", "app.example.com.", dns.TypeA, false, true, dns.RcodeSuccess, false},
+		{"relay allowed", "10.1.2.3", "example.org.", dns.TypeA, false, true, dns.RcodeSuccess, true},
+		{"relay refused", "203.0.113.5", "example.org.", dns.TypeA, false, true, dns.RcodeRefused, false},
+		{"relay dropped", "172.16.0.9", "example.org.", dns.TypeA, false, false, 0, false},
+		{"query refused", "192.0.2.1", "app.example.com.", dns.TypeA, false, true, dns.RcodeRefused, false},
+		{"query dropped", "192.0.2.66", "app.example.com.", dns.TypeA, false, false, 0, false},
+		{"transfer refused", "10.1.2.3", "example.com.", dns.TypeAXFR, false, true, dns.RcodeRefused, false},
+		{"update refused", "10.1.2.3", "example.com.", dns.TypeSOA, true, true, dns.RcodeRefused, false},
+		{"transfer allowed", "10.0.0.53", "app.example.com.", dns.TypeIXFR, false, true, dns.RcodeSuccess, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &mockResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP(tt.client), Port: 5353}}
+			r := new(dns.Msg)
+			r.SetQuestion(tt.qname, tt.qtype)
+			if tt.update {
+				r.SetUpdate(tt.qname)
+			}
+
+			handler.ServeDNS(w, r)
+
+			if !tt.wantReply {
+				if len(w.msgs) != 0 {
+					t.Fatalf("Got a reply with rcode %s, want the query dropped", dns.RcodeToString[w.msgs[0].Rcode])
+				}
+				return
+			}
+			if len(w.msgs) != 1 {
+				t.Fatalf("Got %d replies, want 1", len(w.msgs))
+			}
+			msg := w.msgs[0]
+			if msg.Rcode != tt.wantRcode {
+				t.Errorf("Rcode = %s, want %s", dns.RcodeToString[msg.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if msg.RecursionAvailable != tt.wantRA {
+				t.Errorf("RecursionAvailable = %v, want %v", msg.RecursionAvailable, tt.wantRA)
+			}
+		})
+	}
+}
@@ -0,0 +1,443 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+	"github.com/mguptahub/nanodns/internal/metrics"
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+const (
+	// blockedTTL is the TTL of the answers to blocked names, short enough
+	// for a name taken off a list to work again soon
+	blockedTTL = 60
+
+	// blocklistTimeout bounds the download of a list, and maxBlocklistSize
+	// its size
+	blocklistTimeout = time.Minute
+	maxBlocklistSize = 128 << 20
+)
+
+// hostsNames are the names hosts files give to the local machine, which are
+// never blocked
+var hostsNames = map[string]bool{
+	"localhost.": true, "localhost.localdomain.": true, "local.": true, "broadcasthost.": true,
+	"ip6-localhost.": true, "ip6-loopback.": true, "ip6-localnet.": true, "ip6-mcastprefix.": true,
+	"ip6-allnodes.": true, "ip6-allrouters.": true, "ip6-allhosts.": true,
+}
+
+// Blocklist is a set of blocked domains. Names from hosts files and plain
+// lists are blocked exactly, those from adblock rules ("||example.com^")
+// with their subdomains, which adblock exceptions ("@@||example.com^")
+// allow again.
+type Blocklist struct {
+	exact  map[string]struct{}
+	suffix map[string]struct{}
+	allow  map[string]struct{}
+}
+
+func newBlocklist() *Blocklist {
+	return &Blocklist{
+		exact:  make(map[string]struct{}),
+		suffix: make(map[string]struct{}),
+		allow:  make(map[string]struct{}),
+	}
+}
+
+// ParseBlocklist reads a list in hosts ("0.0.0.0 ads.example.com"), plain
+// ("ads.example.com") or adblock ("||ads.example.com^") format; the formats
+// may be mixed. Comments and the rules that aren't about whole domains, such
+// as adblock rules with options, are skipped.
+func ParseBlocklist(r io.Reader) (*Blocklist, error) {
+	list := newBlocklist()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		list.addLine(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// addLine adds the domains of one line of a list.
+func (l *Blocklist) addLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+		return
+	}
+
+	if rule, ok := strings.CutPrefix(line, "@@||"); ok {
+		if name, ok := adblockDomain(rule); ok {
+			l.allow[name] = struct{}{}
+		}
+		return
+	}
+	if rule, ok := strings.CutPrefix(line, "||"); ok {
+		if name, ok := adblockDomain(rule); ok {
+			l.suffix[name] = struct{}{}
+		}
+		return
+	}
+
+	// Comments follow a space; other '#'s belong to adblock element hiding
+	// rules such as "example.com##.banner"
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		if line[i-1] != ' ' && line[i-1] != '\t' {
+			return
+		}
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) > 1 {
+		// A hosts file line, the address is ignored
+		if _, err := netip.ParseAddr(fields[0]); err != nil {
+			return
+		}
+		fields = fields[1:]
+	}
+	for _, field := range fields {
+		if name, ok := blocklistDomain(field); ok && !hostsNames[name] {
+			l.exact[name] = struct{}{}
+		}
+	}
+}
+
+// adblockDomain returns the domain of an adblock rule without its "||",
+// such as "example.com^". Rules with options or paths are for browsers.
+func adblockDomain(rule string) (string, bool) {
+	name, rest, _ := strings.Cut(rule, "^")
+	if rest != "" && rest != "|" {
+		return "", false
+	}
+	return blocklistDomain(name)
+}
+
+// blocklistDomain returns value as a canonical name, if it is a domain name
+// with at least two labels.
+func blocklistDomain(value string) (string, bool) {
+	if strings.ContainsAny(value, "*/:@$^|") || !strings.Contains(strings.Trim(value, "."), ".") {
+		return "", false
+	}
+	if _, err := netip.ParseAddr(value); err == nil {
+		return "", false
+	}
+	if _, ok := dns.IsDomainName(value); !ok {
+		return "", false
+	}
+	return dns.CanonicalName(value), true
+}
+
+// Len returns the number of blocked domains.
+func (l *Blocklist) Len() int {
+	return len(l.exact) + len(l.suffix)
+}
+
+// Blocked reports whether name is blocked.
+func (l *Blocklist) Blocked(name string) bool {
+	name = dns.CanonicalName(name)
+	_, blocked := l.exact[name]
+	if !blocked && !matchSuffix(l.suffix, name) {
+		return false
+	}
+	return !matchSuffix(l.allow, name)
+}
+
+// matchSuffix reports whether name or one of its parents is in set.
+func matchSuffix(set map[string]struct{}, name string) bool {
+	if len(set) == 0 {
+		return false
+	}
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if _, ok := set[name[off:]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeBlocklists returns the union of lists.
+func mergeBlocklists(lists ...*Blocklist) *Blocklist {
+	merged := newBlocklist()
+	for _, list := range lists {
+		for name := range list.exact {
+			merged.exact[name] = struct{}{}
+		}
+		for name := range list.suffix {
+			merged.suffix[name] = struct{}{}
+		}
+		for name := range list.allow {
+			merged.allow[name] = struct{}{}
+		}
+	}
+	return merged
+}
+
+// BlocklistStatus describes a configured blocklist.
+type BlocklistStatus struct {
+	Source  string // file or URL
+	Domains int
+	Updated time.Time // zero until the list is loaded
+	Err     error     // of the last attempt to load the list
+}
+
+// Blocker answers queries for the names on a set of blocklists, which it
+// loads again periodically. A list that fails to load keeps its previous
+// contents.
+type Blocker struct {
+	cfg    config.BlocklistConfig
+	client *http.Client
+	list   atomic.Pointer[Blocklist]
+
+	loading  sync.Mutex // serializes loads
+	mu       sync.Mutex // guards statuses, never held while downloading
+	statuses map[string]*blocklistSource
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type blocklistSource struct {
+	list *Blocklist
+	BlocklistStatus
+}
+
+// NewBlocker loads the blocklist files of cfg, then downloads its URLs in
+// the background and loads every list again each cfg.Refresh.
+func NewBlocker(cfg config.BlocklistConfig) *Blocker {
+	b := newBlocker(cfg)
+	b.load(cfg.Files)
+	go b.run()
+	return b
+}
+
+func newBlocker(cfg config.BlocklistConfig) *Blocker {
+	b := &Blocker{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: blocklistTimeout},
+		statuses: make(map[string]*blocklistSource),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, source := range b.sources() {
+		b.statuses[source] = &blocklistSource{BlocklistStatus: BlocklistStatus{Source: source}}
+	}
+	b.list.Store(b.merge())
+	return b
+}
+
+// run downloads the lists, then loads every list again each cfg.Refresh
+// until Close.
+func (b *Blocker) run() {
+	defer close(b.done)
+	b.load(b.cfg.URLs)
+	if b.cfg.Refresh <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(b.cfg.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.Refresh()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic refresh.
+func (b *Blocker) Close() {
+	close(b.stop)
+	<-b.done
+}
+
+// Refresh loads every list again.
+func (b *Blocker) Refresh() error {
+	return b.load(b.sources())
+}
+
+// sources returns the files and URLs of the lists.
+func (b *Blocker) sources() []string {
+	return append(append([]string(nil), b.cfg.Files...), b.cfg.URLs...)
+}
+
+// load loads the lists of sources, and replaces the blocked names. The lists
+// are downloaded and parsed before the statuses are locked to swap them in.
+func (b *Blocker) load(sources []string) error {
+	if len(sources) == 0 {
+		return nil
+	}
+	b.loading.Lock()
+	defer b.loading.Unlock()
+
+	lists := make([]*Blocklist, len(sources))
+	fetchErrs := make([]error, len(sources))
+	updated := make([]time.Time, len(sources))
+	for i, source := range sources {
+		lists[i], fetchErrs[i] = b.fetch(source)
+		updated[i] = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var errs []error
+	for i, source := range sources {
+		status := b.statuses[source]
+		status.Err = fetchErrs[i]
+		if err := fetchErrs[i]; err != nil {
+			logging.Warnf("Failed to load blocklist %s, keeping its previous contents: %v", source, err)
+			errs = append(errs, fmt.Errorf("%s: %w", source, err))
+			continue
+		}
+		list := lists[i]
+		status.list, status.Domains, status.Updated = list, list.Len(), updated[i]
+		logging.Infof("Loaded %d domains from blocklist %s", list.Len(), source)
+	}
+
+	merged := b.merge()
+	b.list.Store(merged)
+	metrics.SetBlocklistDomains(merged.Len())
+	return errors.Join(errs...)
+}
+
+// merge returns the union of the loaded lists and the allowed names.
+func (b *Blocker) merge() *Blocklist {
+	lists := make([]*Blocklist, 0, len(b.statuses)+1)
+	for _, status := range b.statuses {
+		if status.list != nil {
+			lists = append(lists, status.list)
+		}
+	}
+	allowed := newBlocklist()
+	for _, name := range b.cfg.Allow {
+		allowed.allow[dns.CanonicalName(name)] = struct{}{}
+	}
+	return mergeBlocklists(append(lists, allowed)...)
+}
+
+// fetch reads the list at source, a file or an http or https URL.
+func (b *Blocker) fetch(source string) (*Blocklist, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ParseBlocklist(f)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-b.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return ParseBlocklist(io.LimitReader(resp.Body, maxBlocklistSize))
+}
+
+// Blocked reports whether name is blocked.
+func (b *Blocker) Blocked(name string) bool {
+	return b.list.Load().Blocked(name)
+}
+
+// Statuses describes the lists in the configured order.
+func (b *Blocker) Statuses() []BlocklistStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var statuses []BlocklistStatus
+	for _, source := range b.sources() {
+		statuses = append(statuses, b.statuses[source].BlocklistStatus)
+	}
+	return statuses
+}
+
+// answer returns the answers to q for a blocked name, and the rcode.
+func (b *Blocker) answer(q dns.Question) ([]dns.RR, int) {
+	if len(b.cfg.Sinkhole) == 0 {
+		return nil, dns.RcodeNameError
+	}
+
+	var answers []dns.RR
+	for _, addr := range b.cfg.Sinkhole {
+		hdr := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Ttl: blockedTTL}
+		switch {
+		case addr.Is4() && q.Qtype == dns.TypeA:
+			hdr.Rrtype = dns.TypeA
+			answers = append(answers, &dns.A{Hdr: hdr, A: addr.AsSlice()})
+		case addr.Is6() && q.Qtype == dns.TypeAAAA:
+			hdr.Rrtype = dns.TypeAAAA
+			answers = append(answers, &dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()})
+		}
+	}
+	return answers, dns.RcodeSuccess
+}
+
+// blocksChain reports whether one of the CNAME targets in answers is
+// blocked, as when a tracker hides behind a CNAME of a first-party name.
+func (b *Blocker) blocksChain(answers []dns.RR) bool {
+	for _, rr := range answers {
+		if cname, ok := rr.(*dns.CNAME); ok && b.Blocked(cname.Target) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithBlocklist answers queries for the names blocker blocks before they
+// are relayed. Names with local records are never blocked.
+func WithBlocklist(blocker *Blocker) Option {
+	return func(h *Handler) {
+		h.blocker = blocker
+	}
+}
+
+// Blocklists describes the blocklists. It returns nil if blocking is
+// disabled.
+func (h *Handler) Blocklists() []BlocklistStatus {
+	if h.blocker == nil {
+		return nil
+	}
+	return h.blocker.Statuses()
+}
+
+// RefreshBlocklists loads every blocklist again.
+func (h *Handler) RefreshBlocklists() error {
+	if h.blocker == nil {
+		return fmt.Errorf("no blocklists are configured")
+	}
+	return h.blocker.Refresh()
+}
@@ -0,0 +1,242 @@
+package dns
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	list, err := ParseBlocklist(strings.NewReader(`# hosts file
+127.0.0.1 localhost
+::1 ip6-localhost ip6-loopback
+0.0.0.0 ads.example.com tracker.example.com # trailing comment
+0.0.0.0 0.0.0.0
+ADS.Example.NET.
+
+! adblock list
+[Adblock Plus 2.0]
+||doubleclick.example^
+||cdn.example.org^
+@@||ok.cdn.example.org^
+||third-party.example^$third-party
+example.info##.banner
+||path.example/ads/*
+`))
+	if err != nil {
+		t.Fatalf("ParseBlocklist() error = %v", err)
+	}
+	if list.Len() != 5 {
+		t.Errorf("Len() = %d, want 5", list.Len())
+	}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"ads.example.com.", true},
+		{"TRACKER.example.com", true},
+		{"sub.ads.example.com.", false}, // hosts entries are exact
+		{"example.com.", false},
+		{"ads.example.net.", true},
+		{"doubleclick.example.", true},
+		{"a.b.doubleclick.example.", true}, // adblock entries cover subdomains
+		{"cdn.example.org.", true},
+		{"ok.cdn.example.org.", false},
+		{"x.ok.cdn.example.org.", false},
+		{"third-party.example.", false},
+		{"example.info.", false},
+		{"path.example.", false},
+		{"localhost.", false},
+	}
+	for _, tt := range tests {
+		if got := list.Blocked(tt.name); got != tt.want {
+			t.Errorf("Blocked(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBlockerRefresh(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(file, []byte("0.0.0.0 file.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	remote := "||remote.example^\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if remote == "" {
+			http.Error(w, "gone", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(remote))
+	}))
+	defer server.Close()
+
+	b := newBlocker(config.BlocklistConfig{
+		Files: []string{file},
+		URLs:  []string{server.URL},
+		Allow: []string{"allowed.remote.example"},
+	})
+	if b.Blocked("file.example.com.") {
+		t.Error("Name blocked before the lists were loaded")
+	}
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	for name, want := range map[string]bool{
+		"file.example.com.":         true,
+		"www.remote.example.":       true,
+		"allowed.remote.example.":   false,
+		"a.allowed.remote.example.": false,
+	} {
+		if got := b.Blocked(name); got != want {
+			t.Errorf("Blocked(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	// A list that fails to load keeps its previous contents
+	os.Remove(file)
+	remote = ""
+	if err := b.Refresh(); err == nil {
+		t.Error("Refresh() of missing lists succeeded")
+	}
+	if !b.Blocked("file.example.com.") || !b.Blocked("remote.example.") {
+		t.Error("Lists that failed to load lost their contents")
+	}
+	statuses := b.Statuses()
+	if len(statuses) != 2 || statuses[0].Source != file || statuses[1].Source != server.URL {
+		t.Fatalf("Statuses() = %+v, want the file then the URL", statuses)
+	}
+	for _, status := range statuses {
+		if status.Err == nil || status.Domains != 1 || status.Updated.IsZero() {
+			t.Errorf("Status = %+v, want the error and the previous contents", status)
+		}
+	}
+}
+
+func TestBlockerStatusesDuringRefresh(t *testing.T) {
+	release := make(chan struct{})
+	requested := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-release
+		w.Write([]byte("||slow.example^\n"))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	b := newBlocker(config.BlocklistConfig{URLs: []string{server.URL}})
+	go b.Refresh()
+	<-requested
+
+	// A slow download doesn't hold up the statuses
+	done := make(chan []BlocklistStatus)
+	go func() { done <- b.Statuses() }()
+	select {
+	case statuses := <-done:
+		if len(statuses) != 1 || statuses[0].Source != server.URL {
+			t.Errorf("Statuses() = %+v, want the URL", statuses)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Statuses() blocked on the download")
+	}
+}
+
+func TestHandlerBlocklist(t *testing.T) {
+	upstream := startTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		name := r.Question[0].Name
+		if name == "cloaked.example.com." {
+			// A first-party name pointing to a tracker
+			m.Answer = append(m.Answer, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+				Target: "tracker.example.net.",
+			})
+			name = "tracker.example.net."
+		}
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("198.51.100.1"),
+		})
+		w.WriteMsg(m)
+	}))
+
+	list := filepath.Join(t.TempDir(), "blocklist")
+	if err := os.WriteFile(list, []byte("ads.example.com\nlocal.example.com\n||example.net^\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	records := map[string][]DNSRecord{
+		"local.example.com.": {{Domain: "local.example.com.", Value: "10.0.0.1", TTL: 60, RecordType: ARecord}},
+	}
+
+	tests := []struct {
+		name      string
+		answer    []netip.Addr
+		qname     string
+		qtype     uint16
+		wantRcode int
+		want      []string
+	}{
+		{"null A", []netip.Addr{netip.IPv4Unspecified(), netip.IPv6Unspecified()}, "ads.example.com.", dns.TypeA, dns.RcodeSuccess, []string{"0.0.0.0"}},
+		{"null AAAA", []netip.Addr{netip.IPv4Unspecified(), netip.IPv6Unspecified()}, "ads.example.com.", dns.TypeAAAA, dns.RcodeSuccess, []string{"::"}},
+		{"null MX", []netip.Addr{netip.IPv4Unspecified(), netip.IPv6Unspecified()}, "ads.example.com.", dns.TypeMX, dns.RcodeSuccess, nil},
+		{"nxdomain", nil, "ads.example.com.", dns.TypeA, dns.RcodeNameError, nil},
+		{"sinkhole without IPv6", []netip.Addr{netip.MustParseAddr("192.168.1.2")}, "ads.example.com.", dns.TypeAAAA, dns.RcodeSuccess, nil},
+		{"sinkhole", []netip.Addr{netip.MustParseAddr("192.168.1.2")}, "ads.example.com.", dns.TypeA, dns.RcodeSuccess, []string{"192.168.1.2"}},
+		{"local record", nil, "local.example.com.", dns.TypeA, dns.RcodeSuccess, []string{"10.0.0.1"}},
+		{"not blocked", nil, "www.example.com.", dns.TypeA, dns.RcodeSuccess, []string{"198.51.100.1"}},
+		{"blocked CNAME target", nil, "cloaked.example.com.", dns.TypeA, dns.RcodeNameError, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocker := newBlocker(config.BlocklistConfig{Files: []string{list}, Sinkhole: tt.answer})
+			if err := blocker.Refresh(); err != nil {
+				t.Fatalf("Refresh() error = %v", err)
+			}
+			handler, err := NewHandler(records, config.RelayConfig{
+				Enabled:     true,
+				Nameservers: []string{upstream},
+				Timeout:     time.Second,
+			}, WithBlocklist(blocker))
+			if err != nil {
+				t.Fatalf("NewHandler() error = %v", err)
+			}
+			defer handler.Close()
+
+			w := &mockResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5353}}
+			r := new(dns.Msg)
+			r.SetQuestion(tt.qname, tt.qtype)
+			handler.ServeDNS(w, r)
+
+			if len(w.msgs) != 1 {
+				t.Fatalf("Got %d replies, want 1", len(w.msgs))
+			}
+			msg := w.msgs[0]
+			if msg.Rcode != tt.wantRcode {
+				t.Errorf("Rcode = %s, want %s", dns.RcodeToString[msg.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			var got []string
+			for _, rr := range msg.Answer {
+				switch rr := rr.(type) {
+				case *dns.A:
+					got = append(got, rr.A.String())
+				case *dns.AAAA:
+					got = append(got, rr.AAAA.String())
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Answers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
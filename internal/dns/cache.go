@@ -0,0 +1,214 @@
+package dns
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// WithRelayCache caches up to size relayed answers until their TTL expires.
+// A size of zero disables the cache.
+func WithRelayCache(size int) Option {
+	return func(h *Handler) {
+		if size > 0 {
+			h.cache = newRelayCache(size)
+		}
+	}
+}
+
+// cacheKey identifies a relayed answer. Answers tailored to a client subnet
+// are cached per subnet.
+type cacheKey struct {
+	name   string // canonical query name
+	qtype  uint16
+	subnet string // ECS subnet sent upstream, empty if none
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	msg      *dns.Msg
+	upstream string
+	stored   time.Time
+	expires  time.Time
+}
+
+// relayCache is a size-bounded LRU cache of upstream answers. Cached
+// messages are never modified; every hit gets a copy with decremented TTLs.
+type relayCache struct {
+	size int
+
+	mu      sync.Mutex
+	lru     *list.List // front is the most recently used entry
+	entries map[cacheKey]*list.Element
+}
+
+func newRelayCache(size int) *relayCache {
+	return &relayCache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[cacheKey]*list.Element),
+	}
+}
+
+// newCacheKey builds the key of a question relayed with ecs, which may be nil.
+func newCacheKey(q dns.Question, ecs *dns.EDNS0_SUBNET) cacheKey {
+	key := cacheKey{name: dns.CanonicalName(q.Name), qtype: q.Qtype}
+	if ecs != nil {
+		key.subnet = fmt.Sprintf("%s/%d", ecs.Address, ecs.SourceNetmask)
+	}
+	return key
+}
+
+// get returns a copy of the cached answer for key and the upstream that sent
+// it, with TTLs lowered by the time spent in the cache.
+func (c *relayCache) get(key cacheKey) (*dns.Msg, string, bool) {
+	if c == nil {
+		return nil, "", false
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, "", false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.remove(elem)
+		c.mu.Unlock()
+		return nil, "", false
+	}
+	c.lru.MoveToFront(elem)
+	c.mu.Unlock()
+
+	msg := entry.msg.Copy()
+	age := uint32(now.Sub(entry.stored) / time.Second)
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if hdr := rr.Header(); hdr.Rrtype != dns.TypeOPT {
+				hdr.Ttl -= min(age, hdr.Ttl)
+			}
+		}
+	}
+	return msg, entry.upstream, true
+}
+
+// set caches msg for as long as its shortest TTL allows. Only successful
+// answers and NXDOMAIN are cached.
+func (c *relayCache) set(key cacheKey, msg *dns.Msg, upstream string) {
+	if c == nil {
+		return
+	}
+	ttl, ok := cacheTTL(msg)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	entry := &cacheEntry{
+		key:      key,
+		msg:      msg.Copy(),
+		upstream: upstream,
+		stored:   now,
+		expires:  now.Add(time.Duration(ttl) * time.Second),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// flush removes the cached answers for name, or all of them if name is
+// empty, and returns how many were removed.
+func (c *relayCache) flush(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if name == "" {
+		n := c.lru.Len()
+		c.lru.Init()
+		c.entries = make(map[cacheKey]*list.Element)
+		return n
+	}
+
+	name = dns.CanonicalName(name)
+	n := 0
+	for key, elem := range c.entries {
+		if key.name == name {
+			c.remove(elem)
+			n++
+		}
+	}
+	return n
+}
+
+// len returns the number of cached answers, expired ones included.
+func (c *relayCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// remove drops elem; the caller holds c.mu.
+func (c *relayCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+// cacheTTL returns how long msg may be cached: the lowest TTL of its records,
+// capped by the SOA minimum for negative answers (RFC 2308).
+func cacheTTL(msg *dns.Msg) (uint32, bool) {
+	if msg.Rcode != dns.RcodeSuccess && msg.Rcode != dns.RcodeNameError {
+		return 0, false
+	}
+	if msg.Truncated {
+		return 0, false
+	}
+
+	var ttl uint32
+	found := false
+	lower := func(v uint32) {
+		if !found || v < ttl {
+			ttl, found = v, true
+		}
+	}
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			lower(rr.Header().Ttl)
+			if soa, ok := rr.(*dns.SOA); ok && len(msg.Answer) == 0 {
+				lower(soa.Minttl)
+			}
+		}
+	}
+	return ttl, found && ttl > 0
+}
+
+// CachedAnswers returns the number of relayed answers in the cache.
+func (h *Handler) CachedAnswers() int {
+	if h.cache == nil {
+		return 0
+	}
+	return h.cache.len()
+}
+
+// FlushCache removes the cached relay answers for name, or every cached
+// answer if name is empty, and returns how many were removed.
+func (h *Handler) FlushCache(name string) (int, error) {
+	if h.cache == nil {
+		return 0, fmt.Errorf("relay cache is disabled")
+	}
+	return h.cache.flush(name), nil
+}
@@ -0,0 +1,163 @@
+package dns
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+func cacheTestAnswer(name string, ttl uint32) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	m.Response = true
+	m.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		A:   net.ParseIP("192.0.2.1"),
+	}}
+	return m
+}
+
+func TestCacheTTL(t *testing.T) {
+	soa := &dns.SOA{
+		Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Ns:     "ns.example.com.",
+		Mbox:   "hostmaster.example.com.",
+		Minttl: 300,
+	}
+	negative := new(dns.Msg)
+	negative.Rcode = dns.RcodeNameError
+	negative.Ns = []dns.RR{soa}
+
+	servfail := cacheTestAnswer("example.com.", 60)
+	servfail.Rcode = dns.RcodeServerFailure
+
+	noRecords := new(dns.Msg)
+	noRecords.Rcode = dns.RcodeNameError
+
+	tests := []struct {
+		name   string
+		msg    *dns.Msg
+		want   uint32
+		wantOK bool
+	}{
+		{"lowest answer TTL", cacheTestAnswer("example.com.", 120), 120, true},
+		{"negative answer uses SOA minimum", negative, 300, true},
+		{"server failure", servfail, 0, false},
+		{"nothing to take a TTL from", noRecords, 0, false},
+		{"zero TTL", cacheTestAnswer("example.com.", 0), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := cacheTTL(tt.msg)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("cacheTTL() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRelayCache(t *testing.T) {
+	c := newRelayCache(2)
+	keyA := newCacheKey(dns.Question{Name: "A.example.com.", Qtype: dns.TypeA}, nil)
+	keyB := newCacheKey(dns.Question{Name: "b.example.com.", Qtype: dns.TypeA}, nil)
+	keyC := newCacheKey(dns.Question{Name: "c.example.com.", Qtype: dns.TypeA}, nil)
+
+	c.set(keyA, cacheTestAnswer("a.example.com.", 60), "192.0.2.53:53")
+	c.set(keyB, cacheTestAnswer("b.example.com.", 60), "192.0.2.53:53")
+
+	// Pretend the first answer has been cached for 10 seconds
+	c.entries[keyA].Value.(*cacheEntry).stored = time.Now().Add(-10 * time.Second)
+	msg, upstream, ok := c.get(keyA)
+	if !ok {
+		t.Fatal("get() missed a cached answer")
+	}
+	if upstream != "192.0.2.53:53" {
+		t.Errorf("get() upstream = %q, want 192.0.2.53:53", upstream)
+	}
+	if ttl := msg.Answer[0].Header().Ttl; ttl != 50 {
+		t.Errorf("Expected TTL 50 after 10 seconds, got %d", ttl)
+	}
+	msg.Answer[0].Header().Ttl = 1
+	if again, _, _ := c.get(keyA); again.Answer[0].Header().Ttl == 1 {
+		t.Error("Modifying a returned answer changed the cached one")
+	}
+
+	// keyB is now the least recently used entry and makes room for keyC
+	c.set(keyC, cacheTestAnswer("c.example.com.", 60), "192.0.2.53:53")
+	if _, _, ok := c.get(keyB); ok {
+		t.Error("Least recently used entry was not evicted")
+	}
+	if c.len() != 2 {
+		t.Errorf("Expected 2 cached answers, got %d", c.len())
+	}
+
+	c.entries[keyC].Value.(*cacheEntry).expires = time.Now()
+	if _, _, ok := c.get(keyC); ok {
+		t.Error("get() returned an expired answer")
+	}
+
+	if n := c.flush("a.EXAMPLE.com"); n != 1 {
+		t.Errorf("flush(name) removed %d answers, want 1", n)
+	}
+	c.set(keyB, cacheTestAnswer("b.example.com.", 60), "192.0.2.53:53")
+	if n := c.flush(""); n != 1 || c.len() != 0 {
+		t.Errorf("flush(\"\") removed %d answers, %d left", n, c.len())
+	}
+}
+
+func TestHandlerRelayCache(t *testing.T) {
+	var exchanges atomic.Int32
+	upstream := startTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		exchanges.Add(1)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = cacheTestAnswer(r.Question[0].Name, 60).Answer
+		w.WriteMsg(m)
+	}))
+
+	relayConfig := config.RelayConfig{Enabled: true, Nameservers: []string{upstream}, Timeout: time.Second}
+	handler, err := NewHandler(nil, relayConfig, WithRelayCache(10))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer handler.Close()
+
+	query := func() *dns.Msg {
+		w := &mockResponseWriter{msgs: make([]*dns.Msg, 0)}
+		r := new(dns.Msg)
+		r.SetQuestion("relayed.example.com.", dns.TypeA)
+		handler.ServeDNS(w, r)
+		return w.msgs[0]
+	}
+
+	for i := 0; i < 3; i++ {
+		if msg := query(); len(msg.Answer) != 1 {
+			t.Fatalf("Expected 1 answer, got %d", len(msg.Answer))
+		}
+	}
+	if n := exchanges.Load(); n != 1 {
+		t.Errorf("Expected 1 upstream exchange, got %d", n)
+	}
+	if n := handler.CachedAnswers(); n != 1 {
+		t.Errorf("CachedAnswers() = %d, want 1", n)
+	}
+
+	if n, err := handler.FlushCache("relayed.example.com."); err != nil || n != 1 {
+		t.Errorf("FlushCache() = %d, %v, want 1, nil", n, err)
+	}
+	query()
+	if n := exchanges.Load(); n != 2 {
+		t.Errorf("Expected the flushed name to be relayed again, got %d exchanges", n)
+	}
+
+	uncached, _ := NewHandler(nil, relayConfig)
+	defer uncached.Close()
+	if _, err := uncached.FlushCache(""); err == nil {
+		t.Error("FlushCache() without a cache succeeded")
+	}
+}
@@ -0,0 +1,66 @@
+package dns
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/mguptahub/nanodns/internal/dnstap"
+	"github.com/miekg/dns"
+)
+
+// WithDnstap logs client queries and responses, and the exchanges with
+// upstream nameservers, to tap.
+func WithDnstap(tap *dnstap.Writer) Option {
+	return func(h *Handler) {
+		h.tap = tap
+	}
+}
+
+// tapClient logs a client query or response.
+func tapClient(tap *dnstap.Writer, typ dnstap.MessageType, w dns.ResponseWriter, msg *dns.Msg, queryTime time.Time) {
+	m := &dnstap.Message{
+		Type:         typ,
+		Protocol:     protocol(w.RemoteAddr()),
+		QueryAddr:    dnstap.AddrPort(w.RemoteAddr()),
+		ResponseAddr: dnstap.AddrPort(w.LocalAddr()),
+		QueryTime:    queryTime,
+	}
+	if !setTapMessage(m, msg) {
+		return
+	}
+	tap.Log(m)
+}
+
+// tapForwarder logs a query sent to, or a response received from, an
+// upstream nameserver.
+func tapForwarder(tap *dnstap.Writer, typ dnstap.MessageType, server string, msg *dns.Msg, queryTime time.Time) {
+	m := &dnstap.Message{
+		Type:      typ,
+		Protocol:  "udp",
+		QueryTime: queryTime,
+	}
+	if addr, err := netip.ParseAddrPort(server); err == nil {
+		m.ResponseAddr = addr
+	}
+	if !setTapMessage(m, msg) {
+		return
+	}
+	tap.Log(m)
+}
+
+// setTapMessage packs msg into the query or response field of m, returning
+// false if it can't be packed.
+func setTapMessage(m *dnstap.Message, msg *dns.Msg) bool {
+	wire, err := msg.Pack()
+	if err != nil {
+		return false
+	}
+	switch m.Type {
+	case dnstap.ClientResponse, dnstap.ForwarderResponse:
+		m.ResponseTime = time.Now()
+		m.ResponseMessage = wire
+	default:
+		m.QueryMessage = wire
+	}
+	return true
+}
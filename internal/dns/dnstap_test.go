@@ -0,0 +1,62 @@
+package dns
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mguptahub/nanodns/internal/dnstap"
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+func TestHandlerDnstap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nanodns.dnstap")
+	tap, err := dnstap.NewFileWriter(path, "test", "test")
+	if err != nil {
+		t.Fatalf("NewFileWriter() error = %v", err)
+	}
+
+	records := map[string][]DNSRecord{
+		"example.com.": {{Domain: "example.com.", Value: "192.0.2.1", TTL: 60, RecordType: ARecord}},
+	}
+	acl, err := config.ParseACL("refuse 192.0.2.66, allow any")
+	if err != nil {
+		t.Fatalf("ParseACL() error = %v", err)
+	}
+	handler, err := NewHandler(records, config.RelayConfig{}, WithDnstap(tap), WithACL(config.ACLConfig{Query: acl}))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer handler.Close()
+
+	w := &mockResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 5353}}
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	handler.ServeDNS(w, r)
+
+	// Queries refused by the ACLs are kept out of dnstap
+	refusedW := &mockResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP("192.0.2.66"), Port: 5353}}
+	refused := new(dns.Msg)
+	refused.SetQuestion("refused.example.com.", dns.TypeA)
+	handler.ServeDNS(refusedW, refused)
+	tap.Close()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, _ := r.Pack()
+	response, _ := w.msgs[0].Pack()
+	if !bytes.Contains(content, query) {
+		t.Error("dnstap output lacks the client query")
+	}
+	if !bytes.Contains(content, response) {
+		t.Error("dnstap output lacks the client response")
+	}
+	if bytes.Contains(content, []byte("\x07refused")) {
+		t.Error("dnstap output holds a refused query")
+	}
+}
@@ -0,0 +1,125 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+// ECS address families as defined by RFC 7871
+const (
+	ecsFamilyIPv4 = 1
+	ecsFamilyIPv6 = 2
+)
+
+// WithECS configures EDNS Client Subnet handling for view selection and relaying.
+func WithECS(ecs config.ECSConfig) Option {
+	return func(h *Handler) {
+		h.ecs = ecs
+	}
+}
+
+// findECS returns the EDNS Client Subnet option of msg, if any.
+func findECS(msg *dns.Msg) *dns.EDNS0_SUBNET {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return ecs
+		}
+	}
+	return nil
+}
+
+// ecsAddr returns the network address carried by an ECS option.
+func ecsAddr(ecs *dns.EDNS0_SUBNET) netip.Addr {
+	addr, ok := netip.AddrFromSlice(ecs.Address)
+	if !ok {
+		return netip.Addr{}
+	}
+	addr = addr.Unmap()
+	if prefix, err := addr.Prefix(int(ecs.SourceNetmask)); err == nil {
+		return prefix.Addr()
+	}
+	return addr
+}
+
+// newECS builds an ECS option for addr truncated to the configured prefix length.
+func (h *Handler) newECS(addr netip.Addr) *dns.EDNS0_SUBNET {
+	family, bits := uint16(ecsFamilyIPv4), h.ecs.IPv4Prefix
+	if addr.Is6() {
+		family, bits = ecsFamilyIPv6, h.ecs.IPv6Prefix
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return nil
+	}
+
+	return &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: uint8(bits),
+		Address:       net.IP(prefix.Addr().AsSlice()),
+	}
+}
+
+// trustsECS reports whether the ECS option sent by client may be used in
+// place of the client's own address.
+func (h *Handler) trustsECS(client netip.Addr) bool {
+	if !client.IsValid() {
+		return false
+	}
+	for _, network := range h.ecs.Trusted {
+		if network.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// relayECS returns the ECS option to send upstream for a query from client
+// carrying reqECS, according to the relay ECS mode.
+func (h *Handler) relayECS(client netip.Addr, reqECS *dns.EDNS0_SUBNET) *dns.EDNS0_SUBNET {
+	switch h.ecs.RelayMode {
+	case config.ECSPass:
+		return reqECS
+	case config.ECSAdd:
+		if reqECS != nil && h.trustsECS(client) {
+			return reqECS
+		}
+		// Never reveal addresses that are meaningless outside this network
+		if client.IsValid() && client.IsGlobalUnicast() && !client.IsPrivate() {
+			return h.newECS(client)
+		}
+	}
+	return nil
+}
+
+// setECS adds an ECS option to msg, creating its OPT record if needed.
+func setECS(msg *dns.Msg, ecs *dns.EDNS0_SUBNET) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(dns.DefaultMsgSize, false)
+		opt = msg.IsEdns0()
+	}
+	opt.Option = append(opt.Option, ecs)
+}
+
+// responseECS builds the ECS option echoed to a client that sent reqECS,
+// with scope as the prefix length the answer is valid for.
+func responseECS(reqECS *dns.EDNS0_SUBNET, scope uint8) *dns.EDNS0_SUBNET {
+	if scope > reqECS.SourceNetmask {
+		scope = reqECS.SourceNetmask
+	}
+	return &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        reqECS.Family,
+		SourceNetmask: reqECS.SourceNetmask,
+		SourceScope:   scope,
+		Address:       reqECS.Address,
+	}
+}
@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+func newECSQuery(qname, subnet string, bits uint8) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetQuestion(qname, dns.TypeA)
+	r.SetEdns0(dns.DefaultMsgSize, false)
+	ip := net.ParseIP(subnet)
+	family := uint16(ecsFamilyIPv4)
+	if ip.To4() == nil {
+		family = ecsFamilyIPv6
+	}
+	setECS(r, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: bits,
+		Address:       ip,
+	})
+	return r
+}
+
+func TestHandlerECSViewSelection(t *testing.T) {
+	records := map[string][]DNSRecord{
+		"api.example.com.": {
+			{Domain: "api.example.com.", Value: "203.0.113.10", TTL: 300, RecordType: ARecord},
+			{Domain: "api.example.com.", Value: "10.0.0.10", TTL: 300, RecordType: ARecord, View: "office"},
+		},
+	}
+	views := []config.ViewConfig{
+		{Name: "office", Networks: []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")}},
+	}
+	ecs := config.ECSConfig{
+		Trusted:    []netip.Prefix{netip.MustParsePrefix("192.0.2.53/32")},
+		RelayMode:  config.ECSStrip,
+		IPv4Prefix: 24,
+		IPv6Prefix: 56,
+	}
+
+	handler, err := NewHandler(records, config.RelayConfig{Enabled: false}, WithViews(views), WithECS(ecs))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+
+	testCases := []struct {
+		name      string
+		remote    string
+		subnet    string
+		expected  string
+		wantScope uint8
+	}{
+		{"trusted forwarder with office subnet", "192.0.2.53", "198.51.100.0", "10.0.0.10", 24},
+		{"trusted forwarder with other subnet", "192.0.2.53", "203.0.113.0", "203.0.113.10", 24},
+		{"untrusted client", "192.0.2.99", "198.51.100.0", "203.0.113.10", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &mockResponseWriter{msgs: make([]*dns.Msg, 0), remote: &net.UDPAddr{IP: net.ParseIP(tc.remote)}}
+			handler.ServeDNS(w, newECSQuery("api.example.com.", tc.subnet, 24))
+
+			msg := w.msgs[0]
+			if len(msg.Answer) != 1 {
+				t.Fatalf("Expected 1 answer, got %d", len(msg.Answer))
+			}
+			if a := msg.Answer[0].(*dns.A); a.A.String() != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, a.A.String())
+			}
+
+			respECS := findECS(msg)
+			if respECS == nil {
+				t.Fatal("Expected ECS option in response")
+			}
+			if respECS.SourceScope != tc.wantScope {
+				t.Errorf("Expected scope %d, got %d", tc.wantScope, respECS.SourceScope)
+			}
+			if !respECS.Address.Equal(net.ParseIP(tc.subnet)) {
+				t.Errorf("Expected echoed address %s, got %s", tc.subnet, respECS.Address)
+			}
+		})
+	}
+}
+
+func TestHandlerRelayECS(t *testing.T) {
+	trustedECS := &dns.EDNS0_SUBNET{Family: ecsFamilyIPv4, SourceNetmask: 24, Address: net.ParseIP("198.51.100.0")}
+	forwarder := netip.MustParseAddr("192.0.2.53")
+	publicClient := netip.MustParseAddr("203.0.113.77")
+	privateClient := netip.MustParseAddr("10.1.2.3")
+
+	testCases := []struct {
+		name     string
+		mode     string
+		client   netip.Addr
+		reqECS   *dns.EDNS0_SUBNET
+		expected string
+	}{
+		{"strip drops client ECS", config.ECSStrip, forwarder, trustedECS, ""},
+		{"pass forwards client ECS", config.ECSPass, publicClient, trustedECS, "198.51.100.0/24"},
+		{"pass without client ECS", config.ECSPass, publicClient, nil, ""},
+		{"add forwards trusted ECS", config.ECSAdd, forwarder, trustedECS, "198.51.100.0/24"},
+		{"add replaces untrusted ECS", config.ECSAdd, publicClient, trustedECS, "203.0.113.0/24"},
+		{"add builds ECS from client", config.ECSAdd, publicClient, nil, "203.0.113.0/24"},
+		{"add skips private clients", config.ECSAdd, privateClient, nil, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, _ := NewHandler(nil, config.RelayConfig{Enabled: false}, WithECS(config.ECSConfig{
+				Trusted:    []netip.Prefix{netip.MustParsePrefix("192.0.2.53/32")},
+				RelayMode:  tc.mode,
+				IPv4Prefix: 24,
+				IPv6Prefix: 56,
+			}))
+
+			ecs := handler.relayECS(tc.client, tc.reqECS)
+			var got string
+			if ecs != nil {
+				got = netip.PrefixFrom(netip.MustParseAddr(ecs.Address.String()), int(ecs.SourceNetmask)).String()
+			}
+			if got != tc.expected {
+				t.Errorf("relayECS() = %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestHandlerEchoesEDNS(t *testing.T) {
+	handler, _ := NewHandler(nil, config.RelayConfig{Enabled: false})
+
+	w := &mockResponseWriter{msgs: make([]*dns.Msg, 0)}
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	r.SetEdns0(4096, true)
+	handler.ServeDNS(w, r)
+
+	opt := w.msgs[0].IsEdns0()
+	if opt == nil {
+		t.Fatal("Expected OPT record in response to an EDNS query")
+	}
+	if !opt.Do() || opt.UDPSize() != ednsUDPSize {
+		t.Errorf("Unexpected OPT record %v", opt)
+	}
+	if findECS(w.msgs[0]) != nil {
+		t.Error("Expected no ECS option when the query had none")
+	}
+}
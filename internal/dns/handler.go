@@ -2,36 +2,48 @@ package dns
 
 import (
 	"fmt"
-	"log"
-	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/mguptahub/nanodns/internal/dnstap"
+	"github.com/mguptahub/nanodns/internal/logging"
+	"github.com/mguptahub/nanodns/internal/metrics"
 	"github.com/mguptahub/nanodns/pkg/config"
 	"github.com/miekg/dns"
 )
 
 type Handler struct {
-	records map[string][]DNSRecord
-	relay   *RelayClient
+	state    atomic.Pointer[handlerState] // replaced as a whole on reload
+	reloadMu sync.Mutex                   // serializes Reload and Close
+	relay    *RelayClient
+	cache    *relayCache // nil unless relayed answers are cached
+
+	wildcardCapture string
+	viewConfigs     []config.ViewConfig
+	ecs             config.ECSConfig
+	policies        map[string]*AnswerPolicy
+	acl             config.ACLConfig
+	blocker         *Blocker       // nil unless blocklists are configured
+	tap             *dnstap.Writer // nil unless dnstap is enabled
 }
 
-func NewHandler(records map[string][]DNSRecord, relayConfig config.RelayConfig) (*Handler, error) {
-	// Normalize all record names to lowercase and ensure they're fully qualified
-	normalizedRecords := make(map[string][]DNSRecord)
-	for k, v := range records {
-		normalizedKey := dns.CanonicalName(k)
-		normalizedRecords[strings.ToLower(normalizedKey)] = make([]DNSRecord, len(v))
-		for i, rec := range v {
-			// Create a copy of the record
-			newRec := rec
-			// Ensure the value is fully qualified for CNAME records
-			if rec.RecordType == CNAMERecord {
-				newRec.Value = dns.CanonicalName(rec.Value)
-			}
-			normalizedRecords[strings.ToLower(normalizedKey)][i] = newRec
-		}
+// ednsUDPSize is the UDP payload size advertised to EDNS clients
+const ednsUDPSize = 1232
+
+// Option configures optional Handler behaviour.
+type Option func(*Handler)
+
+// WithWildcardCapture sets what replaces "*" in wildcard CNAME targets,
+// either config.WildcardCaptureFull or config.WildcardCaptureFirst.
+func WithWildcardCapture(mode string) Option {
+	return func(h *Handler) {
+		h.wildcardCapture = mode
 	}
+}
 
+func NewHandler(records map[string][]DNSRecord, relayConfig config.RelayConfig, opts ...Option) (*Handler, error) {
 	var relay *RelayClient
 	if relayConfig.Enabled {
 		var err error
@@ -41,31 +53,111 @@ func NewHandler(records map[string][]DNSRecord, relayConfig config.RelayConfig)
 		}
 	}
 
-	return &Handler{
-		records: normalizedRecords,
-		relay:   relay,
-	}, nil
+	h := &Handler{
+		relay:           relay,
+		wildcardCapture: config.WildcardCaptureFull,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if relay != nil {
+		relay.tap = h.tap
+	}
+
+	st, err := buildState(records, h.viewConfigs, h.policies)
+	if err != nil {
+		return nil, err
+	}
+	h.state.Store(st)
+	st.health.start()
+	return h, nil
+}
+
+// Ready returns nil when the handler can answer queries it has to relay: at
+// least one upstream nameserver must be reachable if relaying is enabled.
+func (h *Handler) Ready() error {
+	if h.relay == nil {
+		return nil
+	}
+	return h.relay.Check()
+}
+
+// Upstreams probes the upstream nameservers. It returns nil if relaying is
+// disabled.
+func (h *Handler) Upstreams() []UpstreamStatus {
+	if h.relay == nil {
+		return nil
+	}
+	return h.relay.Upstreams()
+}
+
+// Close stops the background health checks.
+func (h *Handler) Close() {
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+	h.state.Load().health.close()
 }
 
 func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
+
+	// Check the access control lists before anything else, so rejected
+	// queries don't reach dnstap either
+	client := clientAddr(w.RemoteAddr())
+	if acl, action := h.checkAccess(client, r); action != config.ACLAllow && !selfProbe(w, r) {
+		h.reject(w, r, client, acl, action, start)
+		return
+	}
+	if h.tap != nil {
+		tapClient(h.tap, dnstap.ClientQuery, w, r, start)
+	}
+	recursion := h.acl.Recursion.Check(client)
+
 	m := new(dns.Msg)
 	m.SetReply(r)
 	m.Authoritative = true
 	m.Compress = true
+	m.RecursionAvailable = h.relay != nil && recursion == config.ACLAllow
+
+	// Pick the records visible to this client, or to the client subnet a
+	// trusted forwarder sent on its behalf
+	viewAddr := client
+	reqECS := findECS(r)
+	st := h.state.Load()
+	var ecsScope uint8
+	if reqECS != nil && h.trustsECS(client) {
+		viewAddr = ecsAddr(reqECS)
+		if len(st.views) > 0 {
+			ecsScope = reqECS.SourceNetmask
+		}
+	}
+	index := st.indexFor(viewAddr)
+	source := metrics.SourceLocal
+	var upstream string
 
 	for _, q := range r.Question {
-		log.Printf("Query for %s (type: %v)", q.Name, dns.TypeToString[q.Qtype])
+		logging.Debugf("Query for %s (type: %v)", q.Name, dns.TypeToString[q.Qtype])
 
-		// Try to find matching records
-		matchingRecords := h.findMatchingRecords(q.Name)
-		log.Printf("Found %d matching records for %s", len(matchingRecords), q.Name)
+		if isProbe(q) {
+			m.Answer = append(m.Answer, probeAnswer(q))
+			continue
+		}
 
-		// Domain exists (found matching records)
-		if len(matchingRecords) > 0 {
-			answers := h.processRecords(q, matchingRecords)
+		// Try to find matching records
+		match := h.findMatchingRecords(index, q.Name)
+		logging.Debugf("Found %d matching records for %s", len(match.records), q.Name)
+
+		// Domain exists (found matching records, or an empty non-terminal).
+		// Every ancestor of a local record is an empty non-terminal, so with a
+		// relay those are relayed rather than answered with no data.
+		if len(match.records) > 0 || (match.exists && h.relay == nil) {
+			if match.wildcard {
+				source = metrics.SourceWildcard
+			}
+			answers := h.processRecords(index, q, match)
 			if len(answers) > 0 {
 				m.Answer = append(m.Answer, answers...)
-				log.Printf("Added %d answers for %s", len(answers), q.Name)
+				logging.Debugf("Added %d answers for %s", len(answers), q.Name)
 				continue // Skip relay if we have local answers
 			}
 			// Domain exists but no matching record type - return NOERROR with no answers
@@ -73,23 +165,50 @@ func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			continue
 		}
 
-		// Domain doesn't exist locally - try relay if enabled
+		// Names on a blocklist are answered here rather than relayed
+		if h.blocker != nil && h.blocker.Blocked(q.Name) {
+			source = metrics.SourceBlocked
+			answers, rcode := h.blocker.answer(q)
+			m.Answer = append(m.Answer, answers...)
+			m.Rcode = rcode
+			continue
+		}
+
+		// Domain doesn't exist locally - try relay if enabled and allowed
+		if h.relay != nil && recursion != config.ACLAllow {
+			h.reject(w, r, client, aclRecursion, recursion, start)
+			return
+		}
 		if h.relay != nil {
-			log.Printf("No local records found for %s, attempting relay", q.Name)
+			logging.Debugf("No local records found for %s, attempting relay", q.Name)
+			source = metrics.SourceRelay
 
 			relayReq := new(dns.Msg)
 			relayReq.SetQuestion(q.Name, q.Qtype)
 			relayReq.RecursionDesired = true
+			ecs := h.relayECS(client, reqECS)
+			if ecs != nil {
+				setECS(relayReq, ecs)
+			}
 
-			relayResp, err := h.relay.Relay(relayReq)
-			if err != nil {
-				log.Printf("Relay failed: %v", err)
-				m.Rcode = dns.RcodeNameError // Return NXDOMAIN on relay failure
-				continue
+			key := newCacheKey(q, ecs)
+			relayResp, ns, cached := h.cache.get(key)
+			if cached {
+				source = metrics.SourceCache
+			} else {
+				var err error
+				relayResp, ns, err = h.relay.relay(relayReq)
+				if err != nil {
+					logging.Warnf("Relay failed: %v", err)
+					m.Rcode = dns.RcodeNameError // Return NXDOMAIN on relay failure
+					continue
+				}
+				h.cache.set(key, relayResp, ns)
 			}
+			upstream = ns
 
 			if relayResp.Rcode != dns.RcodeSuccess {
-				log.Printf("Relay returned non-success code: %v", dns.RcodeToString[relayResp.Rcode])
+				logging.Debugf("Relay returned non-success code: %v", dns.RcodeToString[relayResp.Rcode])
 				// Convert SERVFAIL to NXDOMAIN when appropriate
 				if relayResp.Rcode == dns.RcodeServerFailure {
 					m.Rcode = dns.RcodeNameError
@@ -99,9 +218,26 @@ func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 				continue
 			}
 
+			// A CNAME may lead a name that isn't blocked to one that is
+			if h.blocker != nil && h.blocker.blocksChain(relayResp.Answer) {
+				source = metrics.SourceBlocked
+				answers, rcode := h.blocker.answer(q)
+				m.Answer = append(m.Answer, answers...)
+				m.Rcode = rcode
+				continue
+			}
+
 			m.Answer = append(m.Answer, relayResp.Answer...)
 			m.Ns = append(m.Ns, relayResp.Ns...)
-			m.Extra = append(m.Extra, relayResp.Extra...)
+			for _, rr := range relayResp.Extra {
+				// The upstream OPT record is replaced by our own
+				if rr.Header().Rrtype != dns.TypeOPT {
+					m.Extra = append(m.Extra, rr)
+				}
+			}
+			if upstreamECS := findECS(relayResp); upstreamECS != nil {
+				ecsScope = upstreamECS.SourceScope
+			}
 
 			if len(relayResp.Answer) > 0 {
 				m.Authoritative = false
@@ -112,214 +248,176 @@ func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		}
 	}
 
+	// EDNS queries get an OPT record back, echoing the client subnet if one was sent
+	if opt := r.IsEdns0(); opt != nil {
+		m.SetEdns0(ednsUDPSize, opt.Do())
+		if reqECS != nil {
+			setECS(m, responseECS(reqECS, ecsScope))
+		}
+	}
+
+	if len(r.Question) > 0 {
+		metrics.ObserveQuery(dns.TypeToString[r.Question[0].Qtype], dns.RcodeToString[m.Rcode], source)
+	}
+
+	if h.tap != nil {
+		tapClient(h.tap, dnstap.ClientResponse, w, m, start)
+	}
 	if err := w.WriteMsg(m); err != nil {
-		log.Printf("Error writing DNS response: %v", err)
+		logging.Errorf("Error writing DNS response: %v", err)
 	} else {
-		log.Printf("Successfully wrote DNS response with %d answers", len(m.Answer))
+		logging.Debugf("Successfully wrote DNS response with %d answers", len(m.Answer))
+	}
+
+	if len(r.Question) > 0 && logging.QueryLogEnabled() {
+		q := r.Question[0]
+		var clientIP string
+		if client.IsValid() {
+			clientIP = client.String()
+		}
+		logging.LogQuery(logging.QueryEvent{
+			Time:     start,
+			Client:   clientIP,
+			Protocol: protocol(w.RemoteAddr()),
+			Name:     q.Name,
+			Type:     dns.TypeToString[q.Qtype],
+			Rcode:    dns.RcodeToString[m.Rcode],
+			Source:   source,
+			Answers:  len(m.Answer),
+			Latency:  time.Since(start),
+			Upstream: upstream,
+		})
 	}
 }
 
-func (h *Handler) processRecords(q dns.Question, records []DNSRecord) []dns.RR {
+func (h *Handler) processRecords(index *recordIndex, q dns.Question, match recordMatch) []dns.RR {
 	var answers []dns.RR
 
-	for _, rec := range records {
+	for _, rec := range match.records {
 		switch rec.RecordType {
 		case CNAMERecord:
 			// Always add CNAME record first
-			if cname := h.createCNAMERecord(q, rec); cname != nil {
-				answers = append(answers, cname)
-				log.Printf("Added CNAME record: %v", cname)
-
-				// If query was for A record and we have a CNAME, try to resolve the target
-				if q.Qtype == dns.TypeA {
-					// Ensure CNAME target is fully qualified
-					target := dns.CanonicalName(rec.Value)
-					// Look for A record matching CNAME target
-					targetRecords := h.findMatchingRecords(target)
-					for _, targetRec := range targetRecords {
-						if targetRec.RecordType == ARecord {
-							if a := h.createARecord(dns.Question{
-								Name:   q.Name,
-								Qtype:  q.Qtype,
-								Qclass: q.Qclass,
-							}, targetRec); a != nil {
-								answers = append(answers, a)
-								log.Printf("Added A record for CNAME target: %v", a)
-							}
-						}
-					}
-				}
-			}
-		case ARecord:
-			// Only add A record if specifically queried for it
+			cname := h.cnameAnswer(q.Name, rec, match)
+			answers = append(answers, cname)
+
+			// If query was for A record and we have a CNAME, try to resolve the target
 			if q.Qtype == dns.TypeA {
-				if a := h.createARecord(q, rec); a != nil {
-					answers = append(answers, a)
-					log.Printf("Added A record: %v", a)
-				}
+				target := cname.(*dns.CNAME).Target
+				answers = h.appendAddresses(index, answers, target)
 			}
 		case MXRecord:
 			// Only add MX record if specifically queried for it
 			if q.Qtype == dns.TypeMX {
-				if mx := h.createMXRecord(q, rec); mx != nil {
-					answers = append(answers, mx)
-					log.Printf("Added MX record: %v", mx)
-
-					// Optionally resolve the MX target's A record
-					targetRecords := h.findMatchingRecords(rec.Value)
-					for _, targetRec := range targetRecords {
-						if targetRec.RecordType == ARecord {
-							// Create an additional A record for the MX server
-							if a := h.createARecord(dns.Question{
-								Name:   rec.Value,
-								Qtype:  dns.TypeA,
-								Qclass: q.Qclass,
-							}, targetRec); a != nil {
-								answers = append(answers, a)
-								log.Printf("Added A record for MX target: %v", a)
-							}
-						}
-					}
-				}
+				answers = append(answers, rec.answer(q.Name))
+				// Optionally resolve the MX target's A record
+				answers = h.appendAddresses(index, answers, rec.Value)
 			}
 		case TXTRecord:
 			// Only add TXT record if specifically queried for it
 			if q.Qtype == dns.TypeTXT {
-				if txt := h.createTXTRecord(q, rec); txt != nil {
-					answers = append(answers, txt)
-					log.Printf("Added TXT record: %v", txt)
-				}
+				answers = append(answers, rec.answer(q.Name))
 			}
 		}
 	}
 
+	// Only add A records if specifically queried for them
+	if q.Qtype == dns.TypeA {
+		answers = h.appendAddressRecords(answers, q.Name, match.records)
+	}
+
 	return answers
 }
 
-// findMatchingRecords finds all records that match the query name, including wildcard matches
-func (h *Handler) findMatchingRecords(queryName string) []DNSRecord {
-	// Normalize query name to lowercase and ensure it's fully qualified
-	queryName = dns.CanonicalName(queryName)
-	queryName = strings.ToLower(queryName)
-	log.Printf("Looking for matches for normalized query: %s", queryName)
-
-	// First try exact match
-	if recs, exists := h.records[queryName]; exists {
-		log.Printf("Found exact match for %s", queryName)
-		return recs
-	}
+// appendAddresses appends the local A records of name, owned by name, to answers.
+func (h *Handler) appendAddresses(index *recordIndex, answers []dns.RR, name string) []dns.RR {
+	return h.appendAddressRecords(answers, name, h.findMatchingRecords(index, name).records)
+}
 
-	// Try wildcard matching
-	labels := dns.SplitDomainName(queryName)
-	if len(labels) <= 1 {
-		return nil
+// appendAddressRecords appends the answers of the A records among recs that
+// are currently served, owned by name and ordered by the answer policy of
+// their owner, to answers.
+func (h *Handler) appendAddressRecords(answers []dns.RR, name string, recs []compiledRecord) []dns.RR {
+	served := healthyAddresses(addressRecords(recs))
+	if len(served) > 0 && served[0].policy != nil {
+		served = served[0].policy.apply(served)
 	}
 
-	// Try the wildcard pattern
-	wildcardName := dns.CanonicalName("*." + strings.Join(labels[1:], "."))
-	wildcardName = strings.ToLower(wildcardName)
-	log.Printf("Trying wildcard pattern: %s", wildcardName)
-
-	if recs, exists := h.records[wildcardName]; exists {
-		log.Printf("Found wildcard match: %s", wildcardName)
-		// For each matching wildcard record, create a concrete version
-		concreteRecords := make([]DNSRecord, 0, len(recs))
-		for _, rec := range recs {
-			newRec := rec
-			if rec.RecordType == CNAMERecord {
-				// For CNAME records, replace the wildcard in the target if it exists
-				if strings.HasPrefix(rec.Value, "*.") {
-					// Replace the * with the actual subdomain and ensure it's fully qualified
-					newValue := strings.Replace(rec.Value, "*", labels[0], 1)
-					newRec.Value = dns.CanonicalName(newValue)
-				} else {
-					newRec.Value = dns.CanonicalName(rec.Value)
-				}
-			}
-			concreteRecords = append(concreteRecords, newRec)
+	for _, rec := range served {
+		if a := rec.answer(name); a != nil {
+			answers = append(answers, a)
 		}
-		return concreteRecords
 	}
-
-	log.Printf("No wildcard match found for %s", wildcardName)
-	return nil
+	return answers
 }
 
-func (h *Handler) createARecord(q dns.Question, rec DNSRecord) dns.RR {
-	var ip net.IP
-	if rec.IsService {
-		resolvedIP, err := ResolveServiceIP(rec.Value)
-		if err != nil {
-			log.Printf("Failed to resolve service %s: %v", rec.Value, err)
-			return nil
+// addressRecords returns the A records among recs, without copying when
+// recs holds nothing else.
+func addressRecords(recs []compiledRecord) []compiledRecord {
+	n := 0
+	for _, rec := range recs {
+		if rec.RecordType == ARecord {
+			n++
 		}
-		ip = net.ParseIP(resolvedIP)
-	} else {
-		ip = net.ParseIP(rec.Value)
 	}
-
-	if ip == nil {
-		log.Printf("Invalid IP address for %s", rec.Value)
-		return nil
+	if n == len(recs) {
+		return recs
 	}
 
-	return &dns.A{
-		Hdr: dns.RR_Header{
-			Name:   q.Name,
-			Rrtype: dns.TypeA,
-			Class:  dns.ClassINET,
-			Ttl:    rec.TTL,
-		},
-		A: ip,
+	filtered := make([]compiledRecord, 0, n)
+	for _, rec := range recs {
+		if rec.RecordType == ARecord {
+			filtered = append(filtered, rec)
+		}
 	}
+	return filtered
 }
 
-func (h *Handler) createCNAMERecord(q dns.Question, rec DNSRecord) dns.RR {
-	// Ensure target is fully qualified
-	target := dns.CanonicalName(rec.Value)
-	return &dns.CNAME{
-		Hdr: dns.RR_Header{
-			Name:   q.Name,
-			Rrtype: dns.TypeCNAME,
-			Class:  dns.ClassINET,
-			Ttl:    rec.TTL,
-		},
-		Target: target,
-	}
+// recordMatch is the result of looking up a query name in the local records.
+type recordMatch struct {
+	records []compiledRecord
+	// exists is also true for empty non-terminals that own no records
+	exists bool
+	// wildcard reports whether records came from a wildcard
+	wildcard bool
+	// capture holds what replaces "*" in wildcard CNAME targets
+	capture string
 }
 
-func (h *Handler) createMXRecord(q dns.Question, rec DNSRecord) dns.RR {
-	target := dns.CanonicalName(rec.Value)
-	return &dns.MX{
-		Hdr: dns.RR_Header{
-			Name:   q.Name,
-			Rrtype: dns.TypeMX,
-			Class:  dns.ClassINET,
-			Ttl:    rec.TTL,
-		},
-		Preference: rec.Priority,
-		Mx:         target,
+// findMatchingRecords finds all records that match the query name following
+// the RFC 4592 wildcard rules.
+//
+// A wildcard "*.<closest encloser>" only applies when the query name does not
+// exist and the closest encloser (the longest existing ancestor) owns such a
+// wildcard, so a single wildcard may stand in for any number of labels.
+func (h *Handler) findMatchingRecords(index *recordIndex, queryName string) recordMatch {
+	res := index.lookup(dns.CanonicalName(queryName))
+	match := recordMatch{records: res.Records, exists: res.Exists}
+	if res.Wildcard {
+		match.wildcard = true
+		match.capture = res.Prefix
+		if h.wildcardCapture == config.WildcardCaptureFirst {
+			if i := strings.IndexByte(res.Prefix, '.'); i >= 0 {
+				match.capture = res.Prefix[:i]
+			}
+		}
 	}
+	return match
 }
 
-func (h *Handler) createTXTRecord(q dns.Question, rec DNSRecord) dns.RR {
-	// Split TXT record by spaces if it contains multiple strings
-	txtParts := strings.Split(rec.Value, " ")
-	// Remove empty strings and trim spaces
-	var cleanParts []string
-	for _, part := range txtParts {
-		if trimmed := strings.TrimSpace(part); trimmed != "" {
-			cleanParts = append(cleanParts, trimmed)
-		}
+// cnameAnswer returns the CNAME answer of rec for name, substituting the
+// captured labels into a wildcard target such as "*.backend.local.".
+func (h *Handler) cnameAnswer(name string, rec compiledRecord, match recordMatch) dns.RR {
+	if !match.wildcard || !strings.HasPrefix(rec.Value, "*.") {
+		return rec.answer(name)
 	}
 
-	return &dns.TXT{
+	return &dns.CNAME{
 		Hdr: dns.RR_Header{
-			Name:   q.Name,
-			Rrtype: dns.TypeTXT,
+			Name:   name,
+			Rrtype: dns.TypeCNAME,
 			Class:  dns.ClassINET,
 			Ttl:    rec.TTL,
 		},
-		Txt: cleanParts,
+		Target: dns.CanonicalName(match.capture + rec.Value[1:]),
 	}
 }
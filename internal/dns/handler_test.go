@@ -11,11 +11,12 @@ import (
 )
 
 type mockResponseWriter struct {
-	msgs []*dns.Msg
+	msgs   []*dns.Msg
+	remote net.Addr
 }
 
 func (m *mockResponseWriter) LocalAddr() net.Addr         { return nil }
-func (m *mockResponseWriter) RemoteAddr() net.Addr        { return nil }
+func (m *mockResponseWriter) RemoteAddr() net.Addr        { return m.remote }
 func (m *mockResponseWriter) WriteMsg(msg *dns.Msg) error { m.msgs = append(m.msgs, msg); return nil }
 func (m *mockResponseWriter) Write([]byte) (int, error)   { return 0, nil }
 func (m *mockResponseWriter) Close() error                { return nil }
@@ -65,6 +66,14 @@ func TestHandler_ServeDNS(t *testing.T) {
 				RecordType: TXTRecord,
 			},
 		},
+		"host.sub.example.org.": {
+			{
+				Domain:     "host.sub.example.org.",
+				Value:      "192.168.2.1",
+				TTL:        300,
+				RecordType: ARecord,
+			},
+		},
 	}
 
 	// Create relay config for testing
@@ -137,6 +146,17 @@ func TestHandler_ServeDNS(t *testing.T) {
 			expectedAnswer: "v=spf1 include:_spf.example.com ~all",
 			expectRelay:    false,
 		},
+		{
+			name: "Empty non-terminal - relayed",
+			question: dns.Question{
+				Name:   "sub.example.org.",
+				Qtype:  dns.TypeA,
+				Qclass: dns.ClassINET,
+			},
+			expectedRcode: dns.RcodeNameError,
+			expectedCount: 0,
+			expectRelay:   true,
+		},
 		{
 			name: "Non-existent domain",
 			question: dns.Question{
@@ -244,6 +264,7 @@ func TestHandlerWithoutRelay(t *testing.T) {
 		{"TXT Record", "example.com.", dns.TypeTXT, dns.RcodeSuccess, false}, // Changed: when record doesn't exist, return NoError
 		{"Non-existent domain", "nonexistent.com.", dns.TypeA, dns.RcodeNameError, false},
 		{"Non-existent record type", "example.com.", dns.TypeAAAA, dns.RcodeSuccess, false}, // Changed: when record type doesn't exist, return NoError
+		{"Empty non-terminal", "com.", dns.TypeA, dns.RcodeSuccess, false},
 	}
 
 	for _, tc := range testCases {
@@ -273,3 +294,151 @@ func TestHandlerWithoutRelay(t *testing.T) {
 		})
 	}
 }
+
+func TestHandlerWildcardSemantics(t *testing.T) {
+	records := map[string][]DNSRecord{
+		"*.example.com.": {
+			{Domain: "*.example.com.", Value: "192.168.1.2", TTL: 300, RecordType: ARecord},
+		},
+		"host.example.com.": {
+			{Domain: "host.example.com.", Value: "v=spf1 -all", TTL: 300, RecordType: TXTRecord},
+		},
+		"leaf.ent.example.com.": {
+			{Domain: "leaf.ent.example.com.", Value: "192.168.1.3", TTL: 300, RecordType: ARecord},
+		},
+	}
+
+	handler, _ := NewHandler(records, config.RelayConfig{Enabled: false})
+
+	testCases := []struct {
+		name          string
+		qname         string
+		qtype         uint16
+		expectedRcode int
+		expectedA     string
+	}{
+		{"single label", "a.example.com.", dns.TypeA, dns.RcodeSuccess, "192.168.1.2"},
+		{"multiple labels", "a.b.example.com.", dns.TypeA, dns.RcodeSuccess, "192.168.1.2"},
+		{"existing name blocks wildcard", "host.example.com.", dns.TypeA, dns.RcodeSuccess, ""},
+		{"empty non-terminal blocks wildcard", "ent.example.com.", dns.TypeA, dns.RcodeSuccess, ""},
+		{"closest encloser without wildcard", "x.leaf.ent.example.com.", dns.TypeA, dns.RcodeNameError, ""},
+		{"below empty non-terminal", "x.ent.example.com.", dns.TypeA, dns.RcodeNameError, ""},
+		{"apex is not matched", "example.com.", dns.TypeA, dns.RcodeSuccess, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &mockResponseWriter{msgs: make([]*dns.Msg, 0)}
+			r := new(dns.Msg)
+			r.SetQuestion(tc.qname, tc.qtype)
+
+			handler.ServeDNS(w, r)
+
+			msg := w.msgs[0]
+			if msg.Rcode != tc.expectedRcode {
+				t.Errorf("Expected Rcode %d, got %d", tc.expectedRcode, msg.Rcode)
+			}
+			if tc.expectedA == "" {
+				if len(msg.Answer) != 0 {
+					t.Errorf("Expected no answers, got %v", msg.Answer)
+				}
+				return
+			}
+			if len(msg.Answer) != 1 {
+				t.Fatalf("Expected 1 answer, got %d", len(msg.Answer))
+			}
+			a, ok := msg.Answer[0].(*dns.A)
+			if !ok || a.A.String() != tc.expectedA || a.Hdr.Name != tc.qname {
+				t.Errorf("Expected A %s for %s, got %v", tc.expectedA, tc.qname, msg.Answer[0])
+			}
+		})
+	}
+}
+
+func TestHandlerWildcardCapture(t *testing.T) {
+	records := map[string][]DNSRecord{
+		"*.svc.local.": {
+			{Domain: "*.svc.local.", Value: "*.backend.local", TTL: 300, RecordType: CNAMERecord},
+		},
+	}
+
+	testCases := []struct {
+		mode   string
+		qname  string
+		target string
+	}{
+		{config.WildcardCaptureFull, "api.svc.local.", "api.backend.local."},
+		{config.WildcardCaptureFull, "v1.api.svc.local.", "v1.api.backend.local."},
+		{config.WildcardCaptureFirst, "v1.api.svc.local.", "v1.backend.local."},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.mode+" "+tc.qname, func(t *testing.T) {
+			handler, _ := NewHandler(records, config.RelayConfig{Enabled: false}, WithWildcardCapture(tc.mode))
+
+			w := &mockResponseWriter{msgs: make([]*dns.Msg, 0)}
+			r := new(dns.Msg)
+			r.SetQuestion(tc.qname, dns.TypeCNAME)
+
+			handler.ServeDNS(w, r)
+
+			msg := w.msgs[0]
+			if len(msg.Answer) != 1 {
+				t.Fatalf("Expected 1 answer, got %d", len(msg.Answer))
+			}
+			if cname := msg.Answer[0].(*dns.CNAME); cname.Target != tc.target {
+				t.Errorf("Expected CNAME target %s, got %s", tc.target, cname.Target)
+			}
+		})
+	}
+}
+
+func TestHandlerRelaysAncestors(t *testing.T) {
+	upstream := startTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("198.51.100.1"),
+		})
+		w.WriteMsg(m)
+	}))
+
+	// Override one host and relay the rest of its domain
+	records := map[string][]DNSRecord{
+		"api.example.com.": {{Domain: "api.example.com.", Value: "10.0.0.1", TTL: 60, RecordType: ARecord}},
+	}
+	handler, err := NewHandler(records, config.RelayConfig{
+		Enabled:     true,
+		Nameservers: []string{upstream},
+		Timeout:     time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer handler.Close()
+
+	tests := []struct {
+		qname string
+		want  string
+	}{
+		{"api.example.com.", "10.0.0.1"},
+		{"example.com.", "198.51.100.1"},
+		{"com.", "198.51.100.1"},
+		{"www.example.com.", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		w := &mockResponseWriter{msgs: make([]*dns.Msg, 0)}
+		r := new(dns.Msg)
+		r.SetQuestion(tt.qname, dns.TypeA)
+		handler.ServeDNS(w, r)
+
+		if len(w.msgs) != 1 || len(w.msgs[0].Answer) != 1 {
+			t.Errorf("%s: got %v, want an answer of %s", tt.qname, w.msgs, tt.want)
+			continue
+		}
+		if got := w.msgs[0].Answer[0].(*dns.A).A.String(); got != tt.want {
+			t.Errorf("%s: answer = %s, want %s", tt.qname, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+)
+
+// Health check types
+const (
+	HealthCheckTCP  = "tcp"
+	HealthCheckHTTP = "http"
+
+	DefaultHealthInterval  = 10 * time.Second
+	DefaultHealthTimeout   = 2 * time.Second
+	DefaultHealthThreshold = 2
+)
+
+// HealthCheck describes an active check of an A record's address.
+type HealthCheck struct {
+	Type      string // HealthCheckTCP or HealthCheckHTTP
+	Port      int
+	Path      string // HTTP request path
+	Expect    int    // Expected HTTP status code
+	Interval  time.Duration
+	Timeout   time.Duration
+	Threshold int // Consecutive results needed to change state
+}
+
+// parseHealthCheck parses a check specification such as "tcp:5432" or
+// "http:8080/healthz", filling in the defaults for everything else.
+func parseHealthCheck(spec string) (*HealthCheck, error) {
+	checkType, target, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid health check %q: expected type:port", spec)
+	}
+
+	check := &HealthCheck{
+		Type:      strings.ToLower(checkType),
+		Interval:  DefaultHealthInterval,
+		Timeout:   DefaultHealthTimeout,
+		Threshold: DefaultHealthThreshold,
+	}
+
+	port := target
+	switch check.Type {
+	case HealthCheckTCP:
+	case HealthCheckHTTP:
+		check.Path = "/"
+		check.Expect = http.StatusOK
+		if i := strings.IndexByte(target, '/'); i >= 0 {
+			port, check.Path = target[:i], target[i:]
+		}
+	default:
+		return nil, fmt.Errorf("unsupported health check type %q", checkType)
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return nil, fmt.Errorf("invalid health check port %q", port)
+	}
+	check.Port = p
+	return check, nil
+}
+
+// String returns the check in its configuration syntax.
+func (c *HealthCheck) String() string {
+	if c.Type == HealthCheckHTTP {
+		return fmt.Sprintf("%s:%d%s", c.Type, c.Port, c.Path)
+	}
+	return fmt.Sprintf("%s:%d", c.Type, c.Port)
+}
+
+// healthTarget tracks the health of one address checked one way.
+type healthTarget struct {
+	ip    string
+	check HealthCheck
+
+	healthy atomic.Bool
+	streak  int // consecutive results contradicting the current state
+}
+
+func (t *healthTarget) key() string {
+	return fmt.Sprintf("%s|%s|%d|%s|%s|%d", t.ip, t.check.String(), t.check.Expect,
+		t.check.Interval, t.check.Timeout, t.check.Threshold)
+}
+
+// probe runs the check once, returning nil if it passed.
+func (t *healthTarget) probe() error {
+	addr := net.JoinHostPort(t.ip, strconv.Itoa(t.check.Port))
+
+	switch t.check.Type {
+	case HealthCheckHTTP:
+		client := &http.Client{
+			Timeout: t.check.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		resp, err := client.Get("http://" + addr + t.check.Path)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != t.check.Expect {
+			return fmt.Errorf("unexpected status %d, want %d", resp.StatusCode, t.check.Expect)
+		}
+		return nil
+
+	default:
+		conn, err := net.DialTimeout("tcp", addr, t.check.Timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// record applies one check result, flipping the state once Threshold
+// consecutive results disagree with it.
+func (t *healthTarget) record(err error) {
+	passed := err == nil
+	if passed == t.healthy.Load() {
+		t.streak = 0
+		return
+	}
+
+	t.streak++
+	if t.streak < t.check.Threshold {
+		return
+	}
+
+	t.streak = 0
+	t.healthy.Store(passed)
+	if passed {
+		logging.Infof("Health check %s on %s passed, address is healthy again", t.check.String(), t.ip)
+	} else {
+		logging.Warnf("Health check %s on %s failed, address is unhealthy: %v", t.check.String(), t.ip, err)
+	}
+}
+
+// healthChecker runs the health checks of all records in the background.
+// Addresses start out healthy until their checks prove otherwise.
+type healthChecker struct {
+	targets map[string]*healthTarget
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newHealthChecker() *healthChecker {
+	return &healthChecker{
+		targets: make(map[string]*healthTarget),
+		stop:    make(chan struct{}),
+	}
+}
+
+// target returns the shared target for ip checked by check, so records that
+// point at the same address with the same check are only probed once.
+func (hc *healthChecker) target(ip string, check HealthCheck) *healthTarget {
+	t := &healthTarget{ip: ip, check: check}
+	if existing, ok := hc.targets[t.key()]; ok {
+		return existing
+	}
+	t.healthy.Store(true)
+	hc.targets[t.key()] = t
+	return t
+}
+
+// inherit carries the health state of targets that old checks as well over
+// to hc, so a reload doesn't bring dead addresses back into service.
+func (hc *healthChecker) inherit(old *healthChecker) {
+	for key, t := range hc.targets {
+		if prev, ok := old.targets[key]; ok {
+			t.healthy.Store(prev.healthy.Load())
+		}
+	}
+}
+
+// start launches one goroutine per target.
+func (hc *healthChecker) start() {
+	for _, t := range hc.targets {
+		hc.wg.Add(1)
+		go hc.run(t)
+	}
+}
+
+func (hc *healthChecker) run(t *healthTarget) {
+	defer hc.wg.Done()
+
+	ticker := time.NewTicker(t.check.Interval)
+	defer ticker.Stop()
+
+	for {
+		t.record(t.probe())
+		select {
+		case <-hc.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// close stops all checks and waits for them to finish.
+func (hc *healthChecker) close() {
+	close(hc.stop)
+	hc.wg.Wait()
+}
+
+// healthyAddresses filters the A records of a name: the healthy primary
+// records, or else the healthy backup records, or else all primary records,
+// since a dead answer beats no answer.
+func healthyAddresses(recs []compiledRecord) []compiledRecord {
+	checked := false
+	for _, rec := range recs {
+		if rec.Backup || rec.health != nil {
+			checked = true
+			break
+		}
+	}
+	if !checked {
+		return recs
+	}
+
+	var primaries, backups []compiledRecord
+	for _, rec := range recs {
+		if rec.Backup {
+			backups = append(backups, rec)
+		} else {
+			primaries = append(primaries, rec)
+		}
+	}
+
+	if healthy := filterHealthy(primaries); len(healthy) > 0 {
+		return healthy
+	}
+	if healthy := filterHealthy(backups); len(healthy) > 0 {
+		return healthy
+	}
+	if len(primaries) > 0 {
+		return primaries
+	}
+	return backups
+}
+
+func filterHealthy(recs []compiledRecord) []compiledRecord {
+	healthy := recs[:0:0]
+	for _, rec := range recs {
+		if rec.health == nil || rec.health.healthy.Load() {
+			healthy = append(healthy, rec)
+		}
+	}
+	return healthy
+}
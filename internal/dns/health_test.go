@@ -0,0 +1,199 @@
+package dns
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+func TestParseHealthCheck(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    HealthCheck
+		wantErr bool
+	}{
+		{
+			spec: "tcp:5432",
+			want: HealthCheck{Type: HealthCheckTCP, Port: 5432, Interval: DefaultHealthInterval, Timeout: DefaultHealthTimeout, Threshold: DefaultHealthThreshold},
+		},
+		{
+			spec: "http:8080/healthz",
+			want: HealthCheck{Type: HealthCheckHTTP, Port: 8080, Path: "/healthz", Expect: 200, Interval: DefaultHealthInterval, Timeout: DefaultHealthTimeout, Threshold: DefaultHealthThreshold},
+		},
+		{
+			spec: "HTTP:80",
+			want: HealthCheck{Type: HealthCheckHTTP, Port: 80, Path: "/", Expect: 200, Interval: DefaultHealthInterval, Timeout: DefaultHealthTimeout, Threshold: DefaultHealthThreshold},
+		},
+		{spec: "tcp", wantErr: true},
+		{spec: "tcp:0", wantErr: true},
+		{spec: "icmp:1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseHealthCheck(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseHealthCheck(%q) error = nil, want error", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHealthCheck(%q) error = %v", tt.spec, err)
+			}
+			if *got != tt.want {
+				t.Errorf("parseHealthCheck(%q) = %+v, want %+v", tt.spec, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthTargetProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	openPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	_, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	tests := []struct {
+		name    string
+		check   HealthCheck
+		wantErr bool
+	}{
+		{"tcp open", HealthCheck{Type: HealthCheckTCP, Port: port, Timeout: time.Second}, false},
+		{"tcp closed", HealthCheck{Type: HealthCheckTCP, Port: openPort, Timeout: time.Second}, true},
+		{"http expected status", HealthCheck{Type: HealthCheckHTTP, Port: port, Path: "/healthz", Expect: 200, Timeout: time.Second}, false},
+		{"http unexpected status", HealthCheck{Type: HealthCheckHTTP, Port: port, Path: "/", Expect: 200, Timeout: time.Second}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &healthTarget{ip: "127.0.0.1", check: tt.check}
+			if err := target.probe(); (err != nil) != tt.wantErr {
+				t.Errorf("probe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHealthTargetThreshold(t *testing.T) {
+	target := &healthTarget{ip: "127.0.0.1", check: HealthCheck{Type: HealthCheckTCP, Port: 1, Threshold: 2}}
+	target.healthy.Store(true)
+
+	results := []struct {
+		err  error
+		want bool
+	}{
+		{net.ErrClosed, true},
+		{nil, true}, // resets the streak
+		{net.ErrClosed, true},
+		{net.ErrClosed, false},
+		{nil, false},
+		{nil, true},
+	}
+
+	for i, r := range results {
+		target.record(r.err)
+		if got := target.healthy.Load(); got != r.want {
+			t.Errorf("after result %d healthy = %v, want %v", i, got, r.want)
+		}
+	}
+}
+
+func TestHealthyAddressesBackup(t *testing.T) {
+	primary := &healthTarget{ip: "10.0.0.1"}
+	primary.healthy.Store(true)
+	backup := &healthTarget{ip: "10.0.1.1"}
+	backup.healthy.Store(true)
+	recs := []compiledRecord{
+		{DNSRecord: DNSRecord{Value: "10.0.0.1", RecordType: ARecord}, health: primary},
+		{DNSRecord: DNSRecord{Value: "10.0.1.1", RecordType: ARecord, Backup: true}, health: backup},
+	}
+
+	got := healthyAddresses(recs)
+	if len(got) != 1 || got[0].Value != "10.0.0.1" {
+		t.Errorf("healthyAddresses() = %v, want only the healthy primary", got)
+	}
+}
+
+func TestHandlerHealthFailover(t *testing.T) {
+	check := &HealthCheck{Type: HealthCheckTCP, Port: 80, Interval: time.Hour, Timeout: time.Second, Threshold: 1}
+	records := map[string][]DNSRecord{
+		"api.example.com.": {
+			{Domain: "api.example.com.", Value: "10.0.0.1", TTL: 60, RecordType: ARecord, Health: check},
+			{Domain: "api.example.com.", Value: "10.0.0.2", TTL: 60, RecordType: ARecord, Health: check},
+			{Domain: "api.example.com.", Value: "10.0.1.1", TTL: 60, RecordType: ARecord, Health: check, Backup: true},
+		},
+	}
+
+	handler, err := NewHandler(records, config.RelayConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	// Stop the background checks so the test controls the health state
+	handler.Close()
+
+	setHealthy := func(ip string, healthy bool) {
+		for _, target := range handler.state.Load().health.targets {
+			if target.ip == ip {
+				target.healthy.Store(healthy)
+			}
+		}
+	}
+	query := func() []string {
+		w := &mockResponseWriter{msgs: make([]*dns.Msg, 0)}
+		r := new(dns.Msg)
+		r.SetQuestion("api.example.com.", dns.TypeA)
+		handler.ServeDNS(w, r)
+		var ips []string
+		for _, rr := range w.msgs[0].Answer {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+		return ips
+	}
+
+	steps := []struct {
+		name    string
+		healthy map[string]bool
+		want    []string
+	}{
+		{"all healthy", map[string]bool{"10.0.0.1": true, "10.0.0.2": true, "10.0.1.1": true}, []string{"10.0.0.1", "10.0.0.2"}},
+		{"one primary down", map[string]bool{"10.0.0.1": false}, []string{"10.0.0.2"}},
+		{"all primaries down", map[string]bool{"10.0.0.2": false}, []string{"10.0.1.1"}},
+		{"everything down", map[string]bool{"10.0.1.1": false}, []string{"10.0.0.1", "10.0.0.2"}},
+		{"primary recovers", map[string]bool{"10.0.0.2": true}, []string{"10.0.0.2"}},
+	}
+
+	for _, step := range steps {
+		for ip, healthy := range step.healthy {
+			setHealthy(ip, healthy)
+		}
+		got := query()
+		if len(got) != len(step.want) {
+			t.Errorf("%s: got %v, want %v", step.name, got, step.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != step.want[i] {
+				t.Errorf("%s: got %v, want %v", step.name, got, step.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"github.com/miekg/dns"
+)
+
+// maxLabels is the most labels a 255 octet domain name can hold
+const maxLabels = 128
+
+// recordIndex stores records in a trie keyed by labels in reverse order
+// ("www.example.com." is stored under com -> example -> www), so exact,
+// wildcard and closest encloser lookups all happen in a single walk.
+//
+// Every node in the trie is an existing name: it either owns records or has
+// descendants that do (an empty non-terminal).
+type recordIndex struct {
+	root *indexNode
+	size int // number of names that own records
+}
+
+type indexNode struct {
+	children map[string]*indexNode
+	records  []compiledRecord
+	name     string // fully qualified owner name, empty for the root
+}
+
+// lookupResult describes how a name matched the index.
+type lookupResult struct {
+	// Records holds the records of the matched name, either the exact name or
+	// the wildcard used to synthesize the answer.
+	Records []compiledRecord
+	// Exists reports whether the name exists locally, by exact match, as an
+	// empty non-terminal or through a wildcard.
+	Exists bool
+	// Wildcard reports whether Records came from a wildcard.
+	Wildcard bool
+	// Encloser is the closest encloser: the name itself when it exists,
+	// otherwise its longest existing ancestor ("" when nothing matched).
+	Encloser string
+	// Prefix holds the labels in front of Encloser that a wildcard matched,
+	// without the trailing dot.
+	Prefix string
+}
+
+func newRecordIndex() *recordIndex {
+	return &recordIndex{root: &indexNode{}}
+}
+
+// insert adds records under name, which must be canonical (lower case, fully qualified).
+func (idx *recordIndex) insert(name string, recs []compiledRecord) {
+	var offsets [maxLabels]int
+	n := labelOffsets(name, &offsets)
+
+	node := idx.root
+	for i := n - 1; i >= 0; i-- {
+		label := labelAt(name, offsets[:n], i)
+		child, ok := node.children[label]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[string]*indexNode)
+			}
+			child = &indexNode{name: name[offsets[i]:]}
+			node.children[label] = child
+		}
+		node = child
+	}
+
+	if len(node.records) == 0 && len(recs) > 0 {
+		idx.size++
+	}
+	node.records = append(node.records, recs...)
+}
+
+// lookup finds name following the RFC 4592 rules: an existing name (including
+// an empty non-terminal) is always returned as is, otherwise only the wildcard
+// directly below the closest encloser may answer for it. name must be canonical.
+func (idx *recordIndex) lookup(name string) lookupResult {
+	var offsets [maxLabels]int
+	n := labelOffsets(name, &offsets)
+	if n == 0 {
+		return lookupResult{}
+	}
+
+	node := idx.root
+	i := n - 1
+	for ; i >= 0; i-- {
+		child, ok := node.children[labelAt(name, offsets[:n], i)]
+		if !ok {
+			break
+		}
+		node = child
+	}
+
+	if i < 0 {
+		return lookupResult{Records: node.records, Exists: true, Encloser: node.name}
+	}
+
+	// The root is never used as a closest encloser
+	if node == idx.root {
+		return lookupResult{}
+	}
+
+	res := lookupResult{Encloser: node.name}
+	if wildcard, ok := node.children["*"]; ok && len(wildcard.records) > 0 {
+		res.Records = wildcard.records
+		res.Exists = true
+		res.Wildcard = true
+		res.Prefix = name[:offsets[i+1]-1]
+	}
+	return res
+}
+
+// labelOffsets fills offsets with the start of every label in name and
+// returns the number of labels.
+func labelOffsets(name string, offsets *[maxLabels]int) int {
+	n := 0
+	for off, end := 0, false; !end && n < maxLabels; off, end = dns.NextLabel(name, off) {
+		if off >= len(name) || name == "." {
+			break
+		}
+		offsets[n] = off
+		n++
+	}
+	return n
+}
+
+// labelAt returns label i of name, without its trailing dot.
+func labelAt(name string, offsets []int, i int) string {
+	end := len(name) - 1
+	if i+1 < len(offsets) {
+		end = offsets[i+1] - 1
+	}
+	return name[offsets[i]:end]
+}
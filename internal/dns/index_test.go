@@ -0,0 +1,119 @@
+package dns
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRecordIndexLookup(t *testing.T) {
+	idx := newRecordIndex()
+	idx.insert("example.com.", []compiledRecord{{DNSRecord: DNSRecord{Domain: "example.com.", Value: "192.168.1.1", RecordType: ARecord}}})
+	idx.insert("*.example.com.", []compiledRecord{{DNSRecord: DNSRecord{Domain: "*.example.com.", Value: "192.168.1.2", RecordType: ARecord}}})
+	idx.insert("leaf.ent.example.com.", []compiledRecord{{DNSRecord: DNSRecord{Domain: "leaf.ent.example.com.", Value: "192.168.1.3", RecordType: ARecord}}})
+
+	tests := []struct {
+		name         string
+		query        string
+		wantExists   bool
+		wantWildcard bool
+		wantValue    string
+		wantEncloser string
+		wantPrefix   string
+	}{
+		{"exact", "example.com.", true, false, "192.168.1.1", "example.com.", ""},
+		{"wildcard single label", "a.example.com.", true, true, "192.168.1.2", "example.com.", "a"},
+		{"wildcard multiple labels", "a.b.example.com.", true, true, "192.168.1.2", "example.com.", "a.b"},
+		{"empty non-terminal", "ent.example.com.", true, false, "", "ent.example.com.", ""},
+		{"no wildcard at closest encloser", "x.ent.example.com.", false, false, "", "ent.example.com.", ""},
+		{"outside of known zones", "example.org.", false, false, "", "", ""},
+		{"top level domain", "com.", true, false, "", "com.", ""},
+		{"root", ".", false, false, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := idx.lookup(tt.query)
+			if got.Exists != tt.wantExists {
+				t.Errorf("lookup(%s).Exists = %v, want %v", tt.query, got.Exists, tt.wantExists)
+			}
+			if got.Wildcard != tt.wantWildcard {
+				t.Errorf("lookup(%s).Wildcard = %v, want %v", tt.query, got.Wildcard, tt.wantWildcard)
+			}
+			if got.Encloser != tt.wantEncloser {
+				t.Errorf("lookup(%s).Encloser = %q, want %q", tt.query, got.Encloser, tt.wantEncloser)
+			}
+			if got.Prefix != tt.wantPrefix {
+				t.Errorf("lookup(%s).Prefix = %q, want %q", tt.query, got.Prefix, tt.wantPrefix)
+			}
+			var value string
+			if len(got.Records) > 0 {
+				value = got.Records[0].Value
+			}
+			if value != tt.wantValue {
+				t.Errorf("lookup(%s) value = %q, want %q", tt.query, value, tt.wantValue)
+			}
+		})
+	}
+
+	if idx.size != 3 {
+		t.Errorf("index size = %d, want 3", idx.size)
+	}
+}
+
+// newBenchmarkIndex builds an index holding n names spread over 100 zones,
+// each zone also owning a wildcard.
+func newBenchmarkIndex(n int) *recordIndex {
+	idx := newRecordIndex()
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("host%d.zone%d.example.com.", i, i%100)
+		idx.insert(name, []compiledRecord{{DNSRecord: DNSRecord{Domain: name, Value: "10.0.0.1", RecordType: ARecord}}})
+	}
+	for z := 0; z < 100; z++ {
+		name := fmt.Sprintf("*.zone%d.example.com.", z)
+		idx.insert(name, []compiledRecord{{DNSRecord: DNSRecord{Domain: name, Value: "10.0.0.2", RecordType: ARecord}}})
+	}
+	return idx
+}
+
+func BenchmarkRecordIndexLookup(b *testing.B) {
+	idx := newBenchmarkIndex(100000)
+
+	benchmarks := []struct {
+		name  string
+		query string
+	}{
+		{"exact", "host4242.zone42.example.com."},
+		{"wildcard", "a.b.zone42.example.com."},
+		{"miss", "www.example.org."},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if res := idx.lookup(bm.query); res.Exists != (bm.name != "miss") {
+					b.Fatalf("unexpected lookup result for %s: %+v", bm.query, res)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRecordIndexLookupParallel(b *testing.B) {
+	idx := newBenchmarkIndex(100000)
+
+	queries := make([]string, 1024)
+	for i := range queries {
+		queries[i] = fmt.Sprintf("host%d.zone%d.example.com.", i*97, (i*97)%100)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			idx.lookup(queries[i%len(queries)])
+			i++
+		}
+	})
+}
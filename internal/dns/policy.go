@@ -0,0 +1,158 @@
+package dns
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+	"github.com/miekg/dns"
+)
+
+// Answer ordering modes
+const (
+	PolicyOrdered    = "ordered"     // records in the order of their variable names
+	PolicyShuffle    = "shuffle"     // random order for every query
+	PolicyRoundRobin = "round-robin" // rotate the first record on every query
+	PolicyWeighted   = "weighted"    // random order biased by record weights
+
+	// PolicyPrefix is the environment variable prefix of answer policies
+	PolicyPrefix = "POLICY_"
+)
+
+// AnswerPolicy controls how the A records of a name are returned.
+type AnswerPolicy struct {
+	Domain string
+	Mode   string
+	Count  int // Return at most Count records, 0 returns all of them
+
+	next atomic.Uint64 // round-robin position
+}
+
+// WithPolicies sets the answer policies, keyed by domain.
+func WithPolicies(policies map[string]*AnswerPolicy) Option {
+	return func(h *Handler) {
+		h.policies = policies
+	}
+}
+
+// canonicalPolicies keys policies by canonical domain name.
+func canonicalPolicies(policies map[string]*AnswerPolicy) map[string]*AnswerPolicy {
+	canonical := make(map[string]*AnswerPolicy, len(policies))
+	for name, policy := range policies {
+		canonical[dns.CanonicalName(name)] = policy
+	}
+	return canonical
+}
+
+// LoadPolicies loads answer policies from POLICY_xxx=domain|mode[|count]
+// environment variables.
+func LoadPolicies() map[string]*AnswerPolicy {
+	policies := make(map[string]*AnswerPolicy)
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(key, PolicyPrefix) {
+			continue
+		}
+
+		policy, err := parsePolicy(value)
+		if err != nil {
+			logging.Warnf("Error parsing policy %s: %v", key, err)
+			continue
+		}
+		policies[policy.Domain] = policy
+		logging.Infof("%s answers are %s (count: %d)", policy.Domain, policy.Mode, policy.Count)
+	}
+	return policies
+}
+
+func parsePolicy(value string) (*AnswerPolicy, error) {
+	parts := strings.Split(value, RecordSeparator)
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid format: expected domain%smode[%scount]", RecordSeparator, RecordSeparator)
+	}
+
+	policy := &AnswerPolicy{Domain: dns.CanonicalName(strings.TrimSpace(parts[0]))}
+	switch mode := strings.ToLower(strings.TrimSpace(parts[1])); mode {
+	case PolicyOrdered, PolicyShuffle, PolicyRoundRobin, PolicyWeighted:
+		policy.Mode = mode
+	default:
+		return nil, fmt.Errorf("unknown policy mode %q", parts[1])
+	}
+
+	if len(parts) == 3 {
+		count, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("invalid count %q", parts[2])
+		}
+		policy.Count = count
+	}
+	return policy, nil
+}
+
+// apply returns recs in the policy's order, limited to Count records. recs
+// itself is never reordered as it is shared between queries.
+func (p *AnswerPolicy) apply(recs []compiledRecord) []compiledRecord {
+	if len(recs) == 0 {
+		return recs
+	}
+
+	var ordered []compiledRecord
+	switch p.Mode {
+	case PolicyShuffle:
+		ordered = append([]compiledRecord(nil), recs...)
+		rand.Shuffle(len(ordered), func(i, j int) {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		})
+	case PolicyRoundRobin:
+		start := int((p.next.Add(1) - 1) % uint64(len(recs)))
+		ordered = make([]compiledRecord, 0, len(recs))
+		ordered = append(ordered, recs[start:]...)
+		ordered = append(ordered, recs[:start]...)
+	case PolicyWeighted:
+		ordered = weightedOrder(recs)
+	default:
+		ordered = recs
+	}
+
+	if p.Count > 0 && len(ordered) > p.Count {
+		ordered = ordered[:p.Count]
+	}
+	return ordered
+}
+
+// weightedOrder draws records one after another without replacement, each
+// with a probability proportional to its weight.
+func weightedOrder(recs []compiledRecord) []compiledRecord {
+	remaining := append([]compiledRecord(nil), recs...)
+	ordered := make([]compiledRecord, 0, len(recs))
+
+	for len(remaining) > 0 {
+		total := 0
+		for _, rec := range remaining {
+			total += rec.weight()
+		}
+
+		pick := rand.IntN(total)
+		for i, rec := range remaining {
+			pick -= rec.weight()
+			if pick < 0 {
+				ordered = append(ordered, rec)
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+		}
+	}
+	return ordered
+}
+
+// weight returns the record's weight, 1 unless configured otherwise.
+func (c compiledRecord) weight() int {
+	if c.Weight == 0 {
+		return 1
+	}
+	return c.Weight
+}
@@ -0,0 +1,149 @@
+package dns
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		value     string
+		wantMode  string
+		wantCount int
+		wantErr   bool
+	}{
+		{"api.example.com|shuffle", PolicyShuffle, 0, false},
+		{"api.example.com|Round-Robin|2", PolicyRoundRobin, 2, false},
+		{"api.example.com|weighted|1", PolicyWeighted, 1, false},
+		{"api.example.com|ordered", PolicyOrdered, 0, false},
+		{"api.example.com", "", 0, true},
+		{"api.example.com|random", "", 0, true},
+		{"api.example.com|shuffle|-1", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parsePolicy(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parsePolicy(%q) error = nil, want error", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePolicy(%q) error = %v", tt.value, err)
+			}
+			if got.Domain != "api.example.com." || got.Mode != tt.wantMode || got.Count != tt.wantCount {
+				t.Errorf("parsePolicy(%q) = %s %s %d, want api.example.com. %s %d",
+					tt.value, got.Domain, got.Mode, got.Count, tt.wantMode, tt.wantCount)
+			}
+		})
+	}
+}
+
+func policyTestRecords(weights ...int) []compiledRecord {
+	recs := make([]compiledRecord, len(weights))
+	for i, w := range weights {
+		recs[i] = compiledRecord{DNSRecord: DNSRecord{Value: string(rune('a' + i)), Weight: w}}
+	}
+	return recs
+}
+
+func values(recs []compiledRecord) string {
+	var s string
+	for _, rec := range recs {
+		s += rec.Value
+	}
+	return s
+}
+
+func TestAnswerPolicyRoundRobin(t *testing.T) {
+	recs := policyTestRecords(0, 0, 0)
+	policy := &AnswerPolicy{Mode: PolicyRoundRobin, Count: 2}
+
+	for _, want := range []string{"ab", "bc", "ca", "ab"} {
+		if got := values(policy.apply(recs)); got != want {
+			t.Errorf("apply() = %s, want %s", got, want)
+		}
+	}
+	if values(recs) != "abc" {
+		t.Errorf("apply() reordered the shared records: %s", values(recs))
+	}
+}
+
+func TestAnswerPolicyShuffle(t *testing.T) {
+	recs := policyTestRecords(0, 0, 0, 0)
+	policy := &AnswerPolicy{Mode: PolicyShuffle}
+
+	firsts := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		got := policy.apply(recs)
+		sorted := []byte(values(got))
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		if string(sorted) != "abcd" {
+			t.Fatalf("apply() = %s, want a permutation of abcd", values(got))
+		}
+		firsts[got[0].Value] = true
+	}
+	if len(firsts) < 2 {
+		t.Errorf("shuffle always returned %v first", firsts)
+	}
+	if values(recs) != "abcd" {
+		t.Errorf("apply() reordered the shared records: %s", values(recs))
+	}
+}
+
+func TestAnswerPolicyWeighted(t *testing.T) {
+	recs := policyTestRecords(90, 10)
+	policy := &AnswerPolicy{Mode: PolicyWeighted, Count: 1}
+
+	const rounds = 10000
+	counts := make(map[string]int)
+	for i := 0; i < rounds; i++ {
+		got := policy.apply(recs)
+		if len(got) != 1 {
+			t.Fatalf("apply() returned %d records, want 1", len(got))
+		}
+		counts[got[0].Value]++
+	}
+
+	if share := float64(counts["a"]) / rounds; share < 0.85 || share > 0.95 {
+		t.Errorf("weight 90 record served %.2f of the time, want about 0.90", share)
+	}
+}
+
+func TestHandlerAnswerPolicy(t *testing.T) {
+	records := map[string][]DNSRecord{
+		"api.example.com.": {
+			{Domain: "api.example.com.", Value: "10.0.0.1", TTL: 60, RecordType: ARecord},
+			{Domain: "api.example.com.", Value: "10.0.0.2", TTL: 60, RecordType: ARecord},
+			{Domain: "api.example.com.", Value: "10.0.0.3", TTL: 60, RecordType: ARecord},
+		},
+	}
+	policies := map[string]*AnswerPolicy{
+		"API.example.com": {Mode: PolicyRoundRobin, Count: 1},
+	}
+
+	handler, err := NewHandler(records, config.RelayConfig{Enabled: false}, WithPolicies(policies))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+
+	for _, want := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.1"} {
+		w := &mockResponseWriter{msgs: make([]*dns.Msg, 0)}
+		r := new(dns.Msg)
+		r.SetQuestion("api.example.com.", dns.TypeA)
+		handler.ServeDNS(w, r)
+
+		answers := w.msgs[0].Answer
+		if len(answers) != 1 {
+			t.Fatalf("Expected 1 answer, got %d", len(answers))
+		}
+		if got := answers[0].(*dns.A).A.String(); got != want {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package dns
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ProbeName is the CHAOS class TXT name the handler always answers itself
+// (RFC 4892), so probes check that queries are served without depending on
+// local records or upstream nameservers.
+const ProbeName = "id.server."
+
+// serverID is the identity returned for ProbeName
+const serverID = "nanodns"
+
+// isProbe reports whether q asks for the server identity.
+func isProbe(q dns.Question) bool {
+	return q.Qclass == dns.ClassCHAOS && q.Qtype == dns.TypeTXT && dns.CanonicalName(q.Name) == ProbeName
+}
+
+// selfProbe reports whether r is a probe the server sent itself, as /readyz
+// and the healthcheck command do: one from the loopback address or from the
+// address it was sent to. Those pass the ACLs and rate limits, so a healthy
+// server never fails its own probes.
+func selfProbe(w dns.ResponseWriter, r *dns.Msg) bool {
+	if len(r.Question) != 1 || !isProbe(r.Question[0]) {
+		return false
+	}
+	client := clientAddr(w.RemoteAddr())
+	return client.IsLoopback() || client.IsValid() && client == clientAddr(w.LocalAddr())
+}
+
+func probeAnswer(q dns.Question) dns.RR {
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS},
+		Txt: []string{serverID},
+	}
+}
+
+// Probe queries the DNS server at addr over network ("udp" or "tcp") for its
+// identity and returns nil if it answered.
+func Probe(network, addr string, timeout time.Duration) error {
+	req := new(dns.Msg)
+	req.SetQuestion(ProbeName, dns.TypeTXT)
+	req.Question[0].Qclass = dns.ClassCHAOS
+
+	client := &dns.Client{Net: network, Timeout: timeout}
+	resp, _, err := client.Exchange(req, addr)
+	if err != nil {
+		return err
+	}
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0 {
+		return fmt.Errorf("unexpected probe response from %s: %s", addr, dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
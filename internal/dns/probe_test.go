@@ -0,0 +1,135 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+// startTestServer serves handler on a local UDP port and returns its address.
+func startTestServer(t *testing.T, handler dns.Handler) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	<-started
+
+	return pc.LocalAddr().String()
+}
+
+func TestProbe(t *testing.T) {
+	// Relaying to an unreachable upstream must not affect the probe
+	handler, err := NewHandler(nil, config.RelayConfig{
+		Enabled:     true,
+		Nameservers: []string{"127.0.0.1:1"},
+		Timeout:     100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer handler.Close()
+
+	addr := startTestServer(t, handler)
+	if err := Probe("udp", addr, time.Second); err != nil {
+		t.Errorf("Probe() error = %v", err)
+	}
+
+	refusing := startTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+	}))
+	if err := Probe("udp", refusing, time.Second); err == nil {
+		t.Error("Probe() of a refusing server succeeded")
+	}
+}
+
+func TestRelayClient_Check(t *testing.T) {
+	upstream := startTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	}))
+
+	tests := []struct {
+		name        string
+		nameservers []string
+		wantErr     bool
+	}{
+		{"reachable", []string{upstream}, false},
+		{"first unreachable", []string{"127.0.0.1:1", upstream}, false},
+		{"all unreachable", []string{"127.0.0.1:1"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewRelayClient(config.RelayConfig{
+				Enabled:     true,
+				Nameservers: tt.nameservers,
+				Timeout:     200 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatalf("NewRelayClient() error = %v", err)
+			}
+			if err := client.Check(); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProbeRestrictiveACL(t *testing.T) {
+	acl, err := config.ParseACL("allow 10.0.0.0/8, deny any")
+	if err != nil {
+		t.Fatalf("ParseACL() error = %v", err)
+	}
+	handler, err := NewHandler(nil, config.RelayConfig{}, WithACL(config.ACLConfig{Query: acl}))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer handler.Close()
+	limited := NewRateLimiter(handler, "", config.RateLimitConfig{
+		QueriesPerSecond: 1,
+		QueryBurst:       1,
+		IPv4Prefix:       24,
+		IPv6Prefix:       56,
+	})
+
+	// The server's own probes pass the ACL and the rate limit
+	addr := startTestServer(t, limited)
+	for i := range 3 {
+		if err := Probe("udp", addr, time.Second); err != nil {
+			t.Fatalf("Probe() %d error = %v", i+1, err)
+		}
+	}
+
+	// Other queries from the loopback address and probes from elsewhere don't
+	probe := new(dns.Msg)
+	probe.SetQuestion(ProbeName, dns.TypeTXT)
+	probe.Question[0].Qclass = dns.ClassCHAOS
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	tests := []struct {
+		client string
+		r      *dns.Msg
+	}{
+		{"127.0.0.1", query},
+		{"192.0.2.1", probe},
+	}
+	for _, tt := range tests {
+		w := &mockResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP(tt.client), Port: 5353}}
+		limited.ServeDNS(w, tt.r)
+		if len(w.msgs) != 0 {
+			t.Errorf("Query for %s from %s answered despite the ACL", tt.r.Question[0].Name, tt.client)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package dns
+
+import (
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+	"github.com/mguptahub/nanodns/internal/metrics"
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+// Rate limits and what was done over them, as counted in the metrics
+const (
+	limitQuery    = "query"
+	limitResponse = "response"
+
+	actionDrop = "drop"
+	actionSlip = "slip"
+)
+
+// sweepInterval is how often the buckets that have refilled are forgotten
+const sweepInterval = time.Minute
+
+// maxBuckets is how many buckets each table holds. Past it a new one replaces
+// a random bucket, so spoofed sources can't grow the tables without bound.
+const maxBuckets = 100000
+
+// RateLimiter wraps a handler, limiting the queries of each client prefix
+// with a token bucket and the identical UDP responses sent to it with
+// response rate limiting (RRL). Responses over the limit are dropped, but
+// every Slip-th one is sent truncated so real clients retry over TCP, which
+// spoofed ones can't.
+type RateLimiter struct {
+	next     dns.Handler
+	listener string
+	cfg      config.RateLimitConfig
+	now      func() time.Time
+
+	mu         sync.Mutex
+	queries    map[netip.Prefix]*bucket
+	responses  map[responseKey]*bucket
+	maxBuckets int
+	swept      time.Time
+}
+
+// responseKey identifies the responses that count as identical. Answers are
+// told apart by name and type; NXDOMAIN and error responses only by rcode,
+// so random names don't get around the limit.
+type responseKey struct {
+	client netip.Prefix
+	name   string
+	qtype  uint16
+	rcode  int
+}
+
+// NewRateLimiter returns next limited by cfg, or next itself if cfg sets no
+// limits. listener names the listener in the metrics.
+func NewRateLimiter(next dns.Handler, listener string, cfg config.RateLimitConfig) dns.Handler {
+	if !cfg.Enabled() {
+		return next
+	}
+	return &RateLimiter{
+		next:       next,
+		listener:   listener,
+		cfg:        cfg,
+		now:        time.Now,
+		queries:    make(map[netip.Prefix]*bucket),
+		responses:  make(map[responseKey]*bucket),
+		maxBuckets: maxBuckets,
+	}
+}
+
+// ServeDNS drops r if its client is over the query limit, and passes it on
+// otherwise.
+func (l *RateLimiter) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	client, ok := l.prefix(clientAddr(w.RemoteAddr()))
+	if !ok || selfProbe(w, r) {
+		l.next.ServeDNS(w, r)
+		return
+	}
+
+	if l.cfg.QueriesPerSecond > 0 && !l.allowQuery(client) {
+		metrics.ObserveRateLimited(l.listener, limitQuery, actionDrop)
+		logging.Debugf("Query from %s dropped over the rate limit", client)
+		return
+	}
+	// TCP clients can't spoof their address, so only UDP responses count
+	if l.cfg.ResponsesPerSecond > 0 && protocol(w.RemoteAddr()) == "udp" {
+		w = &rrlWriter{ResponseWriter: w, limiter: l, client: client, request: r}
+	}
+	l.next.ServeDNS(w, r)
+}
+
+// prefix returns the prefix counting for a client at addr.
+func (l *RateLimiter) prefix(addr netip.Addr) (netip.Prefix, bool) {
+	if !addr.IsValid() {
+		return netip.Prefix{}, false
+	}
+	bits := l.cfg.IPv6Prefix
+	if addr.Is4() {
+		bits = l.cfg.IPv4Prefix
+	}
+	prefix, err := addr.Prefix(bits)
+	return prefix, err == nil
+}
+
+// allowQuery takes a token from the query bucket of client.
+func (l *RateLimiter) allowQuery(client netip.Prefix) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	b := bucketFor(l.queries, client, l.maxBuckets, now, float64(l.cfg.QueryBurst))
+	return b.take(now, l.cfg.QueriesPerSecond, float64(l.cfg.QueryBurst))
+}
+
+// checkResponse returns whether a response for key may be sent, and if not,
+// whether it is sent truncated instead.
+func (l *RateLimiter) checkResponse(key responseKey) (allow, slip bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	burst := l.responseBurst()
+	b := bucketFor(l.responses, key, l.maxBuckets, now, burst)
+	if b.take(now, l.cfg.ResponsesPerSecond, burst) {
+		return true, false
+	}
+	b.dropped++
+	return false, l.cfg.Slip > 0 && b.dropped%l.cfg.Slip == 0
+}
+
+// responseBurst allows a second's worth of identical responses at once.
+func (l *RateLimiter) responseBurst() float64 {
+	return max(l.cfg.ResponsesPerSecond, 1)
+}
+
+// sweep forgets the buckets that have refilled, which a new bucket would
+// be the same as, at most once per sweepInterval.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for client, b := range l.queries {
+		if b.full(now, l.cfg.QueriesPerSecond, float64(l.cfg.QueryBurst)) {
+			delete(l.queries, client)
+		}
+	}
+	for key, b := range l.responses {
+		if b.full(now, l.cfg.ResponsesPerSecond, l.responseBurst()) {
+			delete(l.responses, key)
+		}
+	}
+}
+
+// bucket is a token bucket.
+type bucket struct {
+	tokens  float64
+	last    time.Time
+	dropped int // responses over the limit, to slip every Slip-th one
+}
+
+// bucketFor returns the bucket of key in table, adding a full one if there is
+// none. A table holding limit buckets first loses one at random.
+func bucketFor[K comparable](table map[K]*bucket, key K, limit int, now time.Time, burst float64) *bucket {
+	if b, ok := table[key]; ok {
+		return b
+	}
+	if len(table) >= limit {
+		for evicted := range table {
+			delete(table, evicted)
+			break
+		}
+	}
+	b := &bucket{tokens: burst, last: now}
+	table[key] = b
+	return b
+}
+
+// take refills the bucket at rate tokens per second up to burst, and takes a
+// token if there is one.
+func (b *bucket) take(now time.Time, rate, burst float64) bool {
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full reports whether the bucket has refilled by now.
+func (b *bucket) full(now time.Time, rate, burst float64) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*rate >= burst
+}
+
+// rrlWriter applies response rate limiting to the responses written to a
+// client.
+type rrlWriter struct {
+	dns.ResponseWriter
+	limiter *RateLimiter
+	client  netip.Prefix
+	request *dns.Msg
+}
+
+func (w *rrlWriter) WriteMsg(m *dns.Msg) error {
+	allow, slip := w.limiter.checkResponse(w.key(m))
+	if allow {
+		return w.ResponseWriter.WriteMsg(m)
+	}
+	if !slip {
+		metrics.ObserveRateLimited(w.limiter.listener, limitResponse, actionDrop)
+		logging.Debugf("Response to %s dropped over the rate limit", w.client)
+		return nil
+	}
+
+	metrics.ObserveRateLimited(w.limiter.listener, limitResponse, actionSlip)
+	tc := new(dns.Msg)
+	tc.SetRcode(w.request, m.Rcode)
+	tc.Truncated = true
+	return w.ResponseWriter.WriteMsg(tc)
+}
+
+// key returns the key m counts under.
+func (w *rrlWriter) key(m *dns.Msg) responseKey {
+	key := responseKey{client: w.client, rcode: m.Rcode}
+	if m.Rcode == dns.RcodeSuccess && len(m.Question) > 0 {
+		key.name = strings.ToLower(m.Question[0].Name)
+		key.qtype = m.Question[0].Qtype
+	}
+	return key
+}
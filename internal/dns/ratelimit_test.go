@@ -0,0 +1,163 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+// answerHandler answers example.com with NOERROR and other names with
+// NXDOMAIN.
+var answerHandler = dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if r.Question[0].Name != "example.com." {
+		m.Rcode = dns.RcodeNameError
+	}
+	w.WriteMsg(m)
+})
+
+func TestRateLimiterDisabled(t *testing.T) {
+	if h := NewRateLimiter(answerHandler, "", config.RateLimitConfig{IPv4Prefix: 24}); h == nil {
+		t.Fatal("NewRateLimiter() = nil")
+	} else if _, ok := h.(*RateLimiter); ok {
+		t.Error("NewRateLimiter() wrapped the handler without limits")
+	}
+}
+
+func TestRateLimiterQueries(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewRateLimiter(answerHandler, "lan", config.RateLimitConfig{
+		QueriesPerSecond: 2,
+		QueryBurst:       3,
+		IPv4Prefix:       24,
+		IPv6Prefix:       56,
+	}).(*RateLimiter)
+	limiter.now = func() time.Time { return now }
+
+	query := func(client string) bool {
+		w := &mockResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP(client), Port: 5353}}
+		r := new(dns.Msg)
+		r.SetQuestion("example.com.", dns.TypeA)
+		limiter.ServeDNS(w, r)
+		return len(w.msgs) == 1
+	}
+
+	// The burst, then nothing until the bucket refills
+	for i := range 3 {
+		if !query("192.0.2.1") {
+			t.Fatalf("Query %d dropped within the burst", i+1)
+		}
+	}
+	if query("192.0.2.2") {
+		t.Error("Query from the same /24 answered over the limit")
+	}
+	if !query("198.51.100.1") {
+		t.Error("Query from another client dropped")
+	}
+	if !query("2001:db8::1") || !query("2001:db8::1") || !query("2001:db8::1") || query("2001:db8:0:ff::1") {
+		t.Error("IPv6 clients in the same /56 aren't limited together")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !query("192.0.2.1") {
+		t.Error("Query dropped after a token was refilled")
+	}
+	if query("192.0.2.1") {
+		t.Error("Query answered after the refilled token was used")
+	}
+
+	// Refilled buckets are forgotten
+	now = now.Add(2 * sweepInterval)
+	query("203.0.113.1")
+	if _, ok := limiter.queries[netip.MustParsePrefix("192.0.2.0/24")]; ok {
+		t.Error("Refilled bucket was kept")
+	}
+}
+
+func TestRateLimiterResponses(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewRateLimiter(answerHandler, "", config.RateLimitConfig{
+		ResponsesPerSecond: 2,
+		Slip:               2,
+		IPv4Prefix:         24,
+		IPv6Prefix:         56,
+	}).(*RateLimiter)
+	limiter.now = func() time.Time { return now }
+
+	query := func(remote net.Addr, qname string) *dns.Msg {
+		w := &mockResponseWriter{remote: remote}
+		r := new(dns.Msg)
+		r.SetQuestion(qname, dns.TypeA)
+		limiter.ServeDNS(w, r)
+		if len(w.msgs) == 0 {
+			return nil
+		}
+		return w.msgs[0]
+	}
+	udp := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5353}
+
+	for i := range 2 {
+		if m := query(udp, "example.com."); m == nil || m.Truncated {
+			t.Fatalf("Response %d = %v, want a full response", i+1, m)
+		}
+	}
+	// Over the limit, every second response slips out truncated
+	if m := query(udp, "example.com."); m != nil {
+		t.Errorf("Response over the limit was sent")
+	}
+	if m := query(udp, "example.com."); m == nil || !m.Truncated || len(m.Question) != 1 {
+		t.Errorf("Slipped response = %v, want a truncated response", m)
+	}
+	if m := query(udp, "example.com."); m != nil {
+		t.Errorf("Response over the limit was sent")
+	}
+
+	// Other names are counted apart, but NXDOMAIN responses together
+	if m := query(udp, "www.example.com."); m == nil || m.Rcode != dns.RcodeNameError {
+		t.Fatalf("NXDOMAIN response = %v", m)
+	}
+	query(udp, "a.example.com.")
+	if m := query(udp, "b.example.com."); m != nil {
+		t.Error("NXDOMAIN responses for random names aren't limited together")
+	}
+
+	// TCP responses aren't limited
+	tcp := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5353}
+	if m := query(tcp, "example.com."); m == nil || m.Truncated {
+		t.Errorf("TCP response = %v, want a full response", m)
+	}
+
+	now = now.Add(time.Second)
+	if m := query(udp, "example.com."); m == nil || m.Truncated {
+		t.Errorf("Response = %v after the bucket refilled, want a full response", m)
+	}
+}
+
+func TestRateLimiterBucketLimit(t *testing.T) {
+	limiter := NewRateLimiter(answerHandler, "", config.RateLimitConfig{
+		QueriesPerSecond:   1,
+		QueryBurst:         1,
+		ResponsesPerSecond: 1,
+		IPv4Prefix:         32,
+	}).(*RateLimiter)
+	limiter.maxBuckets = 4
+
+	// Spoofed sources, each wanting a bucket of its own
+	for i := range 20 {
+		w := &mockResponseWriter{remote: &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(i)), Port: 5353}}
+		r := new(dns.Msg)
+		r.SetQuestion("example.com.", dns.TypeA)
+		limiter.ServeDNS(w, r)
+		if len(w.msgs) != 1 {
+			t.Fatalf("Query %d from a new client wasn't answered", i+1)
+		}
+	}
+	if len(limiter.queries) != 4 || len(limiter.responses) != 4 {
+		t.Errorf("Tables hold %d query and %d response buckets, want 4 each", len(limiter.queries), len(limiter.responses))
+	}
+}
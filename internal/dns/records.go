@@ -1,12 +1,15 @@
 package dns
 
 import (
+	"cmp"
 	"fmt"
-	"log"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/mguptahub/nanodns/internal/logging"
 	"github.com/mguptahub/nanodns/pkg/config"
 )
 
@@ -20,6 +23,8 @@ const (
 
 	// Record separator
 	RecordSeparator = "|"
+	// ViewSeparator scopes a record to a view, e.g. api.example.com@internal
+	ViewSeparator = "@"
 )
 
 type DNSRecord struct {
@@ -29,36 +34,98 @@ type DNSRecord struct {
 	RecordType RecordType
 	IsService  bool
 	Priority   uint16 // For MX records
-}
+	View       string // Empty for records visible to every client
 
-var records = make(map[string][]DNSRecord)
+	// Options of A records
+	Health *HealthCheck // Active health check of the address, if any
+	Backup bool         // Only served when no primary address is healthy
+	Weight int          // Relative weight for weighted answers, 0 means 1
+}
 
-// LoadRecords loads DNS records from environment variables
+// LoadRecords loads DNS records from environment variables. The records of
+// a name keep the order of their variable names, with numbers compared by
+// value (A_REC2 comes before A_REC10), since the order the variables were
+// set in is lost.
 func LoadRecords() map[string][]DNSRecord {
+	var keys []string
 	for _, env := range os.Environ() {
-		pair := strings.SplitN(env, "=", 2)
-		key := pair[0]
-		value := pair[1]
-
+		key, _, _ := strings.Cut(env, "=")
 		if strings.HasPrefix(key, "A_") ||
 			strings.HasPrefix(key, "CNAME_") ||
 			strings.HasPrefix(key, "MX_") ||
 			strings.HasPrefix(key, "TXT_") {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, compareKeys)
 
-			record, err := parseRecord(key, value)
-			if err != nil {
-				log.Printf("Error parsing record %s: %v", key, err)
-				continue
-			}
-			domain := record.Domain
-			records[domain] = append(records[domain], record)
+	records := make(map[string][]DNSRecord)
+	for _, key := range keys {
+		record, err := parseRecord(key, os.Getenv(key))
+		if err != nil {
+			logging.Warnf("Error parsing record %s: %v", key, err)
+			continue
+		}
+		if _, err := compileRecord(record); err != nil {
+			logging.Warnf("Error validating record %s: %v", key, err)
+			continue
 		}
+		domain := record.Domain
+		records[domain] = append(records[domain], record)
 	}
 
-	logLoadedRecords()
+	logLoadedRecords(records)
 	return records
 }
 
+// compareKeys orders variable names with the runs of digits in them compared
+// by value.
+func compareKeys(a, b string) int {
+	x, y := a, b
+	for x != "" && y != "" {
+		dx, dy := leadingDigits(x), leadingDigits(y)
+		if dx == "" || dy == "" {
+			if x[0] != y[0] {
+				return cmp.Compare(x[0], y[0])
+			}
+			x, y = x[1:], y[1:]
+			continue
+		}
+		nx, ny := strings.TrimLeft(dx, "0"), strings.TrimLeft(dy, "0")
+		if c := cmp.Compare(len(nx), len(ny)); c != 0 {
+			return c
+		}
+		if c := strings.Compare(nx, ny); c != 0 {
+			return c
+		}
+		x, y = x[len(dx):], y[len(dy):]
+	}
+	if c := cmp.Compare(len(x), len(y)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// leadingDigits returns the digits s starts with.
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// CountByType returns the number of records of each record type.
+func CountByType(records map[string][]DNSRecord) map[string]int {
+	counts := make(map[string]int)
+	for _, recs := range records {
+		for _, rec := range recs {
+			counts[string(rec.RecordType)]++
+		}
+	}
+	return counts
+}
+
 func parseRecord(key, value string) (DNSRecord, error) {
 	parts := strings.Split(value, RecordSeparator)
 
@@ -66,7 +133,7 @@ func parseRecord(key, value string) (DNSRecord, error) {
 		return DNSRecord{}, fmt.Errorf("invalid format: expected parts separated by %s", RecordSeparator)
 	}
 
-	domain := parts[0]
+	domain, view, _ := strings.Cut(parts[0], ViewSeparator)
 	if !strings.HasSuffix(domain, ".") {
 		domain = domain + "."
 	}
@@ -75,6 +142,7 @@ func parseRecord(key, value string) (DNSRecord, error) {
 	record := DNSRecord{
 		Domain: domain,
 		TTL:    ttl,
+		View:   strings.ToLower(view),
 	}
 
 	// Set record type and parse value based on prefix
@@ -86,10 +154,15 @@ func parseRecord(key, value string) (DNSRecord, error) {
 			record.IsService = true
 			record.Value = config.GetServiceName(record.Value)
 		}
-		if len(parts) > 2 {
-			if parsedTTL, err := strconv.ParseUint(parts[2], 10, 32); err == nil {
+		options := parts[2:]
+		if len(options) > 0 && !strings.Contains(options[0], "=") {
+			if parsedTTL, err := strconv.ParseUint(options[0], 10, 32); err == nil {
 				record.TTL = uint32(parsedTTL)
 			}
+			options = options[1:]
+		}
+		if err := parseAddressOptions(&record, options); err != nil {
+			return DNSRecord{}, err
 		}
 
 	case strings.HasPrefix(key, "CNAME_"):
@@ -131,8 +204,84 @@ func parseRecord(key, value string) (DNSRecord, error) {
 	return record, nil
 }
 
-func logLoadedRecords() {
-	log.Println("Loaded DNS Records")
+// parseAddressOptions parses the key=value options that may follow the TTL
+// of an A record, e.g. check=http:8080/healthz|interval=5s|weight=10.
+func parseAddressOptions(record *DNSRecord, options []string) error {
+	var check string
+	settings := make(map[string]string)
+	for _, option := range options {
+		key, value, ok := strings.Cut(option, "=")
+		if !ok {
+			return fmt.Errorf("invalid option %q: expected key=value", option)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "check":
+			check = value
+		case "expect", "interval", "timeout", "threshold":
+			settings[key] = value
+		case "weight":
+			weight, err := strconv.Atoi(value)
+			if err != nil || weight < 1 {
+				return fmt.Errorf("invalid weight %q: must be a positive integer", value)
+			}
+			record.Weight = weight
+		case "backup":
+			backup, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid backup option %q: %v", value, err)
+			}
+			record.Backup = backup
+		default:
+			return fmt.Errorf("unknown option %q", key)
+		}
+	}
+
+	if check == "" {
+		if len(settings) > 0 {
+			return fmt.Errorf("health check options require check=type:port")
+		}
+		return nil
+	}
+
+	health, err := parseHealthCheck(check)
+	if err != nil {
+		return err
+	}
+	for key, value := range settings {
+		switch key {
+		case "expect":
+			status, err := strconv.Atoi(value)
+			if err != nil || status < 100 || status > 599 {
+				return fmt.Errorf("invalid expected status %q", value)
+			}
+			health.Expect = status
+		case "interval", "timeout":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid %s %q", key, value)
+			}
+			if key == "interval" {
+				health.Interval = d
+			} else {
+				health.Timeout = d
+			}
+		case "threshold":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid threshold %q", value)
+			}
+			health.Threshold = n
+		}
+	}
+	record.Health = health
+	return nil
+}
+
+func logLoadedRecords(records map[string][]DNSRecord) {
+	logging.Infof("Loaded DNS Records")
 	for domain, recs := range records {
 		for _, rec := range recs {
 			var extraInfo string
@@ -143,8 +292,20 @@ func logLoadedRecords() {
 				if rec.IsService {
 					extraInfo = " (Docker Service)"
 				}
+				if rec.Health != nil {
+					extraInfo += fmt.Sprintf(" Check: %s", rec.Health)
+				}
+				if rec.Backup {
+					extraInfo += " Backup"
+				}
+				if rec.Weight > 0 {
+					extraInfo += fmt.Sprintf(" Weight: %d", rec.Weight)
+				}
+			}
+			if rec.View != "" {
+				extraInfo += fmt.Sprintf(" View: %s", rec.View)
 			}
-			log.Printf("%s -> %s (TTL: %d, Type: %s%s)",
+			logging.Infof("%s -> %s (TTL: %d, Type: %s%s)",
 				domain, rec.Value, rec.TTL, rec.RecordType, extraInfo)
 		}
 	}
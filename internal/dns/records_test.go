@@ -2,7 +2,13 @@ package dns
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
 )
 
 func TestParseRecord(t *testing.T) {
@@ -77,6 +83,68 @@ func TestParseRecord(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:  "A record scoped to a view",
+			key:   "A_REC2",
+			value: "api.example.com@Internal|10.0.0.5",
+			wantRecord: DNSRecord{
+				Domain:     "api.example.com.",
+				Value:      "10.0.0.5",
+				TTL:        60,
+				RecordType: ARecord,
+				View:       "internal",
+			},
+			wantErr: false,
+		},
+		{
+			name:  "A record with health check options",
+			key:   "A_REC3",
+			value: "api.example.com|10.0.0.1|30|check=http:8080/healthz|expect=204|interval=5s|threshold=3",
+			wantRecord: DNSRecord{
+				Domain:     "api.example.com.",
+				Value:      "10.0.0.1",
+				TTL:        30,
+				RecordType: ARecord,
+				Health: &HealthCheck{
+					Type:      HealthCheckHTTP,
+					Port:      8080,
+					Path:      "/healthz",
+					Expect:    204,
+					Interval:  5 * time.Second,
+					Timeout:   DefaultHealthTimeout,
+					Threshold: 3,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "backup A record without TTL",
+			key:   "A_REC4",
+			value: "api.example.com|10.0.1.1|backup=true|weight=10",
+			wantRecord: DNSRecord{
+				Domain:     "api.example.com.",
+				Value:      "10.0.1.1",
+				TTL:        60,
+				RecordType: ARecord,
+				Backup:     true,
+				Weight:     10,
+			},
+			wantErr: false,
+		},
+		{
+			name:        "unknown A record option",
+			key:         "A_REC5",
+			value:       "api.example.com|10.0.1.1|60|colour=blue",
+			wantErr:     true,
+			errContains: "unknown option",
+		},
+		{
+			name:        "health check settings without check",
+			key:         "A_REC6",
+			value:       "api.example.com|10.0.1.1|60|interval=5s",
+			wantErr:     true,
+			errContains: "health check options require",
+		},
 		{
 			name:        "invalid format",
 			key:         "A_REC1",
@@ -133,6 +201,7 @@ func TestLoadRecords(t *testing.T) {
 		"CNAME_REC1": "www.example.com|app.example.com|600",
 		"MX_REC1":    "example.com|10|mail.example.com|300",
 		"TXT_REC1":   "example.com|v=spf1 include:_spf.example.com ~all|300",
+		"A_REC2":     "bad.example.com|999.168.1.1",
 	}
 
 	for k, v := range testEnv {
@@ -149,7 +218,8 @@ func TestLoadRecords(t *testing.T) {
 	}{
 		{"app.example.com.", 1},
 		{"www.example.com.", 1},
-		{"example.com.", 2}, // MX and TXT records
+		{"example.com.", 2},     // MX and TXT records
+		{"bad.example.com.", 0}, // Invalid IP address is rejected
 	}
 
 	for _, tt := range tests {
@@ -162,6 +232,52 @@ func TestLoadRecords(t *testing.T) {
 	}
 }
 
+func TestLoadRecordsOrder(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	t.Setenv("NANODNS_ENV_FILE", envFile)
+	t.Setenv("NANODNS_RECORDS_FILE", "")
+	keys := []string{"A_ORDER10", "A_ORDER2", "A_ORDER1", "A_ORDER_B", "A_ORDER_A"}
+	for _, key := range keys {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+	content := "A_ORDER10=order.example.com|10.0.0.3\n" +
+		"A_ORDER2=order.example.com|10.0.0.2\n" +
+		"A_ORDER1=order.example.com|10.0.0.1\n" +
+		"A_ORDER_B=order.example.com|10.0.0.5\n" +
+		"A_ORDER_A=order.example.com|10.0.0.4\n"
+	if err := os.WriteFile(envFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.Initialize()
+
+	want := "10.0.0.1,10.0.0.2,10.0.0.3,10.0.0.4,10.0.0.5"
+	for i := range 10 {
+		if i > 0 {
+			if err := config.ReloadEnvFile(); err != nil {
+				t.Fatalf("ReloadEnvFile() error = %v", err)
+			}
+		}
+		handler, err := NewHandler(LoadRecords(), config.RelayConfig{})
+		if err != nil {
+			t.Fatalf("NewHandler() error = %v", err)
+		}
+		w := &mockResponseWriter{msgs: make([]*dns.Msg, 0)}
+		r := new(dns.Msg)
+		r.SetQuestion("order.example.com.", dns.TypeA)
+		handler.ServeDNS(w, r)
+		handler.Close()
+
+		var got []string
+		for _, rr := range w.msgs[0].Answer {
+			got = append(got, rr.(*dns.A).A.String())
+		}
+		if strings.Join(got, ",") != want {
+			t.Fatalf("Load %d answered %v, want %s", i+1, got, want)
+		}
+	}
+}
+
 // Helper functions
 func contains(s, substr string) bool {
 	return s != "" && substr != "" && s != substr && len(s) > len(substr) && s[:len(substr)] == substr
@@ -173,7 +289,12 @@ func recordsEqual(a, b DNSRecord) bool {
 		a.TTL == b.TTL &&
 		a.RecordType == b.RecordType &&
 		a.IsService == b.IsService &&
-		a.Priority == b.Priority
+		a.Priority == b.Priority &&
+		a.View == b.View &&
+		a.Backup == b.Backup &&
+		a.Weight == b.Weight &&
+		(a.Health == nil) == (b.Health == nil) &&
+		(a.Health == nil || *a.Health == *b.Health)
 }
 
 func splitEnv(env string) [2]string {
@@ -2,9 +2,14 @@ package dns
 
 import (
 	"fmt"
-	"log"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/mguptahub/nanodns/internal/dnstap"
+	"github.com/mguptahub/nanodns/internal/logging"
+	"github.com/mguptahub/nanodns/internal/metrics"
 	"github.com/mguptahub/nanodns/pkg/config"
 	"github.com/miekg/dns"
 )
@@ -12,6 +17,9 @@ import (
 type RelayClient struct {
 	config config.RelayConfig
 	client *dns.Client
+
+	lastSuccess atomic.Int64   // Unix nanoseconds of the last successful exchange
+	tap         *dnstap.Writer // nil unless dnstap is enabled
 }
 
 type RelayError struct {
@@ -49,25 +57,44 @@ func NewRelayClient(config config.RelayConfig) (*RelayClient, error) {
 
 const defaultDNSPort = "53"
 
+// upstreamFresh is how long a successful relay vouches for the upstreams
+const upstreamFresh = 30 * time.Second
+
+// serverAddr ensures a nameserver address has a port.
+func serverAddr(ns string) string {
+	if !strings.Contains(ns, ":") {
+		return ns + ":" + defaultDNSPort
+	}
+	return ns
+}
+
 // Relay forwards the DNS request to configured upstream nameservers.
 // It attempts each nameserver in sequence until a successful response is received.
 // Returns the first successful response or an error if all nameservers fail.
 func (r *RelayClient) Relay(req *dns.Msg) (*dns.Msg, error) {
+	response, _, err := r.relay(req)
+	return response, err
+}
+
+// relay is Relay, also returning the nameserver that answered.
+func (r *RelayClient) relay(req *dns.Msg) (*dns.Msg, string, error) {
 	if len(req.Question) == 0 {
-		return nil, fmt.Errorf("empty question in DNS request")
+		return nil, "", fmt.Errorf("empty question in DNS request")
 	}
 	var lastErr error
 
 	for _, ns := range r.config.Nameservers {
-		// Ensure server address has port
-		if !strings.Contains(ns, ":") {
-			ns = ns + ":" + defaultDNSPort
-		}
+		ns = serverAddr(ns)
 
-		log.Printf("relay_attempt: server=%s, query=%s", ns, req.Question[0].Name)
+		logging.Debugf("relay_attempt: server=%s, query=%s", ns, req.Question[0].Name)
+		sent := time.Now()
+		if r.tap != nil {
+			tapForwarder(r.tap, dnstap.ForwarderQuery, ns, req, sent)
+		}
 		response, rtt, err := r.client.Exchange(req, ns)
+		metrics.ObserveRelay(ns, rtt, err)
 		if err != nil {
-			log.Printf("relay_failed: server=%s, query=%s, error=%v", ns, req.Question[0].Name, err)
+			logging.Warnf("relay_failed: server=%s, query=%s, error=%v", ns, req.Question[0].Name, err)
 			lastErr = &RelayError{
 				Server: ns,
 				Err:    err,
@@ -76,9 +103,66 @@ func (r *RelayClient) Relay(req *dns.Msg) (*dns.Msg, error) {
 			continue
 		}
 
-		log.Printf("relay_success: server=%s, query=%s, rcode=%v, rtt=%v", ns, req.Question[0].Name, response.Rcode, rtt)
-		return response, nil
+		logging.Debugf("relay_success: server=%s, query=%s, rcode=%v, rtt=%v", ns, req.Question[0].Name, response.Rcode, rtt)
+		r.lastSuccess.Store(time.Now().UnixNano())
+		if r.tap != nil {
+			tapForwarder(r.tap, dnstap.ForwarderResponse, ns, response, sent)
+		}
+		return response, ns, nil
+	}
+
+	return nil, "", fmt.Errorf("all nameservers failed, last error: %v", lastErr)
+}
+
+// Check returns nil if at least one upstream nameserver answers. A recent
+// successful relay is enough; otherwise the nameservers are asked for the
+// root NS records until one of them responds.
+func (r *RelayClient) Check() error {
+	if time.Since(time.Unix(0, r.lastSuccess.Load())) < upstreamFresh {
+		return nil
 	}
 
-	return nil, fmt.Errorf("all nameservers failed, last error: %v", lastErr)
+	req := new(dns.Msg)
+	req.SetQuestion(".", dns.TypeNS)
+	req.RecursionDesired = true
+
+	var lastErr error
+	for _, ns := range r.config.Nameservers {
+		ns = serverAddr(ns)
+		if _, _, err := r.client.Exchange(req, ns); err != nil {
+			lastErr = &RelayError{Server: ns, Err: err, Query: "."}
+			continue
+		}
+		r.lastSuccess.Store(time.Now().UnixNano())
+		return nil
+	}
+	return fmt.Errorf("no upstream nameserver is reachable, last error: %v", lastErr)
+}
+
+// UpstreamStatus is the outcome of probing one upstream nameserver.
+type UpstreamStatus struct {
+	Server string
+	RTT    time.Duration
+	Err    error // nil if the nameserver answered
+}
+
+// Upstreams asks every nameserver for the root NS records in parallel and
+// reports how each of them did, in configuration order.
+func (r *RelayClient) Upstreams() []UpstreamStatus {
+	req := new(dns.Msg)
+	req.SetQuestion(".", dns.TypeNS)
+	req.RecursionDesired = true
+
+	statuses := make([]UpstreamStatus, len(r.config.Nameservers))
+	var wg sync.WaitGroup
+	for i, ns := range r.config.Nameservers {
+		wg.Add(1)
+		go func(i int, ns string) {
+			defer wg.Done()
+			_, rtt, err := r.client.Exchange(req.Copy(), ns)
+			statuses[i] = UpstreamStatus{Server: ns, RTT: rtt, Err: err}
+		}(i, serverAddr(ns))
+	}
+	wg.Wait()
+	return statuses
 }
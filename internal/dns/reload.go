@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"sort"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+// handlerState holds everything a reload replaces. Queries load it once, so
+// each of them sees either the old or the new records, never a mix.
+type handlerState struct {
+	index   *recordIndex // records visible to clients outside of every view
+	views   []*view
+	health  *healthChecker
+	records map[string][]DNSRecord // as configured, for listing
+}
+
+// buildState normalizes all record names to lowercase, makes sure they're
+// fully qualified and builds their answers once up front. The health checks
+// of the new state are not started yet.
+func buildState(records map[string][]DNSRecord, viewConfigs []config.ViewConfig, policies map[string]*AnswerPolicy) (*handlerState, error) {
+	health := newHealthChecker()
+	index, views, err := buildIndexes(records, viewConfigs, canonicalPolicies(policies), health)
+	if err != nil {
+		return nil, err
+	}
+	return &handlerState{index: index, views: views, health: health, records: records}, nil
+}
+
+// Reload replaces the records, views and answer policies. Queries in flight
+// finish with the previous records; on error the handler keeps serving them.
+func (h *Handler) Reload(records map[string][]DNSRecord, viewConfigs []config.ViewConfig, policies map[string]*AnswerPolicy) error {
+	st, err := buildState(records, viewConfigs, policies)
+	if err != nil {
+		return err
+	}
+
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+	st.health.inherit(h.state.Load().health)
+	st.health.start()
+	old := h.state.Swap(st)
+	old.health.close()
+	return nil
+}
+
+// Records returns the configured records sorted by name, type and value.
+func (h *Handler) Records() []DNSRecord {
+	var list []DNSRecord
+	for _, recs := range h.state.Load().records {
+		list = append(list, recs...)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Domain != b.Domain {
+			return dns.CanonicalName(a.Domain) < dns.CanonicalName(b.Domain)
+		}
+		if a.View != b.View {
+			return a.View < b.View
+		}
+		if a.RecordType != b.RecordType {
+			return a.RecordType < b.RecordType
+		}
+		return a.Value < b.Value
+	})
+	return list
+}
@@ -0,0 +1,142 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+func TestHandlerReload(t *testing.T) {
+	records := map[string][]DNSRecord{
+		"api.example.com.": {
+			{Domain: "api.example.com.", Value: "192.0.2.1", TTL: 60, RecordType: ARecord},
+		},
+	}
+	handler, err := NewHandler(records, config.RelayConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer handler.Close()
+
+	query := func(remote net.Addr, name string) *dns.Msg {
+		w := &mockResponseWriter{msgs: make([]*dns.Msg, 0), remote: remote}
+		r := new(dns.Msg)
+		r.SetQuestion(name, dns.TypeA)
+		handler.ServeDNS(w, r)
+		return w.msgs[0]
+	}
+
+	reloaded := map[string][]DNSRecord{
+		"api.example.com.": {
+			{Domain: "api.example.com.", Value: "192.0.2.2", TTL: 60, RecordType: ARecord},
+			{Domain: "api.example.com.", Value: "192.0.2.3", TTL: 60, RecordType: ARecord},
+			{Domain: "api.example.com.", Value: "10.0.0.2", TTL: 60, RecordType: ARecord, View: "internal"},
+		},
+		"www.example.com.": {
+			{Domain: "www.example.com.", Value: "api.example.com.", TTL: 60, RecordType: CNAMERecord},
+		},
+	}
+	views := []config.ViewConfig{
+		{Name: "internal", Networks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+	}
+	policies := map[string]*AnswerPolicy{
+		"API.example.com": {Domain: "api.example.com.", Mode: PolicyOrdered, Count: 1},
+	}
+	if err := handler.Reload(reloaded, views, policies); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	msg := query(nil, "api.example.com.")
+	if len(msg.Answer) != 1 || msg.Answer[0].(*dns.A).A.String() != "192.0.2.2" {
+		t.Errorf("Expected the reloaded record limited by its policy, got %v", msg.Answer)
+	}
+	msg = query(&net.UDPAddr{IP: net.ParseIP("10.1.2.3")}, "api.example.com.")
+	if len(msg.Answer) != 1 || msg.Answer[0].(*dns.A).A.String() != "10.0.0.2" {
+		t.Errorf("Expected the reloaded view's record, got %v", msg.Answer)
+	}
+	if msg := query(nil, "www.example.com."); msg.Rcode != dns.RcodeSuccess || len(msg.Answer) == 0 {
+		t.Errorf("Expected an answer for the added name, got %v", msg)
+	}
+
+	// An invalid configuration leaves the current records in place
+	invalid := map[string][]DNSRecord{
+		"api.example.com.": {
+			{Domain: "api.example.com.", Value: "not-an-ip", TTL: 60, RecordType: ARecord},
+		},
+	}
+	if err := handler.Reload(invalid, nil, nil); err == nil {
+		t.Error("Reload() of an invalid record succeeded")
+	}
+	if msg := query(nil, "www.example.com."); len(msg.Answer) == 0 {
+		t.Error("Failed reload replaced the records")
+	}
+
+	got := handler.Records()
+	want := []string{"192.0.2.2", "192.0.2.3", "10.0.0.2", "api.example.com."}
+	if len(got) != len(want) {
+		t.Fatalf("Records() returned %d records, want %d", len(got), len(want))
+	}
+	for i, rec := range got {
+		if rec.Value != want[i] {
+			t.Errorf("Records()[%d] = %s, want %s", i, rec.Value, want[i])
+		}
+	}
+}
+
+func TestHandlerReloadKeepsHealthState(t *testing.T) {
+	check := &HealthCheck{Type: HealthCheckTCP, Port: 1, Interval: time.Hour, Timeout: 10 * time.Millisecond, Threshold: 1}
+	records := map[string][]DNSRecord{
+		"api.example.com.": {
+			{Domain: "api.example.com.", Value: "127.0.0.1", TTL: 60, RecordType: ARecord, Health: check},
+		},
+	}
+	handler, err := NewHandler(records, config.RelayConfig{Enabled: false})
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	defer handler.Close()
+
+	for _, target := range handler.state.Load().health.targets {
+		target.healthy.Store(false)
+	}
+	if err := handler.Reload(records, nil, nil); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	for _, target := range handler.state.Load().health.targets {
+		if target.healthy.Load() {
+			t.Error("Reload() brought an unhealthy address back into service")
+		}
+	}
+}
+
+func TestRelayClient_Upstreams(t *testing.T) {
+	upstream := startTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	}))
+
+	client, err := NewRelayClient(config.RelayConfig{
+		Enabled:     true,
+		Nameservers: []string{"127.0.0.1:1", upstream},
+		Timeout:     200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewRelayClient() error = %v", err)
+	}
+
+	statuses := client.Upstreams()
+	if len(statuses) != 2 {
+		t.Fatalf("Upstreams() returned %d statuses, want 2", len(statuses))
+	}
+	if statuses[0].Server != "127.0.0.1:1" || statuses[0].Err == nil {
+		t.Errorf("Expected the unreachable upstream to be down, got %+v", statuses[0])
+	}
+	if statuses[1].Server != upstream || statuses[1].Err != nil {
+		t.Errorf("Expected %s to be up, got %+v", upstream, statuses[1])
+	}
+}
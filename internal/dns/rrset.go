@@ -0,0 +1,139 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+	"github.com/mguptahub/nanodns/internal/metrics"
+	"github.com/miekg/dns"
+)
+
+// maxTXTStringLen is the longest character-string a TXT record can carry
+const maxTXTStringLen = 255
+
+// compiledRecord pairs a record with the resource record built for it when
+// the handler was created. rr is shared between queries and must never be
+// modified; it is nil for Docker service records, which are resolved per query.
+type compiledRecord struct {
+	DNSRecord
+	rr     dns.RR
+	health *healthTarget // nil unless the record has a health check
+	policy *AnswerPolicy // answer policy of the owner name, A records only
+}
+
+// compileRecord validates rec and builds its answer with rec.Domain as owner.
+func compileRecord(rec DNSRecord) (compiledRecord, error) {
+	owner := dns.CanonicalName(rec.Domain)
+	hdr := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: owner, Rrtype: rrtype, Class: dns.ClassINET, Ttl: rec.TTL}
+	}
+
+	c := compiledRecord{DNSRecord: rec}
+	switch rec.RecordType {
+	case ARecord:
+		if rec.IsService {
+			if rec.Value == "" {
+				return c, fmt.Errorf("empty service name for %s", rec.Domain)
+			}
+			if rec.Health != nil {
+				return c, fmt.Errorf("health checks need a static IP address, %s uses service %s", rec.Domain, rec.Value)
+			}
+			return c, nil
+		}
+		ip := net.ParseIP(rec.Value).To4()
+		if ip == nil {
+			return c, fmt.Errorf("invalid IPv4 address %q for %s", rec.Value, rec.Domain)
+		}
+		c.rr = &dns.A{Hdr: hdr(dns.TypeA), A: ip}
+
+	case CNAMERecord:
+		target := dns.CanonicalName(rec.Value)
+		if _, ok := dns.IsDomainName(target); !ok {
+			return c, fmt.Errorf("invalid CNAME target %q for %s", rec.Value, rec.Domain)
+		}
+		c.Value = target
+		c.rr = &dns.CNAME{Hdr: hdr(dns.TypeCNAME), Target: target}
+
+	case MXRecord:
+		target := dns.CanonicalName(rec.Value)
+		if _, ok := dns.IsDomainName(target); !ok {
+			return c, fmt.Errorf("invalid MX target %q for %s", rec.Value, rec.Domain)
+		}
+		c.Value = target
+		c.rr = &dns.MX{Hdr: hdr(dns.TypeMX), Preference: rec.Priority, Mx: target}
+
+	case TXTRecord:
+		txt := txtStrings(rec.Value)
+		if len(txt) == 0 {
+			return c, fmt.Errorf("empty TXT value for %s", rec.Domain)
+		}
+		c.rr = &dns.TXT{Hdr: hdr(dns.TypeTXT), Txt: txt}
+
+	default:
+		return c, fmt.Errorf("unsupported record type %q for %s", rec.RecordType, rec.Domain)
+	}
+
+	return c, nil
+}
+
+// answer returns the record's resource record owned by name. The precompiled
+// record is returned as is when the owner already matches, otherwise only the
+// owner name of a copy is rewritten.
+func (c compiledRecord) answer(name string) dns.RR {
+	if c.rr == nil {
+		return c.serviceAnswer(name)
+	}
+	return withOwner(c.rr, name)
+}
+
+// serviceAnswer resolves a Docker service record at query time.
+func (c compiledRecord) serviceAnswer(name string) dns.RR {
+	resolvedIP, err := ResolveServiceIP(c.Value)
+	if err != nil {
+		logging.Warnf("Failed to resolve service %s: %v", c.Value, err)
+		metrics.ServiceResolutionFailed(c.Value)
+		return nil
+	}
+	ip := net.ParseIP(resolvedIP).To4()
+	if ip == nil {
+		logging.Warnf("Service %s resolved to %q, which is not an IPv4 address", c.Value, resolvedIP)
+		metrics.ServiceResolutionFailed(c.Value)
+		return nil
+	}
+
+	return &dns.A{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+			Ttl:    c.TTL,
+		},
+		A: ip,
+	}
+}
+
+// withOwner returns rr itself if it is already owned by name, or a copy owned by name.
+func withOwner(rr dns.RR, name string) dns.RR {
+	if rr.Header().Name == name {
+		return rr
+	}
+	rr = dns.Copy(rr)
+	rr.Header().Name = name
+	return rr
+}
+
+// txtStrings splits a TXT value into its space separated strings, breaking up
+// any string longer than a single TXT character-string allows.
+func txtStrings(value string) []string {
+	var parts []string
+	for _, part := range strings.Fields(value) {
+		for len(part) > maxTXTStringLen {
+			parts = append(parts, part[:maxTXTStringLen])
+			part = part[maxTXTStringLen:]
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
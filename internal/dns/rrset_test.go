@@ -0,0 +1,132 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+)
+
+func TestCompileRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  DNSRecord
+		wantErr bool
+		want    string
+	}{
+		{
+			name:   "A record",
+			record: DNSRecord{Domain: "Example.com", Value: "192.168.1.1", TTL: 300, RecordType: ARecord},
+			want:   "example.com.\t300\tIN\tA\t192.168.1.1",
+		},
+		{
+			name:    "A record with IPv6 address",
+			record:  DNSRecord{Domain: "example.com.", Value: "2001:db8::1", TTL: 300, RecordType: ARecord},
+			wantErr: true,
+		},
+		{
+			name:    "A record with invalid address",
+			record:  DNSRecord{Domain: "example.com.", Value: "192.168.1", TTL: 300, RecordType: ARecord},
+			wantErr: true,
+		},
+		{
+			name:   "service A record",
+			record: DNSRecord{Domain: "example.com.", Value: "webapp", TTL: 300, RecordType: ARecord, IsService: true},
+		},
+		{
+			name:   "CNAME record",
+			record: DNSRecord{Domain: "www.example.com.", Value: "example.com", TTL: 300, RecordType: CNAMERecord},
+			want:   "www.example.com.\t300\tIN\tCNAME\texample.com.",
+		},
+		{
+			name:    "CNAME record with invalid target",
+			record:  DNSRecord{Domain: "www.example.com.", Value: "bad..example.com", TTL: 300, RecordType: CNAMERecord},
+			wantErr: true,
+		},
+		{
+			name:   "MX record",
+			record: DNSRecord{Domain: "example.com.", Value: "mail.example.com", TTL: 300, RecordType: MXRecord, Priority: 10},
+			want:   "example.com.\t300\tIN\tMX\t10 mail.example.com.",
+		},
+		{
+			name:   "TXT record",
+			record: DNSRecord{Domain: "example.com.", Value: "v=spf1  -all", TTL: 300, RecordType: TXTRecord},
+			want:   "example.com.\t300\tIN\tTXT\t\"v=spf1\" \"-all\"",
+		},
+		{
+			name:    "empty TXT record",
+			record:  DNSRecord{Domain: "example.com.", Value: "  ", TTL: 300, RecordType: TXTRecord},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compileRecord(tt.record)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("compileRecord() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compileRecord() error = %v, want nil", err)
+			}
+			if tt.want == "" {
+				if got.rr != nil {
+					t.Errorf("compileRecord() rr = %v, want nil", got.rr)
+				}
+				return
+			}
+			if got.rr.String() != tt.want {
+				t.Errorf("compileRecord() rr = %q, want %q", got.rr.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTXTStrings(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	got := txtStrings("v=spf1 " + long)
+	if len(got) != 3 || got[0] != "v=spf1" || len(got[1]) != 255 || len(got[2]) != 45 {
+		t.Errorf("txtStrings() = %d strings, want v=spf1 followed by 255 and 45 characters", len(got))
+	}
+}
+
+func TestNewHandlerRejectsInvalidRecords(t *testing.T) {
+	records := map[string][]DNSRecord{
+		"example.com.": {
+			{Domain: "example.com.", Value: "not-an-ip", TTL: 300, RecordType: ARecord},
+		},
+	}
+
+	if _, err := NewHandler(records, config.RelayConfig{Enabled: false}); err == nil {
+		t.Error("NewHandler() error = nil, want error for invalid A record")
+	}
+}
+
+func TestAnswerReusesPrecompiledRecords(t *testing.T) {
+	rec, err := compileRecord(DNSRecord{Domain: "example.com.", Value: "192.168.1.1", TTL: 300, RecordType: ARecord})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.answer("example.com.") != rec.rr {
+		t.Error("Expected the precompiled record for a matching owner name")
+	}
+
+	rr := rec.answer("a.example.com.")
+	if rr == rec.rr || rr.Header().Name != "a.example.com." {
+		t.Errorf("Expected a copy owned by a.example.com., got %v", rr)
+	}
+	if rec.rr.Header().Name != "example.com." {
+		t.Errorf("Precompiled record was modified: %v", rec.rr)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		rec.answer("example.com.")
+	})
+	if allocs != 0 {
+		t.Errorf("answer() allocated %v times, want 0", allocs)
+	}
+}
@@ -0,0 +1,135 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+// view is a split-horizon view with its own record index. The index holds the
+// view's records plus every default record whose name the view doesn't define.
+type view struct {
+	name     string
+	networks []netip.Prefix
+	index    *recordIndex
+}
+
+// WithViews enables split-horizon views. Records scoped to a view are only
+// served to clients in the view's networks.
+func WithViews(views []config.ViewConfig) Option {
+	return func(h *Handler) {
+		h.viewConfigs = views
+	}
+}
+
+// buildIndexes compiles records and builds the default index, which holds the
+// records without a view, and one index per view. Health checked records are
+// registered with health and A records get the answer policy of their name.
+func buildIndexes(records map[string][]DNSRecord, viewConfigs []config.ViewConfig, policies map[string]*AnswerPolicy, health *healthChecker) (*recordIndex, []*view, error) {
+	byView := make(map[string]map[string][]compiledRecord)
+	for _, vc := range viewConfigs {
+		byView[vc.Name] = make(map[string][]compiledRecord)
+	}
+	byView[""] = make(map[string][]compiledRecord)
+
+	for k, v := range records {
+		name := dns.CanonicalName(k)
+		for _, rec := range v {
+			c, err := compileRecord(rec)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid record: %w", err)
+			}
+			if rec.Health != nil {
+				c.health = health.target(rec.Value, *rec.Health)
+			}
+			if rec.RecordType == ARecord {
+				c.policy = policies[name]
+			}
+			names, ok := byView[rec.View]
+			if !ok {
+				return nil, nil, fmt.Errorf("record for %s uses undefined view %q", rec.Domain, rec.View)
+			}
+			names[name] = append(names[name], c)
+		}
+	}
+
+	defaultIndex := newRecordIndex()
+	for name, recs := range byView[""] {
+		defaultIndex.insert(name, recs)
+	}
+
+	views := make([]*view, 0, len(viewConfigs))
+	for _, vc := range viewConfigs {
+		v := &view{name: vc.Name, networks: vc.Networks, index: newRecordIndex()}
+		// A name defined in the view hides all of its default records
+		for name, recs := range byView[vc.Name] {
+			v.index.insert(name, recs)
+		}
+		for name, recs := range byView[""] {
+			if _, ok := byView[vc.Name][name]; !ok {
+				v.index.insert(name, recs)
+			}
+		}
+		views = append(views, v)
+	}
+
+	return defaultIndex, views, nil
+}
+
+// selectView returns the view whose network most specifically contains
+// addr, or nil when addr belongs to no view.
+func (st *handlerState) selectView(addr netip.Addr) *view {
+	if !addr.IsValid() {
+		return nil
+	}
+
+	var selected *view
+	bits := -1
+	for _, v := range st.views {
+		for _, network := range v.networks {
+			if network.Bits() > bits && network.Contains(addr) {
+				selected = v
+				bits = network.Bits()
+			}
+		}
+	}
+	return selected
+}
+
+// indexFor returns the record index serving clients at addr.
+func (st *handlerState) indexFor(addr netip.Addr) *recordIndex {
+	if v := st.selectView(addr); v != nil {
+		return v.index
+	}
+	return st.index
+}
+
+// clientAddr extracts the IP address of a DNS client.
+func clientAddr(addr net.Addr) netip.Addr {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		return netip.Addr{}
+	}
+
+	parsed, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}
+	}
+	return parsed.Unmap()
+}
+
+// protocol returns the transport a DNS client connected over.
+func protocol(addr net.Addr) string {
+	if _, ok := addr.(*net.TCPAddr); ok {
+		return "tcp"
+	}
+	return "udp"
+}
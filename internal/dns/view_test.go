@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/mguptahub/nanodns/pkg/config"
+	"github.com/miekg/dns"
+)
+
+func TestHandlerViews(t *testing.T) {
+	records := map[string][]DNSRecord{
+		"api.example.com.": {
+			{Domain: "api.example.com.", Value: "203.0.113.10", TTL: 300, RecordType: ARecord},
+			{Domain: "api.example.com.", Value: "10.0.0.10", TTL: 300, RecordType: ARecord, View: "internal"},
+			{Domain: "api.example.com.", Value: "10.1.0.10", TTL: 300, RecordType: ARecord, View: "office"},
+		},
+		"www.example.com.": {
+			{Domain: "www.example.com.", Value: "203.0.113.20", TTL: 300, RecordType: ARecord},
+		},
+	}
+
+	views := []config.ViewConfig{
+		{Name: "internal", Networks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+		{Name: "office", Networks: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16"), netip.MustParsePrefix("2001:db8::/32")}},
+	}
+
+	handler, err := NewHandler(records, config.RelayConfig{Enabled: false}, WithViews(views))
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		remote   net.Addr
+		qname    string
+		expected string
+	}{
+		{"no client address", nil, "api.example.com.", "203.0.113.10"},
+		{"public client", &net.UDPAddr{IP: net.ParseIP("198.51.100.1")}, "api.example.com.", "203.0.113.10"},
+		{"internal client", &net.UDPAddr{IP: net.ParseIP("10.2.3.4")}, "api.example.com.", "10.0.0.10"},
+		{"most specific view wins", &net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, "api.example.com.", "10.1.0.10"},
+		{"IPv6 client", &net.UDPAddr{IP: net.ParseIP("2001:db8::1")}, "api.example.com.", "10.1.0.10"},
+		{"IPv4-mapped client", &net.UDPAddr{IP: net.ParseIP("::ffff:10.2.3.4")}, "api.example.com.", "10.0.0.10"},
+		{"default records are inherited", &net.UDPAddr{IP: net.ParseIP("10.2.3.4")}, "www.example.com.", "203.0.113.20"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &mockResponseWriter{msgs: make([]*dns.Msg, 0), remote: tc.remote}
+			r := new(dns.Msg)
+			r.SetQuestion(tc.qname, dns.TypeA)
+
+			handler.ServeDNS(w, r)
+
+			msg := w.msgs[0]
+			if len(msg.Answer) != 1 {
+				t.Fatalf("Expected 1 answer, got %d", len(msg.Answer))
+			}
+			if a := msg.Answer[0].(*dns.A); a.A.String() != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, a.A.String())
+			}
+		})
+	}
+}
+
+func TestNewHandlerRejectsUndefinedView(t *testing.T) {
+	records := map[string][]DNSRecord{
+		"api.example.com.": {
+			{Domain: "api.example.com.", Value: "10.0.0.10", TTL: 300, RecordType: ARecord, View: "missing"},
+		},
+	}
+
+	if _, err := NewHandler(records, config.RelayConfig{Enabled: false}); err == nil {
+		t.Error("NewHandler() error = nil, want error for undefined view")
+	}
+}
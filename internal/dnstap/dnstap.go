@@ -0,0 +1,178 @@
+// Package dnstap encodes DNS messages as dnstap events
+// (https://dnstap.info) and writes them as Frame Streams.
+package dnstap
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// MessageType is the dnstap Message.Type of an event.
+type MessageType int
+
+// Message types emitted by NanoDNS
+const (
+	ClientQuery       MessageType = 5
+	ClientResponse    MessageType = 6
+	ForwarderQuery    MessageType = 7
+	ForwarderResponse MessageType = 8
+)
+
+// Socket families and protocols of the dnstap schema
+const (
+	familyINET  = 1
+	familyINET6 = 2
+
+	protocolUDP = 1
+	protocolTCP = 2
+)
+
+// Message is one query or response seen by the server.
+type Message struct {
+	Type            MessageType
+	Protocol        string         // "udp" or "tcp"
+	QueryAddr       netip.AddrPort // The client, or NanoDNS when forwarding
+	ResponseAddr    netip.AddrPort // NanoDNS, or the upstream when forwarding
+	QueryTime       time.Time
+	ResponseTime    time.Time
+	QueryMessage    []byte // Wire format query, set on query events
+	ResponseMessage []byte // Wire format response, set on response events
+}
+
+// AddrPort converts a net.Addr of a DNS connection to an address and port.
+func AddrPort(addr net.Addr) netip.AddrPort {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.AddrPort()
+	case *net.TCPAddr:
+		return a.AddrPort()
+	}
+	if addr != nil {
+		if ap, err := netip.ParseAddrPort(addr.String()); err == nil {
+			return ap
+		}
+	}
+	return netip.AddrPort{}
+}
+
+// Protobuf field numbers of the Dnstap and Message messages
+const (
+	dnstapIdentity = 1
+	dnstapVersion  = 2
+	dnstapMessage  = 14
+	dnstapType     = 15
+
+	messageType             = 1
+	messageSocketFamily     = 2
+	messageSocketProtocol   = 3
+	messageQueryAddress     = 4
+	messageResponseAddress  = 5
+	messageQueryPort        = 6
+	messageResponsePort     = 7
+	messageQueryTimeSec     = 8
+	messageQueryTimeNsec    = 9
+	messageQueryMessage     = 10
+	messageResponseTimeSec  = 12
+	messageResponseTimeNsec = 13
+	messageResponseMessage  = 14
+
+	// Dnstap.Type of events carrying a Message
+	dnstapTypeMessage = 1
+)
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// marshal encodes m as a dnstap.Dnstap protobuf message.
+func marshal(identity, version []byte, m *Message) []byte {
+	var msg []byte
+	msg = appendVarintField(msg, messageType, uint64(m.Type))
+
+	addr := m.QueryAddr.Addr()
+	if !addr.IsValid() {
+		addr = m.ResponseAddr.Addr()
+	}
+	if addr.IsValid() {
+		family := familyINET6
+		if addr.Unmap().Is4() {
+			family = familyINET
+		}
+		msg = appendVarintField(msg, messageSocketFamily, uint64(family))
+	}
+	protocol := protocolUDP
+	if m.Protocol == "tcp" {
+		protocol = protocolTCP
+	}
+	msg = appendVarintField(msg, messageSocketProtocol, uint64(protocol))
+
+	if m.QueryAddr.IsValid() {
+		msg = appendBytesField(msg, messageQueryAddress, addrBytes(m.QueryAddr.Addr()))
+		msg = appendVarintField(msg, messageQueryPort, uint64(m.QueryAddr.Port()))
+	}
+	if m.ResponseAddr.IsValid() {
+		msg = appendBytesField(msg, messageResponseAddress, addrBytes(m.ResponseAddr.Addr()))
+		msg = appendVarintField(msg, messageResponsePort, uint64(m.ResponseAddr.Port()))
+	}
+	if !m.QueryTime.IsZero() {
+		msg = appendVarintField(msg, messageQueryTimeSec, uint64(m.QueryTime.Unix()))
+		msg = appendFixed32Field(msg, messageQueryTimeNsec, uint32(m.QueryTime.Nanosecond()))
+	}
+	if m.QueryMessage != nil {
+		msg = appendBytesField(msg, messageQueryMessage, m.QueryMessage)
+	}
+	if !m.ResponseTime.IsZero() {
+		msg = appendVarintField(msg, messageResponseTimeSec, uint64(m.ResponseTime.Unix()))
+		msg = appendFixed32Field(msg, messageResponseTimeNsec, uint32(m.ResponseTime.Nanosecond()))
+	}
+	if m.ResponseMessage != nil {
+		msg = appendBytesField(msg, messageResponseMessage, m.ResponseMessage)
+	}
+
+	var out []byte
+	if len(identity) > 0 {
+		out = appendBytesField(out, dnstapIdentity, identity)
+	}
+	if len(version) > 0 {
+		out = appendBytesField(out, dnstapVersion, version)
+	}
+	out = appendBytesField(out, dnstapMessage, msg)
+	out = appendVarintField(out, dnstapType, dnstapTypeMessage)
+	return out
+}
+
+// addrBytes returns the 4 or 16 byte network representation of addr.
+func addrBytes(addr netip.Addr) []byte {
+	addr = addr.Unmap()
+	if addr.Is4() {
+		b := addr.As4()
+		return b[:]
+	}
+	b := addr.As16()
+	return b[:]
+}
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendTag(b, field, wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendFixed32Field(b []byte, field int, v uint32) []byte {
+	b = appendTag(b, field, wireFixed32)
+	return binary.LittleEndian.AppendUint32(b, v)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
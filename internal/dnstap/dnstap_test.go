@@ -0,0 +1,118 @@
+package dnstap
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// decode parses a protobuf message into its fields, keeping the last value of
+// repeated fields. Varint and fixed32 values are returned as uint64.
+func decode(t *testing.T, b []byte) map[int]any {
+	t.Helper()
+	fields := make(map[int]any)
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("invalid tag")
+		}
+		b = b[n:]
+		field, wire := int(tag>>3), int(tag&7)
+
+		switch wire {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatalf("invalid varint in field %d", field)
+			}
+			fields[field] = v
+			b = b[n:]
+		case wireFixed32:
+			fields[field] = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || int(l) > len(b)-n {
+				t.Fatalf("invalid length in field %d", field)
+			}
+			fields[field] = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", wire)
+		}
+	}
+	return fields
+}
+
+func TestMarshal(t *testing.T) {
+	queryTime := time.Unix(1700000000, 123456789)
+	m := &Message{
+		Type:            ClientResponse,
+		Protocol:        "tcp",
+		QueryAddr:       netip.MustParseAddrPort("192.0.2.10:5353"),
+		ResponseAddr:    netip.MustParseAddrPort("[::ffff:192.0.2.1]:53"),
+		QueryTime:       queryTime,
+		ResponseTime:    queryTime.Add(time.Millisecond),
+		ResponseMessage: []byte{0xab, 0xcd},
+	}
+
+	top := decode(t, marshal([]byte("ns1"), []byte("nanodns dev"), m))
+	if got := string(top[dnstapIdentity].([]byte)); got != "ns1" {
+		t.Errorf("identity = %q, want ns1", got)
+	}
+	if got := string(top[dnstapVersion].([]byte)); got != "nanodns dev" {
+		t.Errorf("version = %q, want nanodns dev", got)
+	}
+	if got := top[dnstapType]; got != uint64(dnstapTypeMessage) {
+		t.Errorf("type = %v, want MESSAGE", got)
+	}
+
+	msg := decode(t, top[dnstapMessage].([]byte))
+	want := map[int]uint64{
+		messageType:             uint64(ClientResponse),
+		messageSocketFamily:     familyINET,
+		messageSocketProtocol:   protocolTCP,
+		messageQueryPort:        5353,
+		messageResponsePort:     53,
+		messageQueryTimeSec:     1700000000,
+		messageQueryTimeNsec:    123456789,
+		messageResponseTimeSec:  1700000000,
+		messageResponseTimeNsec: 124456789,
+	}
+	for field, value := range want {
+		if msg[field] != value {
+			t.Errorf("message field %d = %v, want %d", field, msg[field], value)
+		}
+	}
+	if got := msg[messageQueryAddress].([]byte); netip.AddrFrom4([4]byte(got)) != netip.MustParseAddr("192.0.2.10") {
+		t.Errorf("query address = %v, want 192.0.2.10", got)
+	}
+	if got := msg[messageResponseAddress].([]byte); len(got) != 4 {
+		t.Errorf("response address = %v, want IPv4-mapped address unmapped to 4 bytes", got)
+	}
+	if _, ok := msg[messageQueryMessage]; ok {
+		t.Error("query message set on a response event")
+	}
+	if got := msg[messageResponseMessage].([]byte); string(got) != "\xab\xcd" {
+		t.Errorf("response message = %x, want abcd", got)
+	}
+}
+
+func TestMarshalIPv6(t *testing.T) {
+	m := &Message{Type: ForwarderQuery, ResponseAddr: netip.MustParseAddrPort("[2001:db8::1]:53"), QueryMessage: []byte{1}}
+
+	msg := decode(t, decode(t, marshal(nil, nil, m))[dnstapMessage].([]byte))
+	if msg[messageSocketFamily] != uint64(familyINET6) {
+		t.Errorf("socket family = %v, want INET6", msg[messageSocketFamily])
+	}
+	if msg[messageSocketProtocol] != uint64(protocolUDP) {
+		t.Errorf("socket protocol = %v, want UDP", msg[messageSocketProtocol])
+	}
+	if _, ok := msg[messageQueryAddress]; ok {
+		t.Error("query address set without one")
+	}
+	if got := msg[messageResponseAddress].([]byte); len(got) != 16 {
+		t.Errorf("response address length = %d, want 16", len(got))
+	}
+}
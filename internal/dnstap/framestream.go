@@ -0,0 +1,279 @@
+package dnstap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+)
+
+// contentType identifies dnstap payloads in Frame Streams
+const contentType = "protobuf:dnstap.Dnstap"
+
+// Frame Streams control frame types
+const (
+	controlAccept = 0x01
+	controlStart  = 0x02
+	controlStop   = 0x03
+	controlReady  = 0x04
+	controlFinish = 0x05
+
+	controlFieldContentType = 0x01
+)
+
+const (
+	// queueSize is how many events may wait to be written before new ones
+	// are dropped
+	queueSize = 10000
+
+	// reconnectInterval is how long to wait before reconnecting to a socket
+	reconnectInterval = 5 * time.Second
+
+	// handshakeTimeout bounds every control frame exchange over a socket
+	handshakeTimeout = 5 * time.Second
+)
+
+// Writer writes dnstap events in the background. Events are dropped instead
+// of slowing down queries when the output can't keep up.
+type Writer struct {
+	identity []byte
+	version  []byte
+
+	frames  chan []byte
+	done    chan struct{}
+	wg      sync.WaitGroup
+	dropped atomic.Uint64
+
+	// connect opens the output and starts the stream; socket outputs are
+	// reconnected when writes fail
+	connect   func() (io.ReadWriteCloser, error)
+	reconnect bool
+}
+
+// NewFileWriter writes a unidirectional Frame Stream to a new file at path.
+func NewFileWriter(path, identity, version string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dnstap file: %v", err)
+	}
+
+	opened := false
+	w := newWriter(identity, version, func() (io.ReadWriteCloser, error) {
+		if opened {
+			return nil, fmt.Errorf("dnstap file %s is closed", path)
+		}
+		opened = true
+		if _, err := file.Write(controlFrame(controlStart)); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return file, nil
+	}, false)
+	return w, nil
+}
+
+// NewSocketWriter writes a bidirectional Frame Stream to the Unix socket at
+// path, connecting in the background and reconnecting after failures.
+func NewSocketWriter(path, identity, version string) *Writer {
+	return newWriter(identity, version, func() (io.ReadWriteCloser, error) {
+		conn, err := net.DialTimeout("unix", path, handshakeTimeout)
+		if err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+		if _, err := conn.Write(controlFrame(controlReady)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := expectControl(conn, controlAccept); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if _, err := conn.Write(controlFrame(controlStart)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.SetDeadline(time.Time{})
+		return conn, nil
+	}, true)
+}
+
+func newWriter(identity, version string, connect func() (io.ReadWriteCloser, error), reconnect bool) *Writer {
+	w := &Writer{
+		identity:  []byte(identity),
+		version:   []byte(version),
+		frames:    make(chan []byte, queueSize),
+		done:      make(chan struct{}),
+		connect:   connect,
+		reconnect: reconnect,
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+// Log queues m to be written.
+func (w *Writer) Log(m *Message) {
+	select {
+	case w.frames <- marshal(w.identity, w.version, m):
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events dropped so far.
+func (w *Writer) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// Close writes the queued events, ends the stream and closes the output.
+func (w *Writer) Close() error {
+	close(w.done)
+	w.wg.Wait()
+	return nil
+}
+
+func (w *Writer) run() {
+	defer w.wg.Done()
+
+	var out io.ReadWriteCloser
+	var buf *bufio.Writer
+	failed := false
+	var retry <-chan time.Time
+
+	for {
+		if out == nil && retry == nil {
+			var err error
+			if out, err = w.connect(); err != nil {
+				if !failed {
+					logging.Warnf("dnstap output unavailable, dropping events: %v", err)
+					failed = true
+				}
+				if !w.reconnect {
+					<-w.done
+					return
+				}
+				retry = time.After(reconnectInterval)
+			} else {
+				if failed {
+					logging.Infof("dnstap output connected")
+					failed = false
+				}
+				buf = bufio.NewWriter(out)
+			}
+		}
+
+		select {
+		case frame := <-w.frames:
+			if out == nil {
+				w.dropped.Add(1)
+				continue
+			}
+			err := writeFrame(buf, frame)
+			if err == nil && len(w.frames) == 0 {
+				err = buf.Flush()
+			}
+			if err != nil {
+				logging.Warnf("dnstap write failed: %v", err)
+				failed = true
+				out.Close()
+				out = nil
+				if w.reconnect {
+					retry = time.After(reconnectInterval)
+				}
+			}
+
+		case <-retry:
+			retry = nil
+
+		case <-w.done:
+			if out != nil {
+				w.finish(out, buf)
+			}
+			return
+		}
+	}
+}
+
+// finish writes the queued frames and stops the stream.
+func (w *Writer) finish(out io.ReadWriteCloser, buf *bufio.Writer) {
+	defer out.Close()
+	for len(w.frames) > 0 {
+		if writeFrame(buf, <-w.frames) != nil {
+			return
+		}
+	}
+
+	buf.Write(controlFrame(controlStop))
+	if buf.Flush() != nil {
+		return
+	}
+	if conn, ok := out.(net.Conn); ok {
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+		expectControl(conn, controlFinish)
+	}
+}
+
+// writeFrame writes a data frame: its length followed by the payload.
+func writeFrame(w io.Writer, frame []byte) error {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(frame)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+// controlFrame builds a control frame. All frames but STOP and FINISH carry
+// the dnstap content type.
+func controlFrame(controlType uint32) []byte {
+	payload := binary.BigEndian.AppendUint32(nil, controlType)
+	if controlType != controlStop && controlType != controlFinish {
+		payload = binary.BigEndian.AppendUint32(payload, controlFieldContentType)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(contentType)))
+		payload = append(payload, contentType...)
+	}
+
+	frame := binary.BigEndian.AppendUint32(nil, 0) // escape: a zero length data frame
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+// readControl reads a control frame and returns its type.
+func readControl(r io.Reader) (uint32, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+	if escape := binary.BigEndian.Uint32(header[:4]); escape != 0 {
+		return 0, fmt.Errorf("expected a control frame")
+	}
+	length := binary.BigEndian.Uint32(header[4:])
+	if length < 4 || length > 512 {
+		return 0, fmt.Errorf("invalid control frame length %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(payload[:4]), nil
+}
+
+func expectControl(r io.Reader, want uint32) error {
+	got, err := readControl(r)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("unexpected control frame type %d, want %d", got, want)
+	}
+	return nil
+}
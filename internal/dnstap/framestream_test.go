@@ -0,0 +1,134 @@
+package dnstap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readFrame reads a data frame, or returns nil and the control type of a
+// control frame.
+func readFrame(t *testing.T, r io.Reader) ([]byte, uint32) {
+	t.Helper()
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	if n := binary.BigEndian.Uint32(length[:]); n > 0 {
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			t.Fatalf("reading frame: %v", err)
+		}
+		return frame, 0
+	}
+
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		t.Fatalf("reading control frame: %v", err)
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(length[:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("reading control frame: %v", err)
+	}
+	if len(payload) > 4 && !bytes.Contains(payload, []byte(contentType)) {
+		t.Errorf("control frame %x lacks the dnstap content type", payload)
+	}
+	return nil, binary.BigEndian.Uint32(payload[:4])
+}
+
+func TestFileWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nanodns.dnstap")
+	w, err := NewFileWriter(path, "ns1", "nanodns dev")
+	if err != nil {
+		t.Fatalf("NewFileWriter() error = %v", err)
+	}
+	w.Log(&Message{Type: ClientQuery, QueryMessage: []byte{1, 2, 3}})
+	w.Log(&Message{Type: ClientResponse, ResponseMessage: []byte{4, 5, 6}})
+	w.Close()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(content)
+
+	if _, control := readFrame(t, r); control != controlStart {
+		t.Fatalf("first frame = control %d, want START", control)
+	}
+	for _, want := range []MessageType{ClientQuery, ClientResponse} {
+		frame, _ := readFrame(t, r)
+		msg := decode(t, decode(t, frame)[dnstapMessage].([]byte))
+		if msg[messageType] != uint64(want) {
+			t.Errorf("event type = %v, want %d", msg[messageType], want)
+		}
+	}
+	if _, control := readFrame(t, r); control != controlStop {
+		t.Fatalf("last frame = control %d, want STOP", control)
+	}
+	if r.Len() != 0 {
+		t.Errorf("%d trailing bytes after STOP", r.Len())
+	}
+}
+
+func TestSocketWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnstap.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	events := make(chan []byte, 10)
+	finished := make(chan uint32, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, control := readFrame(t, conn); control != controlReady {
+			t.Errorf("handshake frame = control %d, want READY", control)
+			return
+		}
+		conn.Write(controlFrame(controlAccept))
+		if _, control := readFrame(t, conn); control != controlStart {
+			t.Errorf("frame after ACCEPT = control %d, want START", control)
+			return
+		}
+		for {
+			frame, control := readFrame(t, conn)
+			if frame == nil {
+				conn.Write(controlFrame(controlFinish))
+				finished <- control
+				return
+			}
+			events <- frame
+		}
+	}()
+
+	w := NewSocketWriter(path, "ns1", "nanodns dev")
+	w.Log(&Message{Type: ForwarderQuery, QueryMessage: []byte{1}})
+
+	select {
+	case frame := <-events:
+		msg := decode(t, decode(t, frame)[dnstapMessage].([]byte))
+		if msg[messageType] != uint64(ForwarderQuery) {
+			t.Errorf("event type = %v, want FORWARDER_QUERY", msg[messageType])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+
+	w.Close()
+	if control := <-finished; control != controlStop {
+		t.Errorf("closing frame = control %d, want STOP", control)
+	}
+	if w.Dropped() != 0 {
+		t.Errorf("Dropped() = %d, want 0", w.Dropped())
+	}
+}
@@ -0,0 +1,78 @@
+// internal/logging/journald.go
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultJournaldSocket is the native protocol socket of systemd-journald
+const DefaultJournaldSocket = "/run/systemd/journal/socket"
+
+// journaldSink sends entries to journald with their fields as journal fields.
+type journaldSink struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newJournaldSink(path string) (*journaldSink, error) {
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		return nil, err
+	}
+	return &journaldSink{conn: conn}, nil
+}
+
+// encode builds the native protocol datagram for e.
+func (j *journaldSink) encode(e Entry) []byte {
+	var buf bytes.Buffer
+	field := func(key, value string) {
+		if !strings.Contains(value, "\n") {
+			buf.WriteString(key + "=" + value + "\n")
+			return
+		}
+		// Values with newlines are sent as a little-endian length and raw bytes
+		buf.WriteString(key + "\n")
+		binary.Write(&buf, binary.LittleEndian, uint64(len(value)))
+		buf.WriteString(value + "\n")
+	}
+
+	field("MESSAGE", e.Message)
+	field("PRIORITY", strconv.Itoa(severity(e.Level)))
+	field("SYSLOG_IDENTIFIER", appName)
+	field("NANODNS_STREAM", e.Stream)
+	for _, f := range e.Fields {
+		field(journalKey(f.Key), f.Value)
+	}
+	return buf.Bytes()
+}
+
+// journalKey converts a field key to a valid journal field name.
+func journalKey(key string) string {
+	return "NANODNS_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
+
+func (j *journaldSink) Write(e Entry) error {
+	data := j.encode(e)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err := j.conn.Write(data)
+	return err
+}
+
+func (j *journaldSink) Close() error {
+	return j.conn.Close()
+}
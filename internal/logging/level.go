@@ -0,0 +1,122 @@
+// internal/logging/level.go
+package logging
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a log message.
+type Level int32
+
+// Log levels, from most to least verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+
+	DefaultLevel = LevelInfo
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name such as "debug" or "WARN".
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "warning" {
+		return LevelWarn, nil
+	}
+	for i, levelName := range levelNames {
+		if name == levelName {
+			return Level(i), nil
+		}
+	}
+	return DefaultLevel, fmt.Errorf("unknown log level %q", name)
+}
+
+var (
+	level atomic.Int32
+
+	// stderrLogger mirrors messages to stderr unless stderr is the service
+	// log file itself, as it is for the daemon
+	stderrLogger = log.New(os.Stderr, "", log.Ldate|log.Ltime|log.Lmicroseconds)
+)
+
+func init() {
+	level.Store(int32(DefaultLevel))
+}
+
+// SetLevel changes the minimum level of logged messages.
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// GetLevel returns the minimum level of logged messages.
+func GetLevel() Level {
+	return Level(level.Load())
+}
+
+// Enabled reports whether messages at l are logged.
+func Enabled(l Level) bool {
+	return l >= GetLevel()
+}
+
+// Debugf logs per-query details.
+func Debugf(format string, args ...any) { logf(LevelDebug, format, args...) }
+
+// Infof logs normal service operations.
+func Infof(format string, args ...any) { logf(LevelInfo, format, args...) }
+
+// Warnf logs problems the service works around, such as invalid settings.
+func Warnf(format string, args ...any) { logf(LevelWarn, format, args...) }
+
+// Errorf logs failures.
+func Errorf(format string, args ...any) { logf(LevelError, format, args...) }
+
+// Fatalf logs a failure and exits.
+func Fatalf(format string, args ...any) {
+	logf(LevelError, format, args...)
+	os.Exit(1)
+}
+
+func logf(l Level, format string, args ...any) {
+	if !Enabled(l) {
+		return
+	}
+	output(l, fmt.Sprintf(format, args...))
+}
+
+func output(l Level, message string) {
+	line := fmt.Sprintf("[%s] %s", strings.ToUpper(l.String()), message)
+	if serviceLogger != nil {
+		serviceLogger.Print(line)
+	}
+	if stderrLogger != nil {
+		stderrLogger.Print(line)
+	}
+	if hasSinks() {
+		writeSinks(Entry{Time: time.Now(), Stream: StreamService, Level: l, Message: message})
+	}
+}
+
+// mirrorToStderr decides whether messages are copied to stderr, which is
+// pointless when stderr is the service log file.
+func mirrorToStderr(serviceLog *os.File) {
+	svcInfo, err1 := serviceLog.Stat()
+	errInfo, err2 := os.Stderr.Stat()
+	if err1 == nil && err2 == nil && os.SameFile(svcInfo, errInfo) {
+		stderrLogger = nil
+	}
+}
@@ -0,0 +1,48 @@
+package logging
+
+import "testing"
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Level
+		wantErr bool
+	}{
+		{"debug", LevelDebug, false},
+		{"INFO", LevelInfo, false},
+		{" warn ", LevelWarn, false},
+		{"warning", LevelWarn, false},
+		{"error", LevelError, false},
+		{"verbose", DefaultLevel, true},
+		{"", DefaultLevel, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevel(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	defer SetLevel(GetLevel())
+
+	SetLevel(LevelWarn)
+	if Enabled(LevelInfo) {
+		t.Error("Enabled(info) = true at warn level")
+	}
+	if !Enabled(LevelError) {
+		t.Error("Enabled(error) = false at warn level")
+	}
+
+	SetLevel(LevelDebug)
+	if !Enabled(LevelDebug) {
+		t.Error("Enabled(debug) = false at debug level")
+	}
+}
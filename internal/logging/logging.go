@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -28,6 +29,18 @@ type Config struct {
 	ActionLogFile  string
 	MaxLogSize     int64
 	MaxLogBackups  int
+	MaxLogAge      time.Duration // Rotate logs whose first line is older, 0 to disable
+
+	LogLevel string
+
+	Outputs        string // Comma-separated list of OutputFile, OutputSyslog and OutputJournald
+	SyslogSocket   string
+	SyslogFacility string
+	JournaldSocket string
+
+	QueryLogEnabled bool
+	QueryLogFile    string
+	QueryLogFormat  string
 }
 
 var (
@@ -47,7 +60,43 @@ func loadConfig() Config {
 		ActionLogFile:  getEnv("ACTION_LOG", DefaultActionLog),
 		MaxLogSize:     getEnvInt64("MAX_LOG_SIZE", DefaultMaxLogSize),
 		MaxLogBackups:  getEnvInt("MAX_LOG_BACKUPS", DefaultMaxLogBackups),
+		MaxLogAge:      getEnvDuration("MAX_LOG_AGE", 0),
+		LogLevel:       getEnv("LOG_LEVEL", DefaultLevel.String()),
+
+		Outputs:        getEnv("LOG_OUTPUTS", DefaultOutputs),
+		SyslogSocket:   getEnv("SYSLOG_SOCKET", DefaultSyslogSocket),
+		SyslogFacility: getEnv("SYSLOG_FACILITY", DefaultSyslogFacility),
+		JournaldSocket: getEnv("JOURNALD_SOCKET", DefaultJournaldSocket),
+
+		QueryLogEnabled: getEnvBool("QUERY_LOG_ENABLED", false),
+		QueryLogFile:    getEnv("QUERY_LOG", DefaultQueryLog),
+		QueryLogFormat:  getQueryLogFormat(),
+	}
+}
+
+// getQueryLogFormat returns QUERY_LOG_FORMAT, falling back to JSON for
+// unknown formats
+func getQueryLogFormat() string {
+	switch format := strings.ToLower(getEnv("QUERY_LOG_FORMAT", DefaultQueryLogFormat)); format {
+	case QueryLogJSON, QueryLogLogfmt:
+		return format
+	default:
+		return DefaultQueryLogFormat
+	}
+}
+
+// Helper function to get boolean environment variables
+func getEnvBool(key string, fallback bool) bool {
+	strValue, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	boolValue, err := strconv.ParseBool(strValue)
+	if err != nil {
+		return fallback
 	}
+	return boolValue
 }
 
 // Helper function to get environment variables with default fallback
@@ -72,6 +121,20 @@ func getEnvInt(key string, fallback int) int {
 	return intValue
 }
 
+// Helper function to get duration environment variables
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	strValue, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	durationValue, err := time.ParseDuration(strValue)
+	if err != nil {
+		return fallback
+	}
+	return durationValue
+}
+
 // Helper function to get int64 environment variables
 func getEnvInt64(key string, fallback int64) int64 {
 	strValue, exists := os.LookupEnv(key)
@@ -91,35 +154,63 @@ func Init() error {
 	// Load configuration from environment
 	config = loadConfig()
 
-	// Create log directory if it doesn't exist
-	if err := os.MkdirAll(config.LogDir, 0755); err != nil {
-		return fmt.Errorf("failed to create log directory: %v", err)
+	// Connect syslog and journald, falling back to the log files
+	outputs, outputErr := parseOutputs(config.Outputs)
+	if outputErr == nil {
+		outputErr = openSinks(outputs)
 	}
-
-	// Initialize service logger
-	svcFile, err := GetServiceLogFile()
-	if err != nil {
-		return fmt.Errorf("failed to initialize service log: %v", err)
+	if outputErr != nil {
+		_ = openSinks([]string{OutputFile})
 	}
-	if err != nil {
-		return fmt.Errorf("failed to open service log file: %v", err)
+
+	if fileOutput {
+		// Create log directory if it doesn't exist
+		if err := os.MkdirAll(config.LogDir, 0755); err != nil {
+			return fmt.Errorf("failed to create log directory: %v", err)
+		}
+
+		// Initialize service logger
+		svcFile, err := GetServiceLogFile()
+		if err != nil {
+			return fmt.Errorf("failed to initialize service log: %v", err)
+		}
+
+		// Initialize action logger
+		actionLog, err := os.OpenFile(
+			filepath.Join(config.LogDir, config.ActionLogFile),
+			os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+			0644,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to open action log file: %v", err)
+		}
+
+		actionFile = actionLog
+
+		// Initialize the optional query log
+		if err := openQueryLog(); err != nil {
+			return err
+		}
+
+		// Create loggers with timestamps and prefixes
+		serviceLogger = log.New(svcFile, "", log.Ldate|log.Ltime|log.Lmicroseconds)
+		actionLogger = log.New(actionLog, "", log.Ldate|log.Ltime|log.Lmicroseconds)
+		mirrorToStderr(svcFile)
+	} else {
+		// syslog and journald get every message, and a daemon's stderr
+		// goes nowhere
+		stderrLogger = nil
 	}
 
-	// Initialize action logger
-	actionLog, err := os.OpenFile(
-		filepath.Join(config.LogDir, config.ActionLogFile),
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
-		0644,
-	)
+	logLevel, err := ParseLevel(config.LogLevel)
 	if err != nil {
-		return fmt.Errorf("failed to open action log file: %v", err)
+		Warnf("Invalid LOG_LEVEL %q, using %s", config.LogLevel, DefaultLevel)
 	}
+	SetLevel(logLevel)
 
-	actionFile = actionLog
-
-	// Create loggers with timestamps and prefixes
-	serviceLogger = log.New(svcFile, "", log.Ldate|log.Ltime|log.Lmicroseconds)
-	actionLogger = log.New(actionLog, "", log.Ldate|log.Ltime|log.Lmicroseconds)
+	if outputErr != nil {
+		Warnf("Invalid LOG_OUTPUTS %q, logging to files: %v", config.Outputs, outputErr)
+	}
 
 	return nil
 }
@@ -130,78 +221,50 @@ func GetLogPaths() (service, action string) {
 		filepath.Join(config.LogDir, config.ActionLogFile)
 }
 
-// LogAction logs administrative actions
-func LogAction(action, details string) {
-	if actionLogger != nil {
-		actionLogger.Printf("%s - %s", action, details)
-	}
-}
-
-// LogService logs DNS service operations
-func LogService(message string) {
-	if serviceLogger != nil {
-		serviceLogger.Print(message)
+// Chown hands the log directory and the files in it to uid and gid, so a
+// server that drops its privileges can still rotate its logs.
+func Chown(uid, gid int) error {
+	if !FileOutput() {
+		return nil
 	}
-}
-
-// GetActionLogs retrieves action logs with optional filtering
-func GetActionLogs(since time.Duration) ([]string, error) {
-	return readLogFile(filepath.Join(config.LogDir, config.ActionLogFile), since)
-}
-
-// GetServiceLogs retrieves service logs with optional filtering
-func GetServiceLogs(since time.Duration) ([]string, error) {
-	return readLogFile(filepath.Join(config.LogDir, config.ServiceLogFile), since)
-}
-
-// Helper function to read and filter log files
-func readLogFile(filepath string, since time.Duration) ([]string, error) {
-	content, err := os.ReadFile(filepath)
+	entries, err := os.ReadDir(config.LogDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read log file: %v", err)
+		return err
 	}
-
-	// TODO: Implement log filtering based on the 'since' parameter
-	return []string{string(content)}, nil
-}
-
-// RotateLogs checks log sizes and rotates if necessary
-func RotateLogs() error {
-	files := []string{config.ServiceLogFile, config.ActionLogFile}
-
-	for _, file := range files {
-		path := filepath.Join(config.LogDir, file)
-
-		info, err := os.Stat(path)
-		if err != nil {
-			continue // Skip if file doesn't exist
-		}
-
-		if info.Size() > config.MaxLogSize {
-			if err := rotateLogFile(path); err != nil {
-				return fmt.Errorf("failed to rotate %s: %v", file, err)
+	if err := os.Chown(config.LogDir, uid, gid); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			if err := os.Chown(filepath.Join(config.LogDir, entry.Name()), uid, gid); err != nil {
+				return err
 			}
 		}
 	}
-
 	return nil
 }
 
-// Helper function to rotate a single log file
-func rotateLogFile(filepath string) error {
-	// Remove the oldest backup if it exists
-	oldestBackup := fmt.Sprintf("%s.%d", filepath, config.MaxLogBackups)
-	_ = os.Remove(oldestBackup)
-
-	// Shift existing backups
-	for i := config.MaxLogBackups - 1; i > 0; i-- {
-		oldPath := fmt.Sprintf("%s.%d", filepath, i)
-		newPath := fmt.Sprintf("%s.%d", filepath, i+1)
-		_ = os.Rename(oldPath, newPath)
+// LogAction logs administrative actions
+func LogAction(action, details string) {
+	if actionLogger != nil {
+		actionLogger.Printf("%s - %s", action, details)
+	}
+	if hasSinks() {
+		writeSinks(Entry{
+			Time:    time.Now(),
+			Stream:  StreamAction,
+			Level:   LevelInfo,
+			Message: action + " - " + details,
+			Fields:  []Field{{"action", action}},
+		})
 	}
+}
 
-	// Rename current log to .1
-	return os.Rename(filepath, filepath+".1")
+// LogService logs DNS service operations at info level
+func LogService(message string) {
+	if Enabled(LevelInfo) {
+		output(LevelInfo, message)
+	}
 }
 
 func GetServiceLogFile() (*os.File, error) {
@@ -262,4 +325,6 @@ func Cleanup() {
 		}
 		actionFile = nil
 	}
+	closeQueryLog()
+	closeSinks()
 }
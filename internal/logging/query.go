@@ -0,0 +1,158 @@
+// internal/logging/query.go
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Query log formats
+const (
+	QueryLogJSON   = "json"
+	QueryLogLogfmt = "logfmt"
+
+	DefaultQueryLog       = "queries.log"
+	DefaultQueryLogFormat = QueryLogJSON
+)
+
+// QueryEvent describes one answered DNS query.
+type QueryEvent struct {
+	Time     time.Time     `json:"time"`
+	Client   string        `json:"client"`
+	Protocol string        `json:"proto"`
+	Name     string        `json:"qname"`
+	Type     string        `json:"qtype"`
+	Rcode    string        `json:"rcode"`
+	Source   string        `json:"source"`
+	Answers  int           `json:"answers"`
+	Latency  time.Duration `json:"-"`
+	Upstream string        `json:"upstream,omitempty"` // Relay server that answered
+}
+
+var (
+	queryFile  *os.File
+	queryMutex sync.Mutex
+)
+
+// openQueryLog opens the query log if it is enabled.
+func openQueryLog() error {
+	if !config.QueryLogEnabled {
+		return nil
+	}
+
+	file, err := os.OpenFile(
+		filepath.Join(config.LogDir, config.QueryLogFile),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0644,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to open query log file: %v", err)
+	}
+
+	queryMutex.Lock()
+	queryFile = file
+	queryMutex.Unlock()
+	return nil
+}
+
+// QueryLogEnabled reports whether LogQuery writes anything, so callers can
+// skip building events nobody reads.
+func QueryLogEnabled() bool {
+	if !config.QueryLogEnabled {
+		return false
+	}
+	queryMutex.Lock()
+	defer queryMutex.Unlock()
+	return queryFile != nil || hasSinks()
+}
+
+// LogQuery writes one line describing e to the query log.
+func LogQuery(e QueryEvent) {
+	if hasSinks() {
+		writeSinks(Entry{
+			Time:    e.Time,
+			Stream:  StreamQuery,
+			Level:   LevelInfo,
+			Message: string(e.logfmt()),
+			Fields:  e.fields(),
+		})
+	}
+
+	queryMutex.Lock()
+	defer queryMutex.Unlock()
+	if queryFile == nil {
+		return
+	}
+
+	var line []byte
+	if config.QueryLogFormat == QueryLogLogfmt {
+		line = e.logfmt()
+	} else {
+		line = e.json()
+	}
+	_, _ = queryFile.Write(append(line, '\n'))
+}
+
+func (e QueryEvent) json() []byte {
+	type event QueryEvent
+	line, _ := json.Marshal(struct {
+		event
+		LatencyMS float64 `json:"latency_ms"`
+	}{event(e), latencyMS(e.Latency)})
+	return line
+}
+
+// fields returns the event's fields in log order.
+func (e QueryEvent) fields() []Field {
+	fields := []Field{
+		{"time", e.Time.Format(time.RFC3339Nano)},
+		{"client", e.Client},
+		{"proto", e.Protocol},
+		{"qname", e.Name},
+		{"qtype", e.Type},
+		{"rcode", e.Rcode},
+		{"source", e.Source},
+		{"answers", strconv.Itoa(e.Answers)},
+		{"latency_ms", strconv.FormatFloat(latencyMS(e.Latency), 'f', -1, 64)},
+	}
+	if e.Upstream != "" {
+		fields = append(fields, Field{"upstream", e.Upstream})
+	}
+	return fields
+}
+
+func (e QueryEvent) logfmt() []byte {
+	var sb strings.Builder
+	for _, f := range e.fields() {
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		value := f.Value
+		if value == "" || strings.ContainsAny(value, " =\"\\") {
+			value = strconv.Quote(value)
+		}
+		sb.WriteString(f.Key + "=" + value)
+	}
+	return []byte(sb.String())
+}
+
+func latencyMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func closeQueryLog() {
+	queryMutex.Lock()
+	defer queryMutex.Unlock()
+	if queryFile != nil {
+		if err := queryFile.Close(); err != nil {
+			fmt.Printf("Error closing query log file: %v\n", err)
+		}
+		queryFile = nil
+	}
+}
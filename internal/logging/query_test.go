@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEvent = QueryEvent{
+	Time:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	Client:   "192.0.2.10",
+	Protocol: "udp",
+	Name:     "example.com.",
+	Type:     "A",
+	Rcode:    "NOERROR",
+	Source:   "relay",
+	Answers:  2,
+	Latency:  1500 * time.Microsecond,
+	Upstream: "8.8.8.8:53",
+}
+
+func TestQueryEventJSON(t *testing.T) {
+	var got map[string]any
+	if err := json.Unmarshal(testEvent.json(), &got); err != nil {
+		t.Fatalf("json() produced invalid JSON: %v", err)
+	}
+
+	want := map[string]any{
+		"time":       "2024-05-01T12:00:00Z",
+		"client":     "192.0.2.10",
+		"proto":      "udp",
+		"qname":      "example.com.",
+		"qtype":      "A",
+		"rcode":      "NOERROR",
+		"source":     "relay",
+		"answers":    float64(2),
+		"latency_ms": 1.5,
+		"upstream":   "8.8.8.8:53",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("json()[%q] = %v, want %v", key, got[key], value)
+		}
+	}
+}
+
+func TestQueryEventLogfmt(t *testing.T) {
+	want := `time=2024-05-01T12:00:00Z client=192.0.2.10 proto=udp qname=example.com. qtype=A ` +
+		`rcode=NOERROR source=relay answers=2 latency_ms=1.5 upstream=8.8.8.8:53`
+	if got := string(testEvent.logfmt()); got != want {
+		t.Errorf("logfmt() = %q, want %q", got, want)
+	}
+
+	local := testEvent
+	local.Upstream = ""
+	local.Client = ""
+	got := string(local.logfmt())
+	if strings.Contains(got, "upstream=") {
+		t.Errorf("logfmt() = %q, want no upstream for local answers", got)
+	}
+	if !strings.Contains(got, `client=""`) {
+		t.Errorf("logfmt() = %q, want empty client quoted", got)
+	}
+}
+
+func TestLogQuery(t *testing.T) {
+	dir := t.TempDir()
+	oldConfig := config
+	defer func() {
+		closeQueryLog()
+		config = oldConfig
+	}()
+
+	config = Config{LogDir: dir, QueryLogEnabled: true, QueryLogFile: "queries.log", QueryLogFormat: QueryLogLogfmt}
+	if err := openQueryLog(); err != nil {
+		t.Fatalf("openQueryLog() error = %v", err)
+	}
+	if !QueryLogEnabled() {
+		t.Fatal("QueryLogEnabled() = false after opening the query log")
+	}
+
+	LogQuery(testEvent)
+	LogQuery(testEvent)
+
+	content, err := os.ReadFile(filepath.Join(dir, "queries.log"))
+	if err != nil {
+		t.Fatalf("reading query log: %v", err)
+	}
+	if lines := strings.Count(string(content), "\n"); lines != 2 {
+		t.Errorf("query log has %d lines, want 2", lines)
+	}
+}
@@ -0,0 +1,277 @@
+// internal/logging/reader.go
+package logging
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// logTimeLayout is the timestamp prefix written by the service and action loggers
+const logTimeLayout = "2006/01/02 15:04:05.000000"
+
+// followInterval is how often a followed log is checked for new lines
+var followInterval = 500 * time.Millisecond
+
+// LogFilter selects the log lines to show.
+type LogFilter struct {
+	Since   time.Duration  // Only lines newer than this, 0 for all of them
+	Pattern *regexp.Regexp // Only lines matching the pattern
+	Domain  string         // Only lines mentioning the domain or its subdomains
+	Lines   int            // Only the last Lines lines, 0 for all of them
+}
+
+// LogFile identifies one of the logs.
+type LogFile int
+
+// Logs that can be read back
+const (
+	ServiceLog LogFile = iota
+	ActionLog
+	QueryLog
+)
+
+// LogPath returns the path of a log.
+func LogPath(log LogFile) string {
+	switch log {
+	case ActionLog:
+		return filepath.Join(config.LogDir, config.ActionLogFile)
+	case QueryLog:
+		return filepath.Join(config.LogDir, config.QueryLogFile)
+	default:
+		return filepath.Join(config.LogDir, config.ServiceLogFile)
+	}
+}
+
+// matcher applies a LogFilter to single lines.
+type matcher struct {
+	filter LogFilter
+	cutoff time.Time
+	domain *regexp.Regexp
+	last   time.Time // timestamp of the last line, inherited by continuation lines
+}
+
+func newMatcher(filter LogFilter) *matcher {
+	m := &matcher{filter: filter}
+	if filter.Since > 0 {
+		m.cutoff = time.Now().Add(-filter.Since)
+	}
+	if domain := strings.TrimSuffix(strings.TrimSpace(filter.Domain), "."); domain != "" {
+		m.domain = regexp.MustCompile(`(?i)(^|[^a-z0-9-])` + regexp.QuoteMeta(domain) + `\.?([^a-z0-9.-]|$)`)
+	}
+	return m
+}
+
+func (m *matcher) match(line string) bool {
+	if ts, ok := lineTime(line); ok {
+		m.last = ts
+	}
+	if !m.cutoff.IsZero() && m.last.Before(m.cutoff) {
+		return false
+	}
+	if m.filter.Pattern != nil && !m.filter.Pattern.MatchString(line) {
+		return false
+	}
+	if m.domain != nil && !m.domain.MatchString(line) {
+		return false
+	}
+	return true
+}
+
+// lineTime parses the timestamp of a service, action or query log line.
+func lineTime(line string) (time.Time, bool) {
+	var value, layout string
+	switch {
+	case strings.HasPrefix(line, `{"time":"`):
+		value, _, _ = strings.Cut(line[len(`{"time":"`):], `"`)
+		layout = time.RFC3339Nano
+	case strings.HasPrefix(line, "time="):
+		value, _, _ = strings.Cut(line[len("time="):], " ")
+		layout = time.RFC3339Nano
+	case len(line) >= len(logTimeLayout):
+		value = line[:len(logTimeLayout)]
+		layout = logTimeLayout
+	default:
+		return time.Time{}, false
+	}
+
+	var ts time.Time
+	var err error
+	if layout == logTimeLayout {
+		ts, err = time.ParseInLocation(layout, value, time.Local)
+	} else {
+		ts, err = time.Parse(layout, value)
+	}
+	return ts, err == nil
+}
+
+// backupPaths returns the rotated backups of path, compressed or not,
+// oldest first.
+func backupPaths(path string) []string {
+	var paths []string
+	for i := config.MaxLogBackups; i > 0; i-- {
+		for _, backup := range []string{backupPath(path, i) + ".gz", backupPath(path, i)} {
+			if _, err := os.Stat(backup); err == nil {
+				paths = append(paths, backup)
+			}
+		}
+	}
+	return paths
+}
+
+// ReadLogs returns the lines of a log and its rotated backups that pass
+// filter, oldest first.
+func ReadLogs(log LogFile, filter LogFilter) ([]string, error) {
+	path := LogPath(log)
+	m := newMatcher(filter)
+
+	var lines []string
+	for _, p := range append(backupPaths(path), path) {
+		if err := readLines(p, m, &lines, filter.Lines); err != nil {
+			if p != path || !os.IsNotExist(err) {
+				return nil, fmt.Errorf("failed to read log file: %v", err)
+			}
+		}
+	}
+	return lines, nil
+}
+
+// readLines appends the lines of path that pass m to lines, keeping only the
+// last limit lines if limit is positive.
+func readLines(path string, m *matcher, lines *[]string, limit int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !m.match(line) {
+			continue
+		}
+		*lines = append(*lines, line)
+		if limit > 0 && len(*lines) > 2*limit {
+			*lines = append((*lines)[:0], (*lines)[len(*lines)-limit:]...)
+		}
+	}
+	if limit > 0 && len(*lines) > limit {
+		*lines = (*lines)[len(*lines)-limit:]
+	}
+	return scanner.Err()
+}
+
+// FollowLogs writes the lines passing filter that are appended to a log to
+// out until ctx is done, picking up the new file when the log is rotated.
+func FollowLogs(ctx context.Context, log LogFile, filter LogFilter, out func(string)) error {
+	path := LogPath(log)
+	filter.Since = 0
+	m := newMatcher(filter)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer func() { file.Close() }()
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	var partial string
+	drain := func() {
+		for {
+			chunk, err := reader.ReadString('\n')
+			partial += chunk
+			if err != nil {
+				return
+			}
+			if line := strings.TrimRight(partial, "\r\n"); m.match(line) {
+				out(line)
+			}
+			partial = ""
+		}
+	}
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+
+	for {
+		drain()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// Start over from the top of a log truncated in place (copytruncate)
+		if truncated(file) {
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			reader.Reset(file)
+			partial = ""
+			continue
+		}
+
+		// Finish the old file and reopen the log once it has been rotated away
+		if rotated(file, path) {
+			next, err := os.Open(path)
+			if err != nil {
+				continue // Not recreated yet
+			}
+			drain()
+			file.Close()
+			file = next
+			reader.Reset(file)
+			partial = ""
+		}
+	}
+}
+
+// rotated reports whether path no longer refers to the open file.
+func rotated(file *os.File, path string) bool {
+	openInfo, err := file.Stat()
+	if err != nil {
+		return true
+	}
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return !os.SameFile(openInfo, pathInfo)
+}
+
+// truncated reports whether the open file has shrunk below the offset read up
+// to.
+func truncated(file *os.File) bool {
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Size() < offset
+}
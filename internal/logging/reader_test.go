@@ -0,0 +1,243 @@
+package logging
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLineTime(t *testing.T) {
+	want := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		line string
+		ok   bool
+	}{
+		{"service log", want.Local().Format(logTimeLayout) + " [INFO] Starting", true},
+		{"json query log", `{"time":"2024-05-01T12:00:00Z","client":"192.0.2.1"}`, true},
+		{"logfmt query log", "time=2024-05-01T12:00:00Z client=192.0.2.1", true},
+		{"continuation", "goroutine 1 [running]:", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := lineTime(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("lineTime() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !got.Equal(want) {
+				t.Errorf("lineTime() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMatcherDomain(t *testing.T) {
+	m := newMatcher(LogFilter{Domain: "Example.com."})
+
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"Query for example.com. (type: A)", true},
+		{"Query for api.example.com. (type: A)", true},
+		{`{"qname":"EXAMPLE.COM."}`, true},
+		{"Query for myexample.com. (type: A)", false},
+		{"Query for example.com.evil. (type: A)", false},
+		{"Query for other.org. (type: A)", false},
+	}
+
+	for _, tt := range tests {
+		if got := m.match(tt.line); got != tt.want {
+			t.Errorf("match(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+// useTestLogDir points the logging configuration at a temporary directory.
+func useTestLogDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	oldConfig := config
+	t.Cleanup(func() { config = oldConfig })
+	config = Config{LogDir: dir, ServiceLogFile: "service.log", ActionLogFile: "actions.log", MaxLogBackups: 3}
+	return dir
+}
+
+func TestReadLogs(t *testing.T) {
+	dir := useTestLogDir(t)
+
+	now := time.Now()
+	stamp := func(age time.Duration) string { return now.Add(-age).Format(logTimeLayout) }
+	files := map[string]string{
+		"service.log.2": stamp(72*time.Hour) + " [INFO] oldest\n",
+		"service.log.1": stamp(2*time.Hour) + " [INFO] older example.com\n",
+		"service.log": stamp(time.Minute) + " [WARN] recent example.com\n" +
+			"continuation of recent\n" +
+			stamp(0) + " [INFO] newest\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter LogFilter
+		want   []string
+	}{
+		{"all", LogFilter{}, []string{"oldest", "older example.com", "recent example.com", "continuation of recent", "newest"}},
+		{"since", LogFilter{Since: 3 * time.Hour}, []string{"older example.com", "recent example.com", "continuation of recent", "newest"}},
+		{"lines", LogFilter{Lines: 2}, []string{"continuation of recent", "newest"}},
+		{"grep", LogFilter{Pattern: regexp.MustCompile(`\[WARN\]`)}, []string{"recent example.com"}},
+		{"domain and lines", LogFilter{Domain: "example.com", Lines: 1}, []string{"recent example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := ReadLogs(ServiceLog, tt.filter)
+			if err != nil {
+				t.Fatalf("ReadLogs() error = %v", err)
+			}
+			var got []string
+			for _, line := range lines {
+				if _, ok := lineTime(line); ok {
+					line = strings.SplitN(line, "] ", 2)[1]
+				}
+				got = append(got, line)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadLogs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFollowLogs(t *testing.T) {
+	dir := useTestLogDir(t)
+	defer func(interval time.Duration) { followInterval = interval }(followInterval)
+	followInterval = 10 * time.Millisecond
+
+	path := filepath.Join(dir, "service.log")
+	if err := os.WriteFile(path, []byte("before follow\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var got []string
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- FollowLogs(ctx, ServiceLog, LogFilter{Pattern: regexp.MustCompile("keep")}, func(line string) {
+			mu.Lock()
+			got = append(got, line)
+			mu.Unlock()
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	appendLine := func(line string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(line + "\n")
+		f.Close()
+	}
+	appendLine("keep 1")
+	appendLine("drop")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLine("keep 2")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("FollowLogs() error = %v", err)
+	}
+
+	if want := []string{"keep 1", "keep 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FollowLogs() lines = %q, want %q", got, want)
+	}
+}
+
+func TestFollowLogsTruncated(t *testing.T) {
+	dir := useTestLogDir(t)
+	defer func(interval time.Duration) { followInterval = interval }(followInterval)
+	followInterval = 10 * time.Millisecond
+
+	path := filepath.Join(dir, "service.log")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var got []string
+	lines := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got)
+	}
+	waitFor := func(n int) {
+		for deadline := time.Now().Add(2 * time.Second); lines() < n && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- FollowLogs(ctx, ServiceLog, LogFilter{}, func(line string) {
+			mu.Lock()
+			got = append(got, line)
+			mu.Unlock()
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	appendLine := func(line string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(line + "\n")
+		f.Close()
+	}
+	appendLine("a long line written before the truncation")
+	waitFor(1)
+
+	// Truncated in place, as by logrotate's copytruncate
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	appendLine("after")
+	waitFor(2)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("FollowLogs() error = %v", err)
+	}
+	if want := []string{"a long line written before the truncation", "after"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FollowLogs() lines = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,233 @@
+// internal/logging/rotate.go
+package logging
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// rotationInterval is how often the logs are checked for rotation
+const rotationInterval = time.Minute
+
+// rotateMutex serializes rotations and reopens
+var rotateMutex sync.Mutex
+
+// managedLog is a log file whose handle can be swapped while it is in use.
+type managedLog struct {
+	name string                             // file name within the log directory
+	swap func(file *os.File) (old *os.File) // installs file, returning the previous handle
+}
+
+// managedLogs returns the logs written by this process.
+func managedLogs() []managedLog {
+	if !fileOutput {
+		return nil
+	}
+	logs := []managedLog{
+		{config.ServiceLogFile, swapServiceFile},
+		{config.ActionLogFile, swapActionFile},
+	}
+	if QueryLogEnabled() {
+		logs = append(logs, managedLog{config.QueryLogFile, swapQueryFile})
+	}
+	return logs
+}
+
+func swapServiceFile(file *os.File) *os.File {
+	fileMutex.Lock()
+	old := serviceFile
+	serviceFile = file
+	fileMutex.Unlock()
+	if serviceLogger != nil {
+		serviceLogger.SetOutput(file)
+	}
+	return old
+}
+
+func swapActionFile(file *os.File) *os.File {
+	old := actionFile
+	actionFile = file
+	if actionLogger != nil {
+		actionLogger.SetOutput(file)
+	}
+	return old
+}
+
+func swapQueryFile(file *os.File) *os.File {
+	queryMutex.Lock()
+	defer queryMutex.Unlock()
+	old := queryFile
+	queryFile = file
+	return old
+}
+
+// reopen opens the log's path again and swaps the new handle in. The loggers
+// are switched over before the old handle is closed, so no line is lost.
+func (l managedLog) reopen() error {
+	file, err := os.OpenFile(filepath.Join(config.LogDir, l.name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen %s: %v", l.name, err)
+	}
+	if old := l.swap(file); old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Reopen reopens all log files, for use after an external tool such as
+// logrotate has moved them away.
+func Reopen() error {
+	rotateMutex.Lock()
+	defer rotateMutex.Unlock()
+
+	for _, l := range managedLogs() {
+		if err := l.reopen(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RotateLogs rotates the logs that grew beyond MaxLogSize or whose first line
+// is older than MaxLogAge.
+func RotateLogs() error {
+	rotateMutex.Lock()
+	defer rotateMutex.Unlock()
+
+	for _, l := range managedLogs() {
+		path := filepath.Join(config.LogDir, l.name)
+		info, err := os.Stat(path)
+		if err != nil || info.Size() == 0 {
+			continue // Skip if file doesn't exist or is empty
+		}
+
+		tooOld := false
+		if config.MaxLogAge > 0 {
+			if started, ok := firstLineTime(path); ok && time.Since(started) > config.MaxLogAge {
+				tooOld = true
+			}
+		}
+
+		if info.Size() > config.MaxLogSize || tooOld {
+			if err := rotateLog(l); err != nil {
+				return fmt.Errorf("failed to rotate %s: %v", l.name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// rotateLog moves the log to its first backup, reopens it and compresses the
+// backup. Older backups are shifted up, dropping the ones beyond MaxLogBackups.
+func rotateLog(l managedLog) error {
+	path := filepath.Join(config.LogDir, l.name)
+
+	_ = os.Remove(backupPath(path, config.MaxLogBackups))
+	_ = os.Remove(backupPath(path, config.MaxLogBackups) + ".gz")
+	for i := config.MaxLogBackups - 1; i > 0; i-- {
+		_ = os.Rename(backupPath(path, i), backupPath(path, i+1))
+		_ = os.Rename(backupPath(path, i)+".gz", backupPath(path, i+1)+".gz")
+	}
+
+	first := backupPath(path, 1)
+	if err := os.Rename(path, first); err != nil {
+		return err
+	}
+	if err := l.reopen(); err != nil {
+		return err
+	}
+
+	if config.MaxLogBackups < 1 {
+		return os.Remove(first)
+	}
+	return compressFile(first)
+}
+
+func backupPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// compressFile replaces path with a gzipped path.gz.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compress %s: %v", path, err)
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// firstLineTime returns the timestamp of the first line of a log.
+func firstLineTime(path string) (time.Time, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return time.Time{}, false
+	}
+	return lineTime(scanner.Text())
+}
+
+// StartRotation checks the logs for rotation in the background until the
+// returned function is called.
+func StartRotation() (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(rotationInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := RotateLogs(); err != nil {
+				Errorf("Log rotation failed: %v", err)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,148 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestLoggers opens the service and action logs in a temporary directory.
+func useTestLoggers(t *testing.T) string {
+	t.Helper()
+	dir := useTestLogDir(t)
+
+	oldService, oldAction := serviceLogger, actionLogger
+	oldServiceFile, oldActionFile := serviceFile, actionFile
+	t.Cleanup(func() {
+		serviceFile.Close()
+		actionFile.Close()
+		serviceLogger, actionLogger = oldService, oldAction
+		serviceFile, actionFile = oldServiceFile, oldActionFile
+	})
+
+	serviceFile, actionFile = nil, nil
+	serviceLogger = log.New(io.Discard, "", 0)
+	actionLogger = log.New(io.Discard, "", 0)
+	for _, l := range managedLogs() {
+		if err := l.reopen(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func readGzip(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening backup: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("backup is not gzipped: %v", err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestRotateLogs(t *testing.T) {
+	dir := useTestLoggers(t)
+	config.MaxLogSize = 10
+	config.MaxLogBackups = 2
+	path := filepath.Join(dir, "service.log")
+
+	for i, line := range []string{"first rotation", "second rotation", "third rotation"} {
+		serviceLogger.Print(line)
+		actionLogger.Print("x") // Below MaxLogSize, never rotated
+		if err := RotateLogs(); err != nil {
+			t.Fatalf("RotateLogs() #%d error = %v", i+1, err)
+		}
+	}
+	serviceLogger.Print("after rotation")
+
+	if got := readGzip(t, path+".1.gz"); !strings.Contains(got, "third rotation") {
+		t.Errorf("service.log.1.gz = %q, want the third rotation", got)
+	}
+	if got := readGzip(t, path+".2.gz"); !strings.Contains(got, "second rotation") {
+		t.Errorf("service.log.2.gz = %q, want the second rotation", got)
+	}
+	if _, err := os.Stat(path + ".3.gz"); !os.IsNotExist(err) {
+		t.Errorf("service.log.3.gz exists beyond MaxLogBackups")
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("uncompressed backup left behind")
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(content); got != "after rotation\n" {
+		t.Errorf("service.log = %q, want only lines written after rotation", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "actions.log.1.gz")); !os.IsNotExist(err) {
+		t.Errorf("actions.log rotated below MaxLogSize")
+	}
+
+	lines, err := ReadLogs(ServiceLog, LogFilter{})
+	if err != nil {
+		t.Fatalf("ReadLogs() error = %v", err)
+	}
+	if want := []string{"second rotation", "third rotation", "after rotation"}; strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("ReadLogs() = %q, want %q", lines, want)
+	}
+}
+
+func TestRotateLogsByAge(t *testing.T) {
+	dir := useTestLoggers(t)
+	config.MaxLogSize = 1 << 20
+	config.MaxLogAge = time.Hour
+	path := filepath.Join(dir, "service.log")
+
+	serviceLogger.Print(time.Now().Format(logTimeLayout) + " fresh")
+	if err := RotateLogs(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".1.gz"); !os.IsNotExist(err) {
+		t.Fatal("fresh log was rotated")
+	}
+
+	os.WriteFile(path, []byte(time.Now().Add(-2*time.Hour).Format(logTimeLayout)+" stale\n"), 0644)
+	if err := RotateLogs(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".1.gz"); err != nil {
+		t.Errorf("stale log was not rotated: %v", err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := useTestLoggers(t)
+	path := filepath.Join(dir, "service.log")
+
+	serviceLogger.Print("before")
+	if err := os.Rename(path, path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reopen(); err != nil {
+		t.Fatalf("Reopen() error = %v", err)
+	}
+	serviceLogger.Print("after")
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("log not recreated: %v", err)
+	}
+	if string(content) != "after\n" {
+		t.Errorf("service.log = %q, want %q", content, "after\n")
+	}
+}
@@ -0,0 +1,141 @@
+// internal/logging/sink.go
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log outputs
+const (
+	OutputFile     = "file"     // files under LOG_DIR
+	OutputSyslog   = "syslog"   // local syslog daemon, RFC 5424
+	OutputJournald = "journald" // systemd-journald native protocol
+
+	DefaultOutputs = OutputFile
+)
+
+// Log streams
+const (
+	StreamService = "service"
+	StreamAction  = "action"
+	StreamQuery   = "query"
+)
+
+// appName identifies NanoDNS to syslog and journald
+const appName = "nanodns"
+
+// Field is a structured key/value pair attached to an entry.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Entry is one message sent to the sinks.
+type Entry struct {
+	Time    time.Time
+	Stream  string // StreamService, StreamAction or StreamQuery
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+// Sink is a log destination besides the files under LOG_DIR.
+type Sink interface {
+	Write(e Entry) error
+	Close() error
+}
+
+var (
+	sinks      []Sink
+	sinksMutex sync.RWMutex
+	fileOutput = true
+)
+
+// parseOutputs splits a LOG_OUTPUTS value into its known outputs.
+func parseOutputs(value string) (outputs []string, err error) {
+	for _, output := range strings.Split(value, ",") {
+		output = strings.ToLower(strings.TrimSpace(output))
+		switch output {
+		case "":
+		case OutputFile, OutputSyslog, OutputJournald:
+			outputs = append(outputs, output)
+		default:
+			return nil, fmt.Errorf("unknown log output %q", output)
+		}
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("no log output configured")
+	}
+	return outputs, nil
+}
+
+// openSinks connects the configured syslog and journald sinks and decides
+// whether the log files are written.
+func openSinks(outputs []string) error {
+	var opened []Sink
+	files := false
+	for _, output := range outputs {
+		var sink Sink
+		var err error
+		switch output {
+		case OutputFile:
+			files = true
+			continue
+		case OutputSyslog:
+			sink, err = newSyslogSink(config.SyslogSocket, config.SyslogFacility)
+		case OutputJournald:
+			sink, err = newJournaldSink(config.JournaldSocket)
+		}
+		if err != nil {
+			for _, s := range opened {
+				s.Close()
+			}
+			return fmt.Errorf("failed to open %s log output: %v", output, err)
+		}
+		opened = append(opened, sink)
+	}
+
+	sinksMutex.Lock()
+	sinks = opened
+	fileOutput = files
+	sinksMutex.Unlock()
+	return nil
+}
+
+// FileOutput reports whether the log files under LOG_DIR are written.
+func FileOutput() bool {
+	sinksMutex.RLock()
+	defer sinksMutex.RUnlock()
+	return fileOutput
+}
+
+// hasSinks reports whether any sink is configured.
+func hasSinks() bool {
+	sinksMutex.RLock()
+	defer sinksMutex.RUnlock()
+	return len(sinks) > 0
+}
+
+// writeSinks sends e to every sink. Failing sinks are reported on stderr
+// rather than through the logger, which would write to them again.
+func writeSinks(e Entry) {
+	sinksMutex.RLock()
+	defer sinksMutex.RUnlock()
+	for _, sink := range sinks {
+		if err := sink.Write(e); err != nil && stderrLogger != nil {
+			stderrLogger.Printf("[ERROR] Failed to write log entry: %v", err)
+		}
+	}
+}
+
+func closeSinks() {
+	sinksMutex.Lock()
+	defer sinksMutex.Unlock()
+	for _, sink := range sinks {
+		sink.Close()
+	}
+	sinks = nil
+}
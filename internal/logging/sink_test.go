@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenUnixgram returns a datagram socket standing in for syslog or journald.
+func listenUnixgram(t *testing.T) (*net.UnixConn, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, path
+}
+
+func receive(t *testing.T, conn *net.UnixConn) []byte {
+	t.Helper()
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("reading datagram: %v", err)
+	}
+	return buf[:n]
+}
+
+func TestParseOutputs(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"file", []string{OutputFile}, false},
+		{"Syslog, journald", []string{OutputSyslog, OutputJournald}, false},
+		{"file,syslog", []string{OutputFile, OutputSyslog}, false},
+		{"kafka", nil, true},
+		{" , ", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseOutputs(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseOutputs(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseOutputs(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	conn, path := listenUnixgram(t)
+
+	sink, err := newSyslogSink(path, "local3")
+	if err != nil {
+		t.Fatalf("newSyslogSink() error = %v", err)
+	}
+	defer sink.Close()
+
+	when := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := sink.Write(Entry{Time: when, Stream: StreamService, Level: LevelWarn, Message: "relay slow"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got := string(receive(t, conn))
+	// local3 (19) * 8 + warning (4)
+	want := regexp.MustCompile(`^<156>1 2024-05-01T12:00:00.000000Z \S+ nanodns \d+ service - relay slow$`)
+	if !want.MatchString(got) {
+		t.Errorf("syslog message = %q, want match for %s", got, want)
+	}
+
+	err = sink.Write(Entry{Time: when, Stream: StreamQuery, Level: LevelInfo, Message: "query",
+		Fields: []Field{{"qname", "example.com."}, {"note", `a"b]`}}})
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if got := string(receive(t, conn)); !strings.Contains(got, ` query [nanodns@32473 qname="example.com." note="a\"b\]"] query`) {
+		t.Errorf("syslog message = %q, want structured data", got)
+	}
+
+	if _, err := newSyslogSink(path, "kernel"); err == nil {
+		t.Error("newSyslogSink() accepted an unknown facility")
+	}
+}
+
+func TestJournaldSink(t *testing.T) {
+	conn, path := listenUnixgram(t)
+
+	sink, err := newJournaldSink(path)
+	if err != nil {
+		t.Fatalf("newJournaldSink() error = %v", err)
+	}
+	defer sink.Close()
+
+	err = sink.Write(Entry{Time: time.Now(), Stream: StreamQuery, Level: LevelError, Message: "line 1\nline 2",
+		Fields: []Field{{"latency_ms", "1.5"}}})
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var want bytes.Buffer
+	want.WriteString("MESSAGE\n")
+	binary.Write(&want, binary.LittleEndian, uint64(len("line 1\nline 2")))
+	want.WriteString("line 1\nline 2\n")
+	want.WriteString("PRIORITY=3\nSYSLOG_IDENTIFIER=nanodns\nNANODNS_STREAM=query\nNANODNS_LATENCY_MS=1.5\n")
+
+	if got := receive(t, conn); !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("journald datagram = %q, want %q", got, want.Bytes())
+	}
+
+	if _, err := newJournaldSink(filepath.Join(t.TempDir(), "missing.sock")); err == nil {
+		t.Error("newJournaldSink() succeeded without a journald socket")
+	}
+}
+
+func TestInitWithoutFiles(t *testing.T) {
+	_, path := listenUnixgram(t)
+	logDir := filepath.Join(t.TempDir(), "log")
+	t.Setenv("LOG_OUTPUTS", "syslog")
+	t.Setenv("SYSLOG_SOCKET", path)
+	t.Setenv("LOG_DIR", logDir)
+
+	savedConfig, savedStderr := config, stderrLogger
+	defer func() {
+		for _, sink := range sinks {
+			sink.Close()
+		}
+		sinks, fileOutput = nil, true
+		config, stderrLogger = savedConfig, savedStderr
+	}()
+
+	if err := Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if FileOutput() {
+		t.Error("FileOutput() = true with LOG_OUTPUTS=syslog")
+	}
+	if stderrLogger != nil {
+		t.Error("Messages are still mirrored to stderr")
+	}
+	if _, err := os.Stat(logDir); !os.IsNotExist(err) {
+		t.Errorf("LOG_DIR was created without file output: %v", err)
+	}
+}
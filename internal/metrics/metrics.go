@@ -0,0 +1,328 @@
+// internal/metrics/metrics.go
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Query answer sources
+const (
+	SourceLocal    = "local"    // answered from an exact local record
+	SourceWildcard = "wildcard" // answered from a local wildcard record
+	SourceRelay    = "relay"    // answered by an upstream nameserver
+	SourceCache    = "cache"    // answered from the relay cache
+	SourceACL      = "acl"      // refused by an access control list
+	SourceBlocked  = "blocked"  // answered for a name on a blocklist
+)
+
+// relayBuckets are the upper bounds, in seconds, of the relay latency histogram
+var relayBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+var (
+	queries = newCounterVec("nanodns_queries_total",
+		"DNS queries answered, by query type, response code and answer source.",
+		"qtype", "rcode", "source")
+	relayDuration = newHistogramVec("nanodns_relay_duration_seconds",
+		"Latency of successful exchanges with upstream nameservers.",
+		relayBuckets, "upstream")
+	relayErrors = newCounterVec("nanodns_relay_errors_total",
+		"Failed exchanges with upstream nameservers.",
+		"server")
+	aclRejections = newCounterVec("nanodns_acl_rejections_total",
+		"Queries refused or dropped by an access control list, by list and action.",
+		"acl", "action")
+	rateLimited = newCounterVec("nanodns_rate_limited_total",
+		"Queries and responses over a rate limit, by listener, limit and action.",
+		"listener", "limit", "action")
+	serviceFailures = newCounterVec("nanodns_service_resolution_failures_total",
+		"Docker service names that could not be resolved.",
+		"service")
+	recordsLoaded = newGaugeVec("nanodns_records_loaded",
+		"Local DNS records currently loaded, by record type.",
+		"type")
+	blocklistDomains = newGaugeVec("nanodns_blocklist_domains",
+		"Domains on the loaded blocklists.")
+	reloadSuccess = newGaugeVec("nanodns_config_last_reload_successful",
+		"Whether the last load of the records succeeded.")
+	reloadTime = newGaugeVec("nanodns_config_last_reload_timestamp_seconds",
+		"Time of the last successful load of the records.")
+
+	registry = []collector{queries, relayDuration, relayErrors, aclRejections, rateLimited, serviceFailures, recordsLoaded, blocklistDomains, reloadSuccess, reloadTime}
+)
+
+// ObserveQuery counts an answered query.
+func ObserveQuery(qtype, rcode, source string) {
+	queries.add(1, qtype, rcode, source)
+}
+
+// ObserveRelay records an exchange with an upstream nameserver.
+func ObserveRelay(upstream string, duration time.Duration, err error) {
+	if err != nil {
+		relayErrors.add(1, upstream)
+		return
+	}
+	relayDuration.observe(duration.Seconds(), upstream)
+}
+
+// ObserveACLRejection counts a query an access control list refused or
+// dropped.
+func ObserveACLRejection(acl, action string) {
+	aclRejections.add(1, acl, action)
+}
+
+// ObserveRateLimited counts a query dropped by the query rate limit, or a
+// response dropped or truncated by response rate limiting.
+func ObserveRateLimited(listener, limit, action string) {
+	rateLimited.add(1, listener, limit, action)
+}
+
+// ServiceResolutionFailed counts a failed lookup of a Docker service name.
+func ServiceResolutionFailed(service string) {
+	serviceFailures.add(1, service)
+}
+
+// SetRecordsLoaded replaces the loaded record counts, keyed by record type.
+func SetRecordsLoaded(counts map[string]int) {
+	recordsLoaded.reset()
+	for recordType, count := range counts {
+		recordsLoaded.set(float64(count), recordType)
+	}
+}
+
+// SetBlocklistDomains sets the number of domains on the loaded blocklists.
+func SetBlocklistDomains(n int) {
+	blocklistDomains.set(float64(n))
+}
+
+// SetReloadStatus records the outcome of loading the records.
+func SetReloadStatus(ok bool) {
+	if ok {
+		reloadSuccess.set(1)
+		reloadTime.set(float64(time.Now().Unix()))
+		return
+	}
+	reloadSuccess.set(0)
+}
+
+// QueriesBy returns the answered queries summed by one of the qtype, rcode
+// and source labels.
+func QueriesBy(label string) map[string]float64 {
+	return queries.sumBy(label)
+}
+
+// RelayErrors returns the failed exchanges by upstream nameserver.
+func RelayErrors() map[string]float64 {
+	return relayErrors.sumBy("server")
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func WriteTo(w io.Writer) error {
+	var sb strings.Builder
+	for _, c := range registry {
+		c.write(&sb)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Handler serves the metrics over HTTP.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WriteTo(w)
+	})
+}
+
+type collector interface {
+	write(sb *strings.Builder)
+}
+
+// metricVec holds the label handling shared by all metric types.
+type metricVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+}
+
+func (m *metricVec) key(values []string) string {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+	return strings.Join(values, "\x00")
+}
+
+func (m *metricVec) header(sb *strings.Builder, kind string) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, kind)
+}
+
+// labelString formats the labels for the series identified by key, with extra
+// label pairs appended.
+func (m *metricVec) labelString(key string, extra ...string) string {
+	var pairs []string
+	if len(m.labels) > 0 {
+		for i, value := range strings.Split(key, "\x00") {
+			pairs = append(pairs, m.labels[i]+`="`+labelEscaper.Replace(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelEscaper escapes label values as the text exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func sortedKeys[V any](series map[string]V) []string {
+	keys := make([]string, 0, len(series))
+	for k := range series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type counterVec struct {
+	metricVec
+	series map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{metricVec: metricVec{name: name, help: help, labels: labels}, series: make(map[string]float64)}
+}
+
+func (c *counterVec) add(v float64, values ...string) {
+	key := c.key(values)
+	c.mu.Lock()
+	c.series[key] += v
+	c.mu.Unlock()
+}
+
+// sumBy sums the series by the value of label.
+func (c *counterVec) sumBy(label string) map[string]float64 {
+	i := slices.Index(c.labels, label)
+	if i < 0 {
+		panic(fmt.Sprintf("metrics: %s has no label %s", c.name, label))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sums := make(map[string]float64)
+	for key, v := range c.series {
+		sums[strings.Split(key, "\x00")[i]] += v
+	}
+	return sums
+}
+
+func (c *counterVec) write(sb *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(sb, "counter")
+	for _, key := range sortedKeys(c.series) {
+		fmt.Fprintf(sb, "%s%s %s\n", c.name, c.labelString(key), formatFloat(c.series[key]))
+	}
+}
+
+type gaugeVec struct {
+	counterVec
+}
+
+func newGaugeVec(name, help string, labels ...string) *gaugeVec {
+	return &gaugeVec{counterVec: *newCounterVec(name, help, labels...)}
+}
+
+func (g *gaugeVec) set(v float64, values ...string) {
+	key := g.key(values)
+	g.mu.Lock()
+	g.series[key] = v
+	g.mu.Unlock()
+}
+
+func (g *gaugeVec) reset() {
+	g.mu.Lock()
+	g.series = make(map[string]float64)
+	g.mu.Unlock()
+}
+
+func (g *gaugeVec) write(sb *strings.Builder) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(sb, "gauge")
+	for _, key := range sortedKeys(g.series) {
+		fmt.Fprintf(sb, "%s%s %s\n", g.name, g.labelString(key), formatFloat(g.series[key]))
+	}
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+type histogramVec struct {
+	metricVec
+	buckets []float64
+	series  map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		metricVec: metricVec{name: name, help: help, labels: labels},
+		buckets:   buckets,
+		series:    make(map[string]*histogram),
+	}
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogramVec) write(sb *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(sb, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(sb, "%s_bucket%s %d\n", h.name, h.labelString(key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(sb, "%s_bucket%s %d\n", h.name, h.labelString(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(sb, "%s_sum%s %s\n", h.name, h.labelString(key), formatFloat(s.sum))
+		fmt.Fprintf(sb, "%s_count%s %d\n", h.name, h.labelString(key), s.count)
+	}
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounterExposition(t *testing.T) {
+	c := newCounterVec("test_total", "Test counter.", "qtype", "source")
+	c.add(1, "A", "local")
+	c.add(2, "A", "local")
+	c.add(1, "AAAA", "relay")
+
+	var sb strings.Builder
+	c.write(&sb)
+
+	want := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{qtype="A",source="local"} 3
+test_total{qtype="AAAA",source="relay"} 1
+`
+	if sb.String() != want {
+		t.Errorf("write() =\n%s\nwant\n%s", sb.String(), want)
+	}
+}
+
+func TestCounterSumBy(t *testing.T) {
+	c := newCounterVec("test_total", "Test counter.", "qtype", "source")
+	c.add(1, "A", "local")
+	c.add(2, "AAAA", "local")
+	c.add(4, "A", "relay")
+
+	sums := c.sumBy("source")
+	if len(sums) != 2 || sums["local"] != 3 || sums["relay"] != 4 {
+		t.Errorf("sumBy(source) = %v, want local=3 relay=4", sums)
+	}
+	sums = c.sumBy("qtype")
+	if len(sums) != 2 || sums["A"] != 5 || sums["AAAA"] != 2 {
+		t.Errorf("sumBy(qtype) = %v, want A=5 AAAA=2", sums)
+	}
+}
+
+func TestGaugeWithoutLabels(t *testing.T) {
+	g := newGaugeVec("test_gauge", "Test gauge.")
+	g.set(1)
+	g.set(0)
+
+	var sb strings.Builder
+	g.write(&sb)
+
+	if !strings.Contains(sb.String(), "\ntest_gauge 0\n") {
+		t.Errorf("write() = %q, want unlabeled sample 0", sb.String())
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	h := newHistogramVec("test_seconds", "Test histogram.", []float64{0.1, 1}, "upstream")
+	h.observe(0.05, "8.8.8.8:53")
+	h.observe(0.1, "8.8.8.8:53")
+	h.observe(0.5, "8.8.8.8:53")
+	h.observe(3, "8.8.8.8:53")
+
+	var sb strings.Builder
+	h.write(&sb)
+
+	for _, line := range []string{
+		`test_seconds_bucket{upstream="8.8.8.8:53",le="0.1"} 2`,
+		`test_seconds_bucket{upstream="8.8.8.8:53",le="1"} 3`,
+		`test_seconds_bucket{upstream="8.8.8.8:53",le="+Inf"} 4`,
+		`test_seconds_sum{upstream="8.8.8.8:53"} 3.65`,
+		`test_seconds_count{upstream="8.8.8.8:53"} 4`,
+	} {
+		if !strings.Contains(sb.String(), line+"\n") {
+			t.Errorf("write() missing %q in\n%s", line, sb.String())
+		}
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	c := newCounterVec("test_total", "Test counter.", "service")
+	c.add(1, "a\"b\\c\nd")
+
+	var sb strings.Builder
+	c.write(&sb)
+
+	if want := `test_total{service="a\"b\\c\nd"} 1`; !strings.Contains(sb.String(), want) {
+		t.Errorf("write() = %q, want it to contain %q", sb.String(), want)
+	}
+}
+
+func TestObserveRelay(t *testing.T) {
+	ObserveRelay("192.0.2.1:53", 20*time.Millisecond, nil)
+	ObserveRelay("192.0.2.1:53", 0, errors.New("timeout"))
+
+	var sb strings.Builder
+	if err := WriteTo(&sb); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	for _, line := range []string{
+		`nanodns_relay_duration_seconds_count{upstream="192.0.2.1:53"} 1`,
+		`nanodns_relay_errors_total{server="192.0.2.1:53"} 1`,
+	} {
+		if !strings.Contains(sb.String(), line) {
+			t.Errorf("WriteTo() missing %q", line)
+		}
+	}
+}
+
+func TestSetRecordsLoaded(t *testing.T) {
+	SetRecordsLoaded(map[string]int{"A": 3, "MX": 1})
+	SetRecordsLoaded(map[string]int{"A": 2})
+
+	var sb strings.Builder
+	recordsLoaded.write(&sb)
+
+	if !strings.Contains(sb.String(), `nanodns_records_loaded{type="A"} 2`) {
+		t.Errorf("write() = %q, want A count 2", sb.String())
+	}
+	if strings.Contains(sb.String(), `type="MX"`) {
+		t.Errorf("write() = %q, want stale MX count removed", sb.String())
+	}
+}
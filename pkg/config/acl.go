@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+)
+
+// ACL actions
+const (
+	ACLAllow  = "allow"  // answer the query
+	ACLRefuse = "refuse" // answer REFUSED
+	ACLDeny   = "deny"   // drop the query without answering
+)
+
+// ACLRule applies Action to the clients in Network.
+type ACLRule struct {
+	Action  string
+	Network netip.Prefix
+}
+
+// ACL is a list of rules, the first rule matching a client applies. An empty
+// ACL allows every client.
+type ACL []ACLRule
+
+// Check returns the action for a client at addr: the action of the first
+// matching rule, or ACLRefuse if none matches.
+func (acl ACL) Check(addr netip.Addr) string {
+	if len(acl) == 0 {
+		return ACLAllow
+	}
+	addr = addr.Unmap()
+	for _, rule := range acl {
+		if rule.Network.Contains(addr) {
+			return rule.Action
+		}
+	}
+	return ACLRefuse
+}
+
+// ACLConfig holds the access control lists of the handler.
+type ACLConfig struct {
+	Query     ACL // every query
+	Recursion ACL // queries relayed to the upstream nameservers
+	Transfer  ACL // zone transfers (AXFR, IXFR) and dynamic updates
+}
+
+// refuseAll refuses every client
+var refuseAll = ACL{
+	{Action: ACLRefuse, Network: netip.MustParsePrefix("0.0.0.0/0")},
+	{Action: ACLRefuse, Network: netip.MustParsePrefix("::/0")},
+}
+
+// GetACLConfig returns the access control lists from ACL_QUERY,
+// ACL_RECURSION and ACL_TRANSFER. Queries and recursion are allowed to every
+// client by default, transfers and updates to none.
+func GetACLConfig() ACLConfig {
+	return ACLConfig{
+		Query:     getACL("ACL_QUERY", nil),
+		Recursion: getACL("ACL_RECURSION", nil),
+		Transfer:  getACL("ACL_TRANSFER", refuseAll),
+	}
+}
+
+// getACL reads an ACL from key. An ACL with an invalid rule refuses every
+// client rather than letting through those the rule was meant to stop.
+func getACL(key string, fallback ACL) ACL {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	acl, err := ParseACL(value)
+	if err != nil {
+		logging.Warnf("Invalid %s, refusing every client: %v", key, err)
+		return refuseAll
+	}
+	return acl
+}
+
+// ParseACL parses comma-separated rules such as "allow 10.0.0.0/8, deny any".
+// A rule is an action followed by a CIDR prefix, an IP address or "any"; a
+// network without an action is allowed.
+func ParseACL(value string) (ACL, error) {
+	var acl ACL
+	for _, rule := range strings.Split(value, ",") {
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			continue
+		}
+
+		action := ACLAllow
+		if len(fields) == 2 {
+			action, fields = strings.ToLower(fields[0]), fields[1:]
+		}
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("invalid rule %q, expected an action and a network", strings.TrimSpace(rule))
+		}
+		switch action {
+		case ACLAllow, ACLRefuse, ACLDeny:
+		default:
+			return nil, fmt.Errorf("unknown action %q, expected allow, refuse or deny", action)
+		}
+
+		if strings.EqualFold(fields[0], "any") {
+			acl = append(acl,
+				ACLRule{Action: action, Network: netip.MustParsePrefix("0.0.0.0/0")},
+				ACLRule{Action: action, Network: netip.MustParsePrefix("::/0")})
+			continue
+		}
+		network, err := ParseNetwork(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %v", fields[0], err)
+		}
+		acl = append(acl, ACLRule{Action: action, Network: network})
+	}
+	return acl, nil
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParseACL(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    ACL
+		wantErr bool
+	}{
+		{"", nil, false},
+		{
+			"allow 10.0.0.0/8, REFUSE 192.168.1.7 ,deny 2001:db8::/32",
+			ACL{
+				{ACLAllow, netip.MustParsePrefix("10.0.0.0/8")},
+				{ACLRefuse, netip.MustParsePrefix("192.168.1.7/32")},
+				{ACLDeny, netip.MustParsePrefix("2001:db8::/32")},
+			},
+			false,
+		},
+		{
+			"127.0.0.1, deny any",
+			ACL{
+				{ACLAllow, netip.MustParsePrefix("127.0.0.1/32")},
+				{ACLDeny, netip.MustParsePrefix("0.0.0.0/0")},
+				{ACLDeny, netip.MustParsePrefix("::/0")},
+			},
+			false,
+		},
+		{"block 10.0.0.0/8", nil, true},
+		{"allow 10.0.0.0/33", nil, true},
+		{"allow 10.0.0.1 10.0.0.2", nil, true},
+		{"deny", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseACL(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseACL(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseACL(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestACLCheck(t *testing.T) {
+	acl, err := ParseACL("deny 10.1.0.0/16, allow 10.0.0.0/8, refuse 2001:db8::/32")
+	if err != nil {
+		t.Fatalf("ParseACL() error = %v", err)
+	}
+
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"10.1.2.3", ACLDeny},
+		{"10.2.3.4", ACLAllow},
+		{"::ffff:10.2.3.4", ACLAllow},
+		{"2001:db8::1", ACLRefuse},
+		{"192.0.2.1", ACLRefuse},
+	}
+	for _, tt := range tests {
+		if got := acl.Check(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("Check(%s) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+
+	if got := acl.Check(netip.Addr{}); got != ACLRefuse {
+		t.Errorf("Check() of an unknown client = %q, want %q", got, ACLRefuse)
+	}
+	if got := ACL(nil).Check(netip.MustParseAddr("192.0.2.1")); got != ACLAllow {
+		t.Errorf("Check() of an empty ACL = %q, want %q", got, ACLAllow)
+	}
+}
+
+func TestGetACLConfig(t *testing.T) {
+	t.Setenv("ACL_QUERY", "")
+	t.Setenv("ACL_RECURSION", "allow 10.0.0.0/8, allow nowhere")
+	t.Setenv("ACL_TRANSFER", "")
+
+	cfg := GetACLConfig()
+	client := netip.MustParseAddr("10.0.0.1")
+	if got := cfg.Query.Check(client); got != ACLAllow {
+		t.Errorf("Default query ACL = %q, want %q", got, ACLAllow)
+	}
+	if got := cfg.Recursion.Check(client); got != ACLRefuse {
+		t.Errorf("Invalid recursion ACL = %q, want %q", got, ACLRefuse)
+	}
+	if got := cfg.Transfer.Check(client); got != ACLRefuse {
+		t.Errorf("Default transfer ACL = %q, want %q", got, ACLRefuse)
+	}
+}
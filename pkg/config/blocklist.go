@@ -0,0 +1,116 @@
+package config
+
+import (
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+)
+
+// Answers to queries for blocked names
+const (
+	BlockNXDomain = "nxdomain" // the name doesn't exist
+	BlockNull     = "null"     // the name resolves to 0.0.0.0 and ::
+
+	DefaultBlocklistRefresh = 24 * time.Hour
+)
+
+// nullAddrs are the addresses of BlockNull
+var nullAddrs = []netip.Addr{netip.IPv4Unspecified(), netip.IPv6Unspecified()}
+
+// BlocklistConfig holds the domain blocklists.
+type BlocklistConfig struct {
+	Files []string // local lists, read again on every refresh
+	URLs  []string // lists downloaded on every refresh
+	Allow []string // names never blocked, with their subdomains
+
+	// Answer is BlockNXDomain, BlockNull or the sinkhole addresses, and
+	// Sinkhole the addresses blocked names resolve to, none for NXDOMAIN.
+	Answer   string
+	Sinkhole []netip.Addr
+
+	Refresh time.Duration
+}
+
+// Enabled reports whether any list is configured.
+func (c BlocklistConfig) Enabled() bool {
+	return len(c.Files) > 0 || len(c.URLs) > 0
+}
+
+// GetBlocklistConfig returns the blocklists from BLOCKLIST_FILES and
+// BLOCKLIST_URLS, the names never blocked from BLOCKLIST_ALLOW, the answer
+// to blocked names from BLOCKLIST_ANSWER and how often the lists are loaded
+// again from BLOCKLIST_REFRESH.
+func GetBlocklistConfig() BlocklistConfig {
+	config := BlocklistConfig{
+		Files:    splitList(os.Getenv("BLOCKLIST_FILES")),
+		URLs:     splitList(os.Getenv("BLOCKLIST_URLS")),
+		Allow:    splitList(os.Getenv("BLOCKLIST_ALLOW")),
+		Answer:   BlockNull,
+		Sinkhole: nullAddrs,
+		Refresh:  DefaultBlocklistRefresh,
+	}
+
+	for _, url := range config.URLs {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			logging.Warnf("Blocklist URL %q is neither http nor https and may fail to load", url)
+		}
+	}
+
+	switch value := strings.ToLower(strings.TrimSpace(os.Getenv("BLOCKLIST_ANSWER"))); value {
+	case "", BlockNull:
+	case BlockNXDomain:
+		config.Answer, config.Sinkhole = BlockNXDomain, nil
+	default:
+		sinkhole, ok := parseSinkhole(value)
+		if !ok {
+			logging.Warnf("Invalid BLOCKLIST_ANSWER %q, using %q", value, BlockNull)
+			break
+		}
+		config.Answer, config.Sinkhole = value, sinkhole
+	}
+
+	if value := strings.TrimSpace(os.Getenv("BLOCKLIST_REFRESH")); value != "" {
+		refresh, err := time.ParseDuration(value)
+		if err != nil || refresh < 0 {
+			logging.Warnf("Invalid BLOCKLIST_REFRESH %q, using %s", value, DefaultBlocklistRefresh)
+		} else {
+			config.Refresh = refresh
+		}
+	}
+
+	return config
+}
+
+// parseSinkhole parses up to one IPv4 and one IPv6 address, separated by
+// commas.
+func parseSinkhole(value string) ([]netip.Addr, bool) {
+	var addrs []netip.Addr
+	var v4, v6 bool
+	for _, field := range splitList(value) {
+		addr, err := netip.ParseAddr(field)
+		if err != nil || addr.Zone() != "" {
+			return nil, false
+		}
+		addr = addr.Unmap()
+		if addr.Is4() && v4 || addr.Is6() && v6 {
+			return nil, false
+		}
+		v4, v6 = v4 || addr.Is4(), v6 || addr.Is6()
+		addrs = append(addrs, addr)
+	}
+	return addrs, len(addrs) > 0
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetBlocklistConfig(t *testing.T) {
+	null := []netip.Addr{netip.IPv4Unspecified(), netip.IPv6Unspecified()}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want BlocklistConfig
+	}{
+		{
+			name: "defaults",
+			want: BlocklistConfig{Answer: BlockNull, Sinkhole: null, Refresh: DefaultBlocklistRefresh},
+		},
+		{
+			name: "lists",
+			env: map[string]string{
+				"BLOCKLIST_FILES":   "/etc/nanodns/hosts, ,/etc/nanodns/extra",
+				"BLOCKLIST_URLS":    "https://example.com/hosts.txt",
+				"BLOCKLIST_ALLOW":   "ok.example.com",
+				"BLOCKLIST_ANSWER":  "NXDOMAIN",
+				"BLOCKLIST_REFRESH": "6h",
+			},
+			want: BlocklistConfig{
+				Files:   []string{"/etc/nanodns/hosts", "/etc/nanodns/extra"},
+				URLs:    []string{"https://example.com/hosts.txt"},
+				Allow:   []string{"ok.example.com"},
+				Answer:  BlockNXDomain,
+				Refresh: 6 * time.Hour,
+			},
+		},
+		{
+			name: "sinkhole",
+			env:  map[string]string{"BLOCKLIST_ANSWER": "192.168.1.2, fd00::2"},
+			want: BlocklistConfig{
+				Answer:   "192.168.1.2, fd00::2",
+				Sinkhole: []netip.Addr{netip.MustParseAddr("192.168.1.2"), netip.MustParseAddr("fd00::2")},
+				Refresh:  DefaultBlocklistRefresh,
+			},
+		},
+		{
+			name: "invalid values",
+			env:  map[string]string{"BLOCKLIST_ANSWER": "10.0.0.1,10.0.0.2", "BLOCKLIST_REFRESH": "daily"},
+			want: BlocklistConfig{Answer: BlockNull, Sinkhole: null, Refresh: DefaultBlocklistRefresh},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"BLOCKLIST_FILES", "BLOCKLIST_URLS", "BLOCKLIST_ALLOW", "BLOCKLIST_ANSWER", "BLOCKLIST_REFRESH"} {
+				t.Setenv(key, tt.env[key])
+			}
+			got := GetBlocklistConfig()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetBlocklistConfig() = %+v, want %+v", got, tt.want)
+			}
+			if got.Enabled() != (len(tt.want.Files)+len(tt.want.URLs) > 0) {
+				t.Errorf("Enabled() = %v", got.Enabled())
+			}
+		})
+	}
+}
@@ -1,13 +1,17 @@
 package config
 
 import (
-	"log"
+	"fmt"
+	"maps"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/mguptahub/nanodns/internal/logging"
 )
 
 const (
@@ -15,26 +19,100 @@ const (
 	DefaultPort    = "53"
 	ServicePrefix  = "service:"
 	DefaultTimeout = 5 * time.Second
+
+	DefaultRuntimeDir      = "/tmp"
+	DefaultShutdownTimeout = 10 * time.Second
+
+	// Wildcard capture modes control what replaces "*" in a wildcard CNAME target
+	WildcardCaptureFull  = "full"  // the whole prefix matched by the wildcard
+	WildcardCaptureFirst = "first" // only the leftmost label of the query name
 )
 
+// PrivilegeConfig is the account a server started as root switches to once
+// its sockets are bound.
+type PrivilegeConfig struct {
+	User   string
+	Group  string
+	Chroot string
+}
+
 type RelayConfig struct {
 	Enabled     bool
 	Nameservers []string
 	Timeout     time.Duration
 }
 
+// envFileKeys are the variables set from the env files, which a reload may
+// change or remove. Variables from the process environment always win.
+var envFileKeys = make(map[string]bool)
+
 func Initialize() {
-	envFile := os.Getenv("NANODNS_ENV_FILE")
-	if envFile == "" {
-		envFile = ".env"
+	vars, err := readEnvFiles()
+	if err != nil {
+		logging.Errorf("%v", err)
+	}
+
+	for key, value := range vars {
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		os.Setenv(key, value)
+		envFileKeys[key] = true
+	}
+}
+
+// ReloadEnvFile reads the env files again, so a following reload of the
+// records sees their current contents. Variables removed from the files are
+// unset; variables of the process environment are left alone.
+func ReloadEnvFile() error {
+	vars, err := readEnvFiles()
+	if err != nil {
+		return err
+	}
+
+	for key := range envFileKeys {
+		if _, ok := vars[key]; !ok {
+			os.Unsetenv(key)
+			delete(envFileKeys, key)
+		}
+	}
+	for key, value := range vars {
+		if _, set := os.LookupEnv(key); set && !envFileKeys[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		envFileKeys[key] = true
+	}
+	return nil
+}
+
+// readEnvFiles reads the env file, which may be missing, and the records
+// file given with --records, whose variables take precedence.
+func readEnvFiles() (map[string]string, error) {
+	envFile := envFilePath()
+	vars, err := godotenv.Read(envFile)
+	if os.IsNotExist(err) {
+		vars, err = make(map[string]string), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file %s: %w", envFile, err)
 	}
 
-	if err := godotenv.Load(envFile); err != nil {
-		// Only log if file exists but couldn't be loaded
-		if !os.IsNotExist(err) {
-			log.Printf("Error loading env file %s: %v", envFile, err)
+	if recordsFile := os.Getenv("NANODNS_RECORDS_FILE"); recordsFile != "" {
+		records, err := godotenv.Read(recordsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read records file %s: %w", recordsFile, err)
 		}
+		maps.Copy(vars, records)
+	}
+	return vars, nil
+}
+
+func envFilePath() string {
+	if envFile := os.Getenv("NANODNS_ENV_FILE"); envFile != "" {
+		return envFile
 	}
+	return ".env"
 }
 
 func GetDNSPort() string {
@@ -44,6 +122,95 @@ func GetDNSPort() string {
 	return DefaultPort
 }
 
+// GetHTTPAddr returns the listen address of the HTTP server exposing metrics
+// from HTTP_ADDR, e.g. ":9153". An empty address disables the server.
+func GetHTTPAddr() string {
+	return strings.TrimSpace(os.Getenv("HTTP_ADDR"))
+}
+
+// GetRuntimeDir returns the directory holding the PID file and the control
+// socket, from RUNTIME_DIR.
+func GetRuntimeDir() string {
+	if dir := strings.TrimSpace(os.Getenv("RUNTIME_DIR")); dir != "" {
+		return dir
+	}
+	return DefaultRuntimeDir
+}
+
+// GetPIDFile returns the path of the PID file from PID_FILE, by default
+// nanodns.pid in the runtime directory.
+func GetPIDFile() string {
+	if path := strings.TrimSpace(os.Getenv("PID_FILE")); path != "" {
+		return path
+	}
+	return filepath.Join(GetRuntimeDir(), "nanodns.pid")
+}
+
+// GetControlSocket returns the path of the control socket the server listens
+// on for admin commands from CONTROL_SOCKET, by default nanodns.sock in the
+// runtime directory.
+func GetControlSocket() string {
+	if path := strings.TrimSpace(os.Getenv("CONTROL_SOCKET")); path != "" {
+		return path
+	}
+	return filepath.Join(GetRuntimeDir(), "nanodns.sock")
+}
+
+// GetPrivilegeConfig returns the user and group the server runs as from
+// RUN_USER and RUN_GROUP, and the directory it is confined to from
+// RUN_CHROOT. They are all empty by default, keeping the server as started.
+func GetPrivilegeConfig() PrivilegeConfig {
+	return PrivilegeConfig{
+		User:   strings.TrimSpace(os.Getenv("RUN_USER")),
+		Group:  strings.TrimSpace(os.Getenv("RUN_GROUP")),
+		Chroot: strings.TrimSpace(os.Getenv("RUN_CHROOT")),
+	}
+}
+
+// GetShutdownTimeout returns how long the server waits for queries in flight
+// to be answered when shutting down, from SHUTDOWN_TIMEOUT.
+func GetShutdownTimeout() time.Duration {
+	value := strings.TrimSpace(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if value == "" {
+		return DefaultShutdownTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		logging.Warnf("Invalid SHUTDOWN_TIMEOUT %q, using %s", value, DefaultShutdownTimeout)
+		return DefaultShutdownTimeout
+	}
+	return timeout
+}
+
+// GetRelayCacheSize returns the number of relayed answers to cache from
+// DNS_RELAY_CACHE_SIZE. Zero, the default, disables the cache.
+func GetRelayCacheSize() int {
+	value := strings.TrimSpace(os.Getenv("DNS_RELAY_CACHE_SIZE"))
+	if value == "" {
+		return 0
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 {
+		logging.Warnf("Invalid DNS_RELAY_CACHE_SIZE %q, relay cache disabled", value)
+		return 0
+	}
+	return size
+}
+
+// GetWildcardCapture returns the wildcard capture mode from DNS_WILDCARD_CAPTURE.
+// Unknown values fall back to WildcardCaptureFull.
+func GetWildcardCapture() string {
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("DNS_WILDCARD_CAPTURE"))); mode {
+	case WildcardCaptureFull, WildcardCaptureFirst:
+		return mode
+	case "":
+		return WildcardCaptureFull
+	default:
+		logging.Warnf("Invalid DNS_WILDCARD_CAPTURE %q, using %q", mode, WildcardCaptureFull)
+		return WildcardCaptureFull
+	}
+}
+
 // IsServiceRecord checks if the value represents a Docker service
 func IsServiceRecord(value string) bool {
 	return strings.HasPrefix(value, ServicePrefix)
@@ -75,7 +242,7 @@ func GetRelayConfig() RelayConfig {
 			if server == "" {
 				continue
 			} else if !isValidNameserver(server) {
-				log.Printf("Warning: Invalid nameserver address: %s", server)
+				logging.Warnf("Invalid nameserver address: %s", server)
 				hasInvalid = true
 				continue
 			}
@@ -88,7 +255,7 @@ func GetRelayConfig() RelayConfig {
 			config.Enabled = true
 			config.Nameservers = validServers
 		} else {
-			log.Print("Warning: DNS relay disabled due to invalid nameserver entries")
+			logging.Warnf("DNS relay disabled due to invalid nameserver entries")
 		}
 	}
 
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -43,6 +44,159 @@ func TestGetDNSPort(t *testing.T) {
 	}
 }
 
+func TestGetHTTPAddr(t *testing.T) {
+	oldAddr := os.Getenv("HTTP_ADDR")
+	defer os.Setenv("HTTP_ADDR", oldAddr)
+
+	os.Unsetenv("HTTP_ADDR")
+	if got := GetHTTPAddr(); got != "" {
+		t.Errorf("GetHTTPAddr() = %q, want disabled by default", got)
+	}
+
+	os.Setenv("HTTP_ADDR", " :9153 ")
+	if got := GetHTTPAddr(); got != ":9153" {
+		t.Errorf("GetHTTPAddr() = %q, want %q", got, ":9153")
+	}
+}
+
+func TestReloadEnvFile(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	t.Setenv("NANODNS_ENV_FILE", envFile)
+	t.Setenv("TEST_RELOAD_PROCESS", "process")
+	for _, key := range []string{"TEST_RELOAD_KEPT", "TEST_RELOAD_REMOVED", "TEST_RELOAD_ADDED"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+
+	write := func(content string) {
+		if err := os.WriteFile(envFile, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("TEST_RELOAD_KEPT=old\nTEST_RELOAD_REMOVED=x\nTEST_RELOAD_PROCESS=file\n")
+	Initialize()
+
+	write("TEST_RELOAD_KEPT=new\nTEST_RELOAD_ADDED=y\nTEST_RELOAD_PROCESS=file\n")
+	if err := ReloadEnvFile(); err != nil {
+		t.Fatalf("ReloadEnvFile() error = %v", err)
+	}
+
+	tests := []struct {
+		key   string
+		want  string
+		isSet bool
+	}{
+		{"TEST_RELOAD_KEPT", "new", true},
+		{"TEST_RELOAD_ADDED", "y", true},
+		{"TEST_RELOAD_REMOVED", "", false},
+		{"TEST_RELOAD_PROCESS", "process", true},
+	}
+	for _, tt := range tests {
+		got, isSet := os.LookupEnv(tt.key)
+		if got != tt.want || isSet != tt.isSet {
+			t.Errorf("%s = %q (set: %v), want %q (set: %v)", tt.key, got, isSet, tt.want, tt.isSet)
+		}
+	}
+}
+
+func TestRecordsFile(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, ".env")
+	recordsFile := filepath.Join(dir, "records.env")
+	os.WriteFile(envFile, []byte("TEST_RECORDS_SHARED=env\nTEST_RECORDS_ENV=env\n"), 0644)
+	os.WriteFile(recordsFile, []byte("TEST_RECORDS_SHARED=records\n"), 0644)
+	t.Setenv("NANODNS_ENV_FILE", envFile)
+	t.Setenv("NANODNS_RECORDS_FILE", recordsFile)
+	for _, key := range []string{"TEST_RECORDS_SHARED", "TEST_RECORDS_ENV"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+
+	Initialize()
+	if got := os.Getenv("TEST_RECORDS_SHARED"); got != "records" {
+		t.Errorf("TEST_RECORDS_SHARED = %q, want the records file to win", got)
+	}
+	if got := os.Getenv("TEST_RECORDS_ENV"); got != "env" {
+		t.Errorf("TEST_RECORDS_ENV = %q, want %q", got, "env")
+	}
+
+	t.Setenv("NANODNS_RECORDS_FILE", filepath.Join(dir, "missing.env"))
+	if err := ReloadEnvFile(); err == nil {
+		t.Error("ReloadEnvFile() with a missing records file succeeded")
+	}
+}
+
+func TestRuntimePaths(t *testing.T) {
+	tests := []struct {
+		name       string
+		runtimeDir string
+		pidFile    string
+		socket     string
+		wantPID    string
+		wantSocket string
+	}{
+		{"defaults", "", "", "", "/tmp/nanodns.pid", "/tmp/nanodns.sock"},
+		{"runtime directory", "/run/nanodns", "", "", "/run/nanodns/nanodns.pid", "/run/nanodns/nanodns.sock"},
+		{"explicit paths", "/run/nanodns", "/var/run/dns.pid", "/var/run/dns.sock", "/var/run/dns.pid", "/var/run/dns.sock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RUNTIME_DIR", tt.runtimeDir)
+			t.Setenv("PID_FILE", tt.pidFile)
+			t.Setenv("CONTROL_SOCKET", tt.socket)
+			if got := GetPIDFile(); got != tt.wantPID {
+				t.Errorf("GetPIDFile() = %q, want %q", got, tt.wantPID)
+			}
+			if got := GetControlSocket(); got != tt.wantSocket {
+				t.Errorf("GetControlSocket() = %q, want %q", got, tt.wantSocket)
+			}
+		})
+	}
+}
+
+func TestGetRelayCacheSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", 0},
+		{"1000", 1000},
+		{"-1", 0},
+		{"many", 0},
+	}
+	for _, tt := range tests {
+		t.Setenv("DNS_RELAY_CACHE_SIZE", tt.value)
+		if got := GetRelayCacheSize(); got != tt.want {
+			t.Errorf("GetRelayCacheSize() with %q = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGetWildcardCapture(t *testing.T) {
+	oldMode := os.Getenv("DNS_WILDCARD_CAPTURE")
+	defer os.Setenv("DNS_WILDCARD_CAPTURE", oldMode)
+
+	tests := []struct {
+		name     string
+		envValue string
+		want     string
+	}{
+		{"default", "", WildcardCaptureFull},
+		{"full", "full", WildcardCaptureFull},
+		{"first", "FIRST", WildcardCaptureFirst},
+		{"invalid", "everything", WildcardCaptureFull},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("DNS_WILDCARD_CAPTURE", tt.envValue)
+			if got := GetWildcardCapture(); got != tt.want {
+				t.Errorf("GetWildcardCapture() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestIsServiceRecord(t *testing.T) {
 	tests := []struct {
 		name  string
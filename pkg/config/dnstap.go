@@ -0,0 +1,33 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// DnstapConfig holds the dnstap output settings. At most one of Socket and
+// File is used, the socket taking precedence.
+type DnstapConfig struct {
+	Socket   string // Unix socket of a dnstap collector
+	File     string // File to write the Frame Stream to
+	Identity string // Server identity sent with every event
+}
+
+// Enabled reports whether dnstap events are written anywhere.
+func (c DnstapConfig) Enabled() bool {
+	return c.Socket != "" || c.File != ""
+}
+
+// GetDnstapConfig returns the dnstap configuration from DNSTAP_SOCKET,
+// DNSTAP_FILE and DNSTAP_IDENTITY, which defaults to the host name.
+func GetDnstapConfig() DnstapConfig {
+	config := DnstapConfig{
+		Socket:   strings.TrimSpace(os.Getenv("DNSTAP_SOCKET")),
+		File:     strings.TrimSpace(os.Getenv("DNSTAP_FILE")),
+		Identity: strings.TrimSpace(os.Getenv("DNSTAP_IDENTITY")),
+	}
+	if config.Identity == "" {
+		config.Identity, _ = os.Hostname()
+	}
+	return config
+}
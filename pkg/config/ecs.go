@@ -0,0 +1,77 @@
+package config
+
+import (
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+)
+
+// Relay ECS modes control the EDNS Client Subnet option sent upstream
+const (
+	ECSStrip = "strip" // never send ECS upstream
+	ECSPass  = "pass"  // forward the client's ECS option as received
+	ECSAdd   = "add"   // forward trusted ECS, or add one built from the client address
+
+	DefaultECSIPv4Prefix = 24
+	DefaultECSIPv6Prefix = 56
+)
+
+// ECSConfig holds the EDNS Client Subnet (RFC 7871) settings.
+type ECSConfig struct {
+	// Trusted lists the clients, usually forwarding resolvers, whose ECS
+	// option is used in place of their own address to select a view.
+	Trusted    []netip.Prefix
+	RelayMode  string
+	IPv4Prefix int
+	IPv6Prefix int
+}
+
+// GetECSConfig returns the ECS configuration from DNS_ECS_TRUSTED,
+// DNS_RELAY_ECS, DNS_ECS_IPV4_PREFIX and DNS_ECS_IPV6_PREFIX.
+func GetECSConfig() ECSConfig {
+	config := ECSConfig{
+		RelayMode:  ECSStrip,
+		IPv4Prefix: getPrefixLen("DNS_ECS_IPV4_PREFIX", DefaultECSIPv4Prefix, 32),
+		IPv6Prefix: getPrefixLen("DNS_ECS_IPV6_PREFIX", DefaultECSIPv6Prefix, 128),
+	}
+
+	for _, network := range strings.Split(os.Getenv("DNS_ECS_TRUSTED"), ",") {
+		network = strings.TrimSpace(network)
+		if network == "" {
+			continue
+		}
+		prefix, err := ParseNetwork(network)
+		if err != nil {
+			logging.Warnf("Invalid network %q in DNS_ECS_TRUSTED: %v", network, err)
+			continue
+		}
+		config.Trusted = append(config.Trusted, prefix)
+	}
+
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("DNS_RELAY_ECS"))); mode {
+	case "":
+	case ECSStrip, ECSPass, ECSAdd:
+		config.RelayMode = mode
+	default:
+		logging.Warnf("Invalid DNS_RELAY_ECS %q, using %q", mode, ECSStrip)
+	}
+
+	return config
+}
+
+// getPrefixLen reads a prefix length between 0 and max from key.
+func getPrefixLen(key string, fallback, max int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	bits, err := strconv.Atoi(value)
+	if err != nil || bits < 0 || bits > max {
+		logging.Warnf("Invalid %s %q, using %d", key, value, fallback)
+		return fallback
+	}
+	return bits
+}
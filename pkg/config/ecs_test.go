@@ -0,0 +1,66 @@
+package config
+
+import (
+	"net/netip"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestGetECSConfig(t *testing.T) {
+	keys := []string{"DNS_ECS_TRUSTED", "DNS_RELAY_ECS", "DNS_ECS_IPV4_PREFIX", "DNS_ECS_IPV6_PREFIX"}
+	for _, key := range keys {
+		defer os.Setenv(key, os.Getenv(key))
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want ECSConfig
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{},
+			want: ECSConfig{RelayMode: ECSStrip, IPv4Prefix: 24, IPv6Prefix: 56},
+		},
+		{
+			name: "custom",
+			env: map[string]string{
+				"DNS_ECS_TRUSTED":     "192.0.2.53, 10.0.0.0/8",
+				"DNS_RELAY_ECS":       "ADD",
+				"DNS_ECS_IPV4_PREFIX": "20",
+				"DNS_ECS_IPV6_PREFIX": "48",
+			},
+			want: ECSConfig{
+				Trusted: []netip.Prefix{
+					netip.MustParsePrefix("192.0.2.53/32"),
+					netip.MustParsePrefix("10.0.0.0/8"),
+				},
+				RelayMode:  ECSAdd,
+				IPv4Prefix: 20,
+				IPv6Prefix: 48,
+			},
+		},
+		{
+			name: "invalid values",
+			env: map[string]string{
+				"DNS_ECS_TRUSTED":     "bogus",
+				"DNS_RELAY_ECS":       "always",
+				"DNS_ECS_IPV4_PREFIX": "33",
+				"DNS_ECS_IPV6_PREFIX": "-1",
+			},
+			want: ECSConfig{RelayMode: ECSStrip, IPv4Prefix: 24, IPv6Prefix: 56},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range keys {
+				os.Setenv(key, tt.env[key])
+			}
+			if got := GetECSConfig(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetECSConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+)
+
+// Protocols a listener serves
+const (
+	ProtocolUDP = "udp"
+	ProtocolTCP = "tcp"
+)
+
+// Listener is an address the DNS server answers on with one protocol.
+type Listener struct {
+	Name string // optional, selects per-listener settings such as RATE_LIMIT_<NAME>
+	Addr string // host:port, the host is empty for all addresses
+	Net  string // ProtocolUDP or ProtocolTCP
+}
+
+func (l Listener) String() string {
+	if l.Name != "" {
+		return l.Name + "=" + l.Addr + "/" + l.Net
+	}
+	return l.Addr + "/" + l.Net
+}
+
+// GetListeners returns the listeners from DNS_BIND, a comma-separated list of
+// addresses such as "127.0.0.53:53,[::1]:53,10.0.0.5/udp+tcp". Addresses
+// without a port use DNS_PORT, and those without protocols serve UDP only.
+// An address may be named, as in "lan=10.0.0.5", to give it its own settings.
+// An empty DNS_BIND listens on all addresses.
+func GetListeners() ([]Listener, error) {
+	port := GetDNSPort()
+	value := strings.TrimSpace(os.Getenv("DNS_BIND"))
+	if value == "" {
+		return []Listener{{Addr: net.JoinHostPort("", port), Net: ProtocolUDP}}, nil
+	}
+
+	var listeners []Listener
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parsed, err := parseListener(entry, port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listener %q in DNS_BIND: %w", entry, err)
+		}
+		for _, l := range parsed {
+			key := l.Addr + "/" + l.Net
+			if seen[key] {
+				return nil, fmt.Errorf("listener %s is given twice in DNS_BIND", key)
+			}
+			seen[key] = true
+			listeners = append(listeners, l)
+		}
+	}
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("DNS_BIND has no listeners")
+	}
+	return listeners, nil
+}
+
+// GetUDPSockets returns how many sockets each UDP listener opens from
+// DNS_REUSEPORT: "true" opens one per CPU (GOMAXPROCS) and a number opens
+// that many. The sockets share the address with SO_REUSEPORT, so the kernel
+// spreads queries over them. Empty or "false", the default, opens one.
+func GetUDPSockets() int {
+	value := strings.TrimSpace(os.Getenv("DNS_REUSEPORT"))
+	if value == "" {
+		return 1
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		if n < 0 {
+			logging.Warnf("Invalid DNS_REUSEPORT %q, using one socket", value)
+		}
+		return max(n, 1)
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		logging.Warnf("Invalid DNS_REUSEPORT %q, using one socket", value)
+		return 1
+	}
+	if enabled {
+		return runtime.GOMAXPROCS(0)
+	}
+	return 1
+}
+
+// parseListener parses "[name=]address[:port][/protocols]", where protocols
+// is "udp", "tcp" or "udp+tcp", into a listener per protocol.
+func parseListener(entry, defaultPort string) ([]Listener, error) {
+	var name string
+	if before, after, found := strings.Cut(entry, "="); found {
+		name, entry = strings.TrimSpace(before), strings.TrimSpace(after)
+		if !ValidListenerName(name) {
+			return nil, fmt.Errorf("invalid name %q, expected letters, digits and underscores", name)
+		}
+	}
+	addr, protocols, hasProtocols := strings.Cut(entry, "/")
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// A bare address, IPv6 ones possibly in brackets
+		host, port = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), defaultPort
+	}
+	if host != "" {
+		if _, err := netip.ParseAddr(host); err != nil {
+			return nil, fmt.Errorf("%q is not an IP address", host)
+		}
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	addr = net.JoinHostPort(host, port)
+
+	if !hasProtocols {
+		return []Listener{{Name: name, Addr: addr, Net: ProtocolUDP}}, nil
+	}
+	var listeners []Listener
+	for _, protocol := range strings.Split(protocols, "+") {
+		protocol = strings.ToLower(strings.TrimSpace(protocol))
+		if protocol != ProtocolUDP && protocol != ProtocolTCP {
+			return nil, fmt.Errorf("unknown protocol %q, expected udp or tcp", protocol)
+		}
+		l := Listener{Name: name, Addr: addr, Net: protocol}
+		for _, other := range listeners {
+			if other == l {
+				return nil, fmt.Errorf("protocol %s is given twice", protocol)
+			}
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// ValidListenerName reports whether name can be part of an environment
+// variable name.
+func ValidListenerName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestGetListeners(t *testing.T) {
+	udp := func(addr string) Listener { return Listener{Addr: addr, Net: ProtocolUDP} }
+	tcp := func(addr string) Listener { return Listener{Addr: addr, Net: ProtocolTCP} }
+
+	tests := []struct {
+		name    string
+		bind    string
+		want    []Listener
+		wantErr bool
+	}{
+		{"all addresses", "", []Listener{udp(":10053")}, false},
+		{"addresses with ports", "127.0.0.53:53,[::1]:53", []Listener{udp("127.0.0.53:53"), udp("[::1]:53")}, false},
+		{"addresses without ports", " 10.0.0.5 , ::1, [fe80::1]", []Listener{udp("10.0.0.5:10053"), udp("[::1]:10053"), udp("[fe80::1]:10053")}, false},
+		{"protocols", "10.0.0.5/udp+tcp,[::1]:53/tcp", []Listener{udp("10.0.0.5:10053"), tcp("10.0.0.5:10053"), tcp("[::1]:53")}, false},
+		{"all addresses with protocols", ":53/TCP+UDP", []Listener{tcp(":53"), udp(":53")}, false},
+		{"named", "lan=10.0.0.5/udp+tcp, public = :53", []Listener{
+			{Name: "lan", Addr: "10.0.0.5:10053", Net: ProtocolUDP},
+			{Name: "lan", Addr: "10.0.0.5:10053", Net: ProtocolTCP},
+			{Name: "public", Addr: ":53", Net: ProtocolUDP}}, false},
+		{"invalid name", "my-lan=10.0.0.5", nil, true},
+		{"empty name", "=10.0.0.5", nil, true},
+		{"named twice", "lan=10.0.0.5,wan=10.0.0.5", nil, true},
+		{"host name", "localhost:53", nil, true},
+		{"invalid port", "127.0.0.1:dns", nil, true},
+		{"port out of range", "127.0.0.1:65536", nil, true},
+		{"unknown protocol", "127.0.0.1/sctp", nil, true},
+		{"protocol given twice", "127.0.0.1/udp+udp", nil, true},
+		{"listener given twice", "127.0.0.1,127.0.0.1:10053/udp", nil, true},
+		{"only separators", ",", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DNS_PORT", "10053")
+			t.Setenv("DNS_BIND", tt.bind)
+
+			got, err := GetListeners()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetListeners() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetListeners() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetUDPSockets(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", 1},
+		{"false", 1},
+		{"true", runtime.GOMAXPROCS(0)},
+		{"4", 4},
+		{"1", 1},
+		{"0", 1},
+		{"-2", 1},
+		{"many", 1},
+	}
+	for _, tt := range tests {
+		t.Setenv("DNS_REUSEPORT", tt.value)
+		if got := GetUDPSockets(); got != tt.want {
+			t.Errorf("GetUDPSockets() with DNS_REUSEPORT=%q = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
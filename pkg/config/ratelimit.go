@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+)
+
+// Default client prefixes sharing the rate limits, as in BIND
+const (
+	DefaultRateLimitIPv4Prefix = 24
+	DefaultRateLimitIPv6Prefix = 56
+
+	DefaultRRLSlip = 2
+)
+
+// RateLimitConfig holds the rate limits of a listener. Clients are counted by
+// prefix, so a client can't escape them by spreading over its addresses.
+type RateLimitConfig struct {
+	// QueriesPerSecond is the rate of queries a client prefix may send, and
+	// QueryBurst how many more it may send at once. Queries over it are
+	// dropped. Zero disables the limit.
+	QueriesPerSecond float64
+	QueryBurst       int
+
+	// ResponsesPerSecond is the rate of identical UDP responses a client
+	// prefix is sent (response rate limiting, RRL). Every Slip-th response
+	// over it is sent truncated, so real clients retry over TCP, and the
+	// others are dropped. Zero disables the limit.
+	ResponsesPerSecond float64
+	Slip               int
+
+	IPv4Prefix int
+	IPv6Prefix int
+}
+
+// Enabled reports whether any limit is set.
+func (c RateLimitConfig) Enabled() bool {
+	return c.QueriesPerSecond > 0 || c.ResponsesPerSecond > 0
+}
+
+// GetRateLimitConfig returns the rate limits of the listener named name from
+// RATE_LIMIT and RRL, overridden by RATE_LIMIT_<NAME> and RRL_<NAME>, and
+// the client prefixes from RATE_LIMIT_IPV4_PREFIX and RATE_LIMIT_IPV6_PREFIX.
+// There are no limits by default.
+func GetRateLimitConfig(name string) RateLimitConfig {
+	config := RateLimitConfig{
+		IPv4Prefix: getPrefixLen("RATE_LIMIT_IPV4_PREFIX", DefaultRateLimitIPv4Prefix, 32),
+		IPv6Prefix: getPrefixLen("RATE_LIMIT_IPV6_PREFIX", DefaultRateLimitIPv6Prefix, 128),
+	}
+
+	if key, value := listenerSetting("RATE_LIMIT", name); value != "" {
+		qps, burst, err := parseRate(value, 0)
+		if err != nil {
+			logging.Warnf("Invalid %s %q, not limiting queries: %v", key, value, err)
+		} else {
+			config.QueriesPerSecond, config.QueryBurst = qps, burst
+			if qps > 0 && burst == 0 {
+				config.QueryBurst = max(int(qps), 1)
+			}
+		}
+	}
+
+	if key, value := listenerSetting("RRL", name); value != "" {
+		rps, slip, err := parseRate(value, DefaultRRLSlip)
+		if err != nil {
+			logging.Warnf("Invalid %s %q, not limiting responses: %v", key, value, err)
+		} else {
+			config.ResponsesPerSecond, config.Slip = rps, slip
+		}
+	}
+
+	return config
+}
+
+// listenerSetting returns key_<NAME> if it is set for the listener named name,
+// or else key, and its value.
+func listenerSetting(key, name string) (string, string) {
+	if name != "" {
+		override := key + "_" + strings.ToUpper(name)
+		if value, ok := os.LookupEnv(override); ok {
+			return override, strings.TrimSpace(value)
+		}
+	}
+	return key, strings.TrimSpace(os.Getenv(key))
+}
+
+// parseRate parses "rate[/n]", or "off" for no limit. n defaults to fallback.
+func parseRate(value string, fallback int) (float64, int, error) {
+	if strings.EqualFold(value, "off") {
+		return 0, 0, nil
+	}
+	rateValue, nValue, hasN := strings.Cut(value, "/")
+	rate, err := strconv.ParseFloat(strings.TrimSpace(rateValue), 64)
+	if err != nil || rate < 0 {
+		return 0, 0, fmt.Errorf("invalid rate %q", rateValue)
+	}
+	n := fallback
+	if hasN {
+		if n, err = strconv.Atoi(strings.TrimSpace(nValue)); err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid number %q", nValue)
+		}
+	}
+	if rate == 0 {
+		return 0, 0, nil
+	}
+	return rate, n, nil
+}
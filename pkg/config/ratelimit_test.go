@@ -0,0 +1,66 @@
+package config
+
+import "testing"
+
+func TestGetRateLimitConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		listener string
+		env      map[string]string
+		want     RateLimitConfig
+	}{
+		{
+			name: "no limits",
+			want: RateLimitConfig{IPv4Prefix: 24, IPv6Prefix: 56},
+		},
+		{
+			name: "defaults",
+			env:  map[string]string{"RATE_LIMIT": "20", "RRL": "5"},
+			want: RateLimitConfig{QueriesPerSecond: 20, QueryBurst: 20, ResponsesPerSecond: 5, Slip: 2, IPv4Prefix: 24, IPv6Prefix: 56},
+		},
+		{
+			name: "burst, slip and prefixes",
+			env: map[string]string{
+				"RATE_LIMIT":             "0.5/10",
+				"RRL":                    "10/0",
+				"RATE_LIMIT_IPV4_PREFIX": "32",
+				"RATE_LIMIT_IPV6_PREFIX": "64",
+			},
+			want: RateLimitConfig{QueriesPerSecond: 0.5, QueryBurst: 10, ResponsesPerSecond: 10, IPv4Prefix: 32, IPv6Prefix: 64},
+		},
+		{
+			name:     "listener overrides",
+			listener: "lan",
+			env:      map[string]string{"RATE_LIMIT": "20", "RRL": "5", "RATE_LIMIT_LAN": "off", "RRL_LAN": "50/3"},
+			want:     RateLimitConfig{ResponsesPerSecond: 50, Slip: 3, IPv4Prefix: 24, IPv6Prefix: 56},
+		},
+		{
+			name:     "other listener",
+			listener: "public",
+			env:      map[string]string{"RATE_LIMIT": "20/40", "RATE_LIMIT_LAN": "off"},
+			want:     RateLimitConfig{QueriesPerSecond: 20, QueryBurst: 40, IPv4Prefix: 24, IPv6Prefix: 56},
+		},
+		{
+			name: "invalid values",
+			env:  map[string]string{"RATE_LIMIT": "fast", "RRL": "5/-1", "RATE_LIMIT_IPV4_PREFIX": "33"},
+			want: RateLimitConfig{IPv4Prefix: 24, IPv6Prefix: 56},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"RATE_LIMIT", "RRL", "RATE_LIMIT_IPV4_PREFIX", "RATE_LIMIT_IPV6_PREFIX"} {
+				t.Setenv(key, tt.env[key])
+			}
+			for _, key := range []string{"RATE_LIMIT_LAN", "RRL_LAN"} {
+				if value, ok := tt.env[key]; ok {
+					t.Setenv(key, value)
+				}
+			}
+
+			if got := GetRateLimitConfig(tt.listener); got != tt.want {
+				t.Errorf("GetRateLimitConfig(%q) = %+v, want %+v", tt.listener, got, tt.want)
+			}
+		})
+	}
+}
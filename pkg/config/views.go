@@ -0,0 +1,77 @@
+package config
+
+import (
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mguptahub/nanodns/internal/logging"
+)
+
+// ViewPrefix is the environment variable prefix that defines a view
+const ViewPrefix = "VIEW_"
+
+// ViewConfig describes a split-horizon view: clients whose address falls in
+// one of Networks see the records scoped to the view.
+type ViewConfig struct {
+	Name     string
+	Networks []netip.Prefix
+}
+
+// GetViews returns the views defined through VIEW_<NAME>=cidr[,cidr...]
+// environment variables, sorted by name. View names are case-insensitive and
+// a bare IP address is treated as a single host network.
+func GetViews() []ViewConfig {
+	var views []ViewConfig
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(key, ViewPrefix) || len(key) == len(ViewPrefix) {
+			continue
+		}
+
+		view := ViewConfig{Name: strings.ToLower(strings.TrimPrefix(key, ViewPrefix))}
+		for _, network := range strings.Split(value, ",") {
+			network = strings.TrimSpace(network)
+			if network == "" {
+				continue
+			}
+			prefix, err := ParseNetwork(network)
+			if err != nil {
+				logging.Warnf("Invalid network %q in %s: %v", network, key, err)
+				continue
+			}
+			view.Networks = append(view.Networks, prefix)
+		}
+
+		if len(view.Networks) == 0 {
+			logging.Warnf("View %s has no valid networks and is ignored", view.Name)
+			continue
+		}
+		views = append(views, view)
+	}
+
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	return views
+}
+
+// ParseNetwork parses a CIDR prefix or a single IP address.
+func ParseNetwork(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"net/netip"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestGetViews(t *testing.T) {
+	defer os.Unsetenv("VIEW_INTERNAL")
+	defer os.Unsetenv("VIEW_OFFICE")
+	defer os.Unsetenv("VIEW_BROKEN")
+
+	os.Setenv("VIEW_INTERNAL", "10.0.0.0/8, 192.168.1.7")
+	os.Setenv("VIEW_OFFICE", "2001:db8::/32,not-a-network")
+	os.Setenv("VIEW_BROKEN", "300.0.0.0/8")
+
+	want := []ViewConfig{
+		{
+			Name: "internal",
+			Networks: []netip.Prefix{
+				netip.MustParsePrefix("10.0.0.0/8"),
+				netip.MustParsePrefix("192.168.1.7/32"),
+			},
+		},
+		{
+			Name:     "office",
+			Networks: []netip.Prefix{netip.MustParsePrefix("2001:db8::/32")},
+		},
+	}
+
+	if got := GetViews(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetViews() = %v, want %v", got, want)
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"10.1.2.3/8", "10.0.0.0/8", false},
+		{"10.1.2.3", "10.1.2.3/32", false},
+		{"2001:db8::1", "2001:db8::1/128", false},
+		{"::ffff:10.0.0.0/104", "10.0.0.0/8", false},
+		{"10.0.0.0/33", "", true},
+		{"example.com", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseNetwork(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseNetwork(%q) error = nil, want error", tt.value)
+				}
+				return
+			}
+			if err != nil || got.String() != tt.want {
+				t.Errorf("ParseNetwork(%q) = %v, %v, want %s", tt.value, got, err, tt.want)
+			}
+		})
+	}
+}